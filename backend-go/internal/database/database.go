@@ -53,19 +53,43 @@ func Initialize(cfg *config.Config) (*Database, error) {
 	return &Database{DB: db}, nil
 }
 
+// PolicySearchVector is the full-text search document of a policy. Queries must use
+// the same expression for Postgres to use the GIN index created in migrate.
+const PolicySearchVector = "to_tsvector('english', coalesce(name, '') || ' ' || coalesce(description, '') || ' ' || coalesce(content, ''))"
+
 func migrate(db *gorm.DB) error {
-	return db.AutoMigrate(
+	err := db.AutoMigrate(
 		&models.User{},
 		&models.Organization{},
 		&models.UserOrganizationRole{},
 		&models.Policy{},
 		&models.PolicyTemplate{},
 		&models.PolicyEvaluation{},
+		&models.PolicyTestCase{},
+		&models.PolicyVersion{},
+		&models.PolicyReview{},
+		&models.PolicySource{},
+		&models.PolicySync{},
+		&models.PolicyExport{},
+		&models.PolicyShadow{},
+		&models.PolicyReplay{},
+		&models.PolicyReplayFlip{},
+		&models.PolicyException{},
+		&models.PolicyData{},
+		&models.PolicyDataVersion{},
+		&models.PolicyDataSchema{},
+		&models.DataSource{},
+		&models.DataSourceFetch{},
 		&models.ComplianceFramework{},
 		&models.ComplianceControl{},
 		&models.PolicyComplianceMapping{},
 		&models.ComplianceReport{},
 	)
+	if err != nil {
+		return err
+	}
+
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_policies_search ON policies USING GIN (" + PolicySearchVector + ")").Error
 }
 
 func (d *Database) Close() error {
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BundleHandler struct {
+	service *services.BundleService
+}
+
+func NewBundleHandler(service *services.BundleService) *BundleHandler {
+	return &BundleHandler{service: service}
+}
+
+// GetBundle serves an organization's active policies as an OPA bundle tarball
+func (h *BundleHandler) GetBundle(c *gin.Context) {
+	b, err := h.service.GetBundle(c.Param("org"))
+	if err != nil {
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	etag := b.ETag()
+	c.Header("ETag", etag)
+
+	// Polling agents send the last revision they loaded
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Header("Content-Type", "application/gzip")
+	c.Status(http.StatusOK)
+	if err := b.Write(c.Writer); err != nil {
+		c.Error(err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPublicKeys publishes the keys OPA agents use to verify signed bundles
+func (h *BundleHandler) GetPublicKeys(c *gin.Context) {
+	keys, err := h.service.PublicKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
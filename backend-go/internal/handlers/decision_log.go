@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DecisionLogHandler struct {
+	service *services.DecisionLogService
+}
+
+func NewDecisionLogHandler(service *services.DecisionLogService) *DecisionLogHandler {
+	return &DecisionLogHandler{service: service}
+}
+
+// IngestDecisionLogs receives the decision log uploads of an organization's OPA agents
+func (h *DecisionLogHandler) IngestDecisionLogs(c *gin.Context) {
+	if maxBytes := h.service.MaxUploadBytes(); maxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	}
+
+	// OPA's decision_logs plugin gzips every upload
+	gzipped := strings.EqualFold(c.GetHeader("Content-Encoding"), "gzip")
+	entries, err := h.service.ReadUpload(c.Request.Body, gzipped)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.Is(err, services.ErrDecisionLogTooLarge), errors.As(err, &tooLarge):
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidDecisionLog):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	result, err := h.service.Ingest(c.Param("org"), entries)
+	if err != nil {
+		if errors.Is(err, services.ErrOrganizationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
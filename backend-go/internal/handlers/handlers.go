@@ -5,25 +5,29 @@ import (
 )
 
 type Handlers struct {
-	Health     *HealthHandler
-	Auth       *AuthHandler
-	Policy     *PolicyHandler
-	Template   *TemplateHandler
-	Compliance *ComplianceHandler
-	AI         *AIHandler
-	Monitoring *MonitoringHandler
-	User       *UserHandler
+	Health      *HealthHandler
+	Auth        *AuthHandler
+	Policy      *PolicyHandler
+	Template    *TemplateHandler
+	Compliance  *ComplianceHandler
+	AI          *AIHandler
+	Monitoring  *MonitoringHandler
+	User        *UserHandler
+	Bundle      *BundleHandler
+	DecisionLog *DecisionLogHandler
 }
 
 func NewHandlers(services *services.Services) *Handlers {
 	return &Handlers{
-		Health:     NewHealthHandler(),
-		Auth:       NewAuthHandler(services.Auth),
-		Policy:     NewPolicyHandler(services.Policy),
-		Template:   NewTemplateHandler(services.Template),
-		Compliance: NewComplianceHandler(services.Compliance),
-		AI:         NewAIHandler(services.AI),
-		Monitoring: NewMonitoringHandler(services.Monitoring),
-		User:       NewUserHandler(services.User),
+		Health:      NewHealthHandler(),
+		Auth:        NewAuthHandler(services.Auth),
+		Policy:      NewPolicyHandler(services.Policy),
+		Template:    NewTemplateHandler(services.Template),
+		Compliance:  NewComplianceHandler(services.Compliance),
+		AI:          NewAIHandler(services.AI),
+		Monitoring:  NewMonitoringHandler(services.Monitoring),
+		User:        NewUserHandler(services.User),
+		Bundle:      NewBundleHandler(services.Bundle),
+		DecisionLog: NewDecisionLogHandler(services.DecisionLog),
 	}
 }
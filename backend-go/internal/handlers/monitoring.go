@@ -47,6 +47,15 @@ func (h *MonitoringHandler) GetMetrics(c *gin.Context) {
 }
 
 func (h *MonitoringHandler) GetAlerts(c *gin.Context) {
+	// For development, use mock org data
+	orgID := uint(1)
+
+	sourceAlerts, err := h.service.DataSourceAlerts(orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Mock alerts data
 	alerts := []gin.H{
 		{
@@ -78,6 +87,18 @@ func (h *MonitoringHandler) GetAlerts(c *gin.Context) {
 		alerts = alerts[:2] // Return only first 2 alerts
 	}
 
+	// Failing data sources come first
+	for i := len(sourceAlerts) - 1; i >= 0; i-- {
+		alert := sourceAlerts[i]
+		alerts = append([]gin.H{{
+			"id":        alert.ID,
+			"type":      alert.Type,
+			"message":   alert.Message,
+			"timestamp": alert.Timestamp,
+			"severity":  alert.Severity,
+		}}, alerts...)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"alerts": alerts,
 		"total":  len(alerts),
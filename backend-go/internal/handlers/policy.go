@@ -1,14 +1,20 @@
 package handlers
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"niyama-backend/internal/models"
 	"niyama-backend/internal/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
 type PolicyHandler struct {
@@ -26,18 +32,69 @@ func (h *PolicyHandler) GetPolicies(c *gin.Context) {
 	orgID := uint(1)
 	userRole := models.RoleAdmin
 
-	policies, err := h.service.GetPolicies(userID, orgID, userRole)
+	query := services.PolicyQuery{
+		Search:      c.Query("q"),
+		Tags:        queryList(c, "tags"),
+		Category:    c.Query("category"),
+		Status:      c.Query("status"),
+		Language:    c.Query("language"),
+		AccessLevel: c.Query("access_level"),
+		Sort:        c.Query("sort"),
+		Desc:        c.Query("order") == "desc",
+		Cursor:      c.Query("cursor"),
+	}
+	if query.Search == "" {
+		query.Search = c.Query("search")
+	}
+	if author := c.Query("author"); author != "" {
+		authorID, err := strconv.ParseUint(author, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid author ID"})
+			return
+		}
+		query.AuthorID = uint(authorID)
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		query.Limit = n
+	}
+
+	page, err := h.service.GetPolicies(userID, orgID, userRole, query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidPolicyQuery) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"policies": policies,
-		"count":    len(policies),
+		"policies":    page.Policies,
+		"count":       len(page.Policies),
+		"total":       page.Total,
+		"next_cursor": page.NextCursor,
+		"facets":      page.Facets,
 	})
 }
 
+// queryList reads a list query parameter given repeatedly or comma-separated
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, param := range c.QueryArray(key) {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
 // GetPolicy retrieves a specific policy
 func (h *PolicyHandler) GetPolicy(c *gin.Context) {
 	policyIDStr := c.Param("id")
@@ -75,7 +132,10 @@ func (h *PolicyHandler) CreatePolicy(c *gin.Context) {
 
 	err := h.service.CreatePolicy(&policy, userID, orgID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if respondValidationError(c, err) {
+			return
+		}
+		c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -95,22 +155,57 @@ func (h *PolicyHandler) UpdatePolicy(c *gin.Context) {
 	}
 
 	var updates models.Policy
-	if err := c.ShouldBindJSON(&updates); err != nil {
+	if err := c.ShouldBindBodyWith(&updates, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Fields that may be cleared are written whenever the request sets them
+	var fields map[string]json.RawMessage
+	if err := c.ShouldBindBodyWith(&fields, binding.JSON); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var columns []string
+	for _, field := range []string{"description", "category", "require_tests"} {
+		if _, ok := fields[field]; ok {
+			columns = append(columns, field)
+		}
+	}
 
 	// For development, use mock user and org data
 	userID := uint(1)
 	orgID := uint(1)
 	userRole := models.RoleAdmin
 
-	err = h.service.UpdatePolicy(uint(policyID), &updates, userID, orgID, userRole)
+	err = h.service.UpdatePolicy(uint(policyID), &updates, userID, orgID, userRole, columns...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if respondValidationError(c, err) {
+			return
+		}
+		if respondTestFailure(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrNoTestCases) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(reviewErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
+	// Archiving is allowed, but warns about active policies that still depend on this one
+	if updates.Status == models.StatusArchived {
+		dependents, err := h.service.GetDependents(uint(policyID), userID, orgID, userRole)
+		if err == nil && len(dependents) > 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"message":    "Policy updated successfully",
+				"warning":    "active policies depend on this policy",
+				"dependents": dependents,
+			})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Policy updated successfully"})
 }
 
@@ -128,8 +223,19 @@ func (h *PolicyHandler) DeletePolicy(c *gin.Context) {
 	orgID := uint(1)
 	userRole := models.RoleAdmin
 
-	err = h.service.DeletePolicy(uint(policyID), userID, orgID, userRole)
+	// Policies that active policies depend on are only deleted with ?force=true
+	force := c.Query("force") == "true"
+
+	err = h.service.DeletePolicy(uint(policyID), userID, orgID, userRole, force)
 	if err != nil {
+		var dependentsErr *services.DependentsError
+		if errors.As(err, &dependentsErr) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":      err.Error(),
+				"dependents": dependentsErr.Dependents,
+			})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -194,6 +300,11 @@ func (h *PolicyHandler) SavePolicy(c *gin.Context) {
 		policy.Status = models.StatusDraft
 	}
 
+	if diagnostics := h.service.ValidatePolicy(&policy); len(diagnostics) > 0 {
+		respondValidationError(c, &services.ValidationError{Diagnostics: diagnostics})
+		return
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Policy saved successfully",
 		"policy":  policy,
@@ -228,3 +339,261 @@ func (h *PolicyHandler) TestPolicy(c *gin.Context) {
 		"evaluation": evaluation,
 	})
 }
+
+// GetEvaluations returns the evaluation history of a policy
+func (h *PolicyHandler) GetEvaluations(c *gin.Context) {
+	policyIDStr := c.Param("id")
+	policyID, err := strconv.ParseUint(policyIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	filter := services.EvaluationFilter{
+		Decision:       c.Query("decision"),
+		Source:         c.Query("source"),
+		BundleRevision: c.Query("bundle_revision"),
+	}
+
+	// Parse time range
+	if filter.From, err = parseTimeQuery(c, "from"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if filter.To, err = parseTimeQuery(c, "to"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Parse pagination parameters
+	filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 50
+	}
+
+	filter.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	evaluations, total, err := h.service.GetEvaluations(uint(policyID), userID, orgID, userRole, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"evaluations": evaluations,
+		"meta": gin.H{
+			"total":  total,
+			"limit":  filter.Limit,
+			"offset": filter.Offset,
+		},
+	})
+}
+
+// parseTimeQuery parses an optional RFC3339 query parameter
+func parseTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s time, expected RFC3339", name)
+	}
+	return &t, nil
+}
+
+// EvaluatePolicyBatch evaluates a JSON array or NDJSON stream of inputs against a policy
+func (h *PolicyHandler) EvaluatePolicyBatch(c *gin.Context) {
+	policyIDStr := c.Param("id")
+	policyID, err := strconv.ParseUint(policyIDStr, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	maxInputs, maxBytes := h.service.BatchLimits()
+	if maxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	}
+	inputs, err := readBatchInputs(c, maxInputs)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.Is(err, services.ErrBatchTooLarge) || errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	batch, err := h.service.EvaluateBatch(uint(policyID), inputs, userID, orgID, userRole)
+	if err != nil {
+		if errors.Is(err, services.ErrBatchTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Batch evaluation completed",
+		"policy_id": batch.PolicyID,
+		"results":   batch.Results,
+		"summary":   batch.Summary,
+	})
+}
+
+// readBatchInputs decodes batch inputs from an NDJSON body (one object per line)
+// or a JSON array body, depending on the request content type. Reading stops with
+// ErrBatchTooLarge as soon as the body holds more than maxInputs inputs.
+func readBatchInputs(c *gin.Context, maxInputs int) ([]map[string]interface{}, error) {
+	var inputs []map[string]interface{}
+	tooMany := func() error {
+		return fmt.Errorf("%w: more than %d inputs", services.ErrBatchTooLarge, maxInputs)
+	}
+
+	switch c.ContentType() {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		scanner := bufio.NewScanner(c.Request.Body)
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			if maxInputs > 0 && len(inputs) == maxInputs {
+				return nil, tooMany()
+			}
+			var input map[string]interface{}
+			if err := json.Unmarshal([]byte(text), &input); err != nil {
+				// The scanner hands out the partial last line of a body cut off by the size limit
+				if readErr := scanner.Err(); readErr != nil {
+					return nil, fmt.Errorf("failed to read request body: %w", readErr)
+				}
+				return nil, fmt.Errorf("invalid JSON object on line %d: %v", line, err)
+			}
+			inputs = append(inputs, input)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	default:
+		// Decode the array element by element so an oversized batch is rejected early
+		decoder := json.NewDecoder(c.Request.Body)
+		if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+			return nil, batchArrayError(err)
+		}
+		for decoder.More() {
+			if maxInputs > 0 && len(inputs) == maxInputs {
+				return nil, tooMany()
+			}
+			var input map[string]interface{}
+			if err := decoder.Decode(&input); err != nil {
+				return nil, batchArrayError(err)
+			}
+			inputs = append(inputs, input)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, batchArrayError(err)
+		}
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no inputs provided")
+	}
+	return inputs, nil
+}
+
+// batchArrayError describes a body that is not a JSON array of input objects
+func batchArrayError(err error) error {
+	if err == nil {
+		return fmt.Errorf("expected a JSON array of input objects")
+	}
+	return fmt.Errorf("expected a JSON array of input objects: %w", err)
+}
+
+// ValidatePolicy compiles policy content and returns its diagnostics without saving it
+func (h *PolicyHandler) ValidatePolicy(c *gin.Context) {
+	var policy models.Policy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	diagnostics := h.service.ValidatePolicy(&policy)
+	c.JSON(http.StatusOK, gin.H{
+		"valid":       len(diagnostics) == 0,
+		"diagnostics": diagnostics,
+	})
+}
+
+// respondValidationError writes a 422 with the diagnostics of a ValidationError
+// and reports whether err was one
+func respondValidationError(c *gin.Context, err error) bool {
+	var validationErr *services.ValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":       err.Error(),
+		"diagnostics": validationErr.Diagnostics,
+	})
+	return true
+}
+
+// GetDependencyGraph returns the dependency graph of the organization's policies
+func (h *PolicyHandler) GetDependencyGraph(c *gin.Context) {
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	graph, err := h.service.GetDependencyGraph(userID, orgID, userRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, graph)
+}
+
+// GetDependents lists the active policies that depend on a policy
+func (h *PolicyHandler) GetDependents(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	dependents, err := h.service.GetDependents(uint(policyID), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dependents": dependents,
+		"count":      len(dependents),
+	})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ValidateAdmission answers the AdmissionReview requests of a Kubernetes
+// ValidatingAdmissionWebhook with the decision of an organization's active policies
+func (h *PolicyHandler) ValidateAdmission(c *gin.Context) {
+	var review services.AdmissionReview
+	if err := c.ShouldBindJSON(&review); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.service.ReviewAdmission(c.Param("org"), &review)
+	if err != nil {
+		c.JSON(admissionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// admissionErrorStatus maps admission errors to HTTP status codes
+func admissionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidAdmissionReview):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrOrganizationNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// MutateAdmission answers the AdmissionReview requests of a Kubernetes
+// MutatingAdmissionWebhook with the JSONPatch of an organization's active policies
+func (h *PolicyHandler) MutateAdmission(c *gin.Context) {
+	var review services.AdmissionReview
+	if err := c.ShouldBindJSON(&review); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := h.service.MutateAdmission(c.Param("org"), &review)
+	if err != nil {
+		c.JSON(admissionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// MutatePolicy previews the JSONPatch a policy's patch rule produces for an object
+func (h *PolicyHandler) MutatePolicy(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	var object map[string]interface{}
+	if err := c.ShouldBindJSON(&object); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	result, err := h.service.MutatePolicy(uint(policyID), object, userID, orgID, userRole)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, services.ErrInvalidPatch) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
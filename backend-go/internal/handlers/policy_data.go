@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDataDocuments lists the organization's data documents, optionally under a path prefix
+func (h *PolicyHandler) GetDataDocuments(c *gin.Context) {
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	documents, err := h.service.GetDataDocuments(c.Query("prefix"), orgID)
+	if err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents": documents,
+		"count":     len(documents),
+	})
+}
+
+// GetDataDocument retrieves the data document at a path
+func (h *PolicyHandler) GetDataDocument(c *gin.Context) {
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	document, err := h.service.GetDataDocument(c.Param("path"), orgID)
+	if err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"document": document})
+}
+
+// PutDataDocument creates or replaces the data document at a path with the JSON request body
+func (h *PolicyHandler) PutDataDocument(c *gin.Context) {
+	var value interface{}
+	if err := json.NewDecoder(c.Request.Body).Decode(&value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body must be a JSON document: " + err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	document, created, err := h.service.PutDataDocument(c.Param("path"), value, c.Query("message"), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"message":  "Data document saved successfully",
+		"document": document,
+	})
+}
+
+// DeleteDataDocument removes the data document at a path
+func (h *PolicyHandler) DeleteDataDocument(c *gin.Context) {
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.DeleteDataDocument(c.Param("path"), c.Query("message"), userID, orgID, userRole); err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Data document deleted successfully"})
+}
+
+// GetDataVersions lists the versions of the data document at a path, or returns the
+// one selected with the version query parameter
+func (h *PolicyHandler) GetDataVersions(c *gin.Context) {
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	if value := c.Query("version"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+			return
+		}
+		result, err := h.service.GetDataVersion(c.Param("path"), version, orgID)
+		if err != nil {
+			c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"version": result})
+		return
+	}
+
+	versions, err := h.service.GetDataVersions(c.Param("path"), orgID)
+	if err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"versions": versions,
+		"count":    len(versions),
+	})
+}
+
+// GetDataSchemas lists the organization's data schemas
+func (h *PolicyHandler) GetDataSchemas(c *gin.Context) {
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	schemas, err := h.service.GetDataSchemas(orgID)
+	if err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schemas": schemas,
+		"count":   len(schemas),
+	})
+}
+
+// PutDataSchema sets the JSON Schema and size limit of the data documents at or below a path
+func (h *PolicyHandler) PutDataSchema(c *gin.Context) {
+	var req struct {
+		Schema   map[string]interface{} `json:"schema"`
+		MaxBytes int                    `json:"max_bytes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	schema, err := h.service.PutDataSchema(c.Param("path"), req.Schema, req.MaxBytes, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Data schema saved successfully",
+		"schema":  schema,
+	})
+}
+
+// DeleteDataSchema removes the data schema of a path
+func (h *PolicyHandler) DeleteDataSchema(c *gin.Context) {
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.DeleteDataSchema(c.Param("path"), userID, orgID, userRole); err != nil {
+		c.JSON(dataErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Data schema deleted successfully"})
+}
+
+// dataErrorStatus maps data document errors to HTTP status codes
+func dataErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDataNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidDataPath), errors.Is(err, services.ErrInvalidDataSchema):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrDataConflict):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrDataTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, services.ErrInvalidData):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDataSources lists the organization's external data sources
+func (h *PolicyHandler) GetDataSources(c *gin.Context) {
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	sources, err := h.service.GetDataSources(orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sources": sources,
+		"count":   len(sources),
+	})
+}
+
+// GetDataSource retrieves a data source
+func (h *PolicyHandler) GetDataSource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	source, err := h.service.GetDataSource(uint(sourceID), orgID)
+	if err != nil {
+		c.JSON(dataSourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"source": source})
+}
+
+// CreateDataSource registers an HTTP JSON endpoint to poll into a data document
+func (h *PolicyHandler) CreateDataSource(c *gin.Context) {
+	var source models.DataSource
+	if err := c.ShouldBindJSON(&source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.CreateDataSource(&source, userID, orgID, userRole); err != nil {
+		c.JSON(dataSourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Data source created successfully",
+		"source":  source,
+	})
+}
+
+// UpdateDataSource changes the name, URL, path or interval of a data source
+func (h *PolicyHandler) UpdateDataSource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	var updates models.DataSource
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	source, err := h.service.UpdateDataSource(uint(sourceID), &updates, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(dataSourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Data source updated successfully",
+		"source":  source,
+	})
+}
+
+// DeleteDataSource stops polling a data source, keeping the document it wrote
+func (h *PolicyHandler) DeleteDataSource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.DeleteDataSource(uint(sourceID), userID, orgID, userRole); err != nil {
+		c.JSON(dataSourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Data source deleted successfully"})
+}
+
+// FetchDataSource polls a data source now
+func (h *PolicyHandler) FetchDataSource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	fetch, err := h.service.FetchDataSource(uint(sourceID), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(dataSourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"fetch": fetch})
+}
+
+// GetDataSourceFetches lists the fetch history of a data source
+func (h *PolicyHandler) GetDataSourceFetches(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	fetches, err := h.service.GetDataSourceFetches(uint(sourceID), orgID)
+	if err != nil {
+		c.JSON(dataSourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"fetches": fetches,
+		"count":   len(fetches),
+	})
+}
+
+// dataSourceErrorStatus maps data source errors to HTTP status codes
+func dataSourceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDataSourceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidDataSource):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
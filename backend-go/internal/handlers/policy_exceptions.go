@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetExceptions lists the exceptions of a policy
+func (h *PolicyHandler) GetExceptions(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	exceptions, err := h.service.GetExceptions(uint(policyID), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exceptions": exceptions,
+		"count":      len(exceptions),
+	})
+}
+
+// CreateException requests a time-bound exception to a policy
+func (h *PolicyHandler) CreateException(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	var exception models.PolicyException
+	if err := c.ShouldBindJSON(&exception); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.CreateException(uint(policyID), &exception, userID, orgID, userRole); err != nil {
+		c.JSON(exceptionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "Exception requested, it applies once approved",
+		"exception": exception,
+	})
+}
+
+// ApproveException approves a pending exception
+func (h *PolicyHandler) ApproveException(c *gin.Context) {
+	policyID, exceptionID, ok := parseExceptionParams(c)
+	if !ok {
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	exception, err := h.service.ApproveException(policyID, exceptionID, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(exceptionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Exception approved",
+		"exception": exception,
+	})
+}
+
+// RevokeException revokes an exception before it expires
+func (h *PolicyHandler) RevokeException(c *gin.Context) {
+	policyID, exceptionID, ok := parseExceptionParams(c)
+	if !ok {
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.RevokeException(policyID, exceptionID, userID, orgID, userRole); err != nil {
+		c.JSON(exceptionErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exception revoked"})
+}
+
+// GetExpiringExceptions lists the organization's exceptions expiring within a duration
+func (h *PolicyHandler) GetExpiringExceptions(c *gin.Context) {
+	within, err := parseWithin(c.DefaultQuery("within", "7d"))
+	if err != nil || within <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "within must be a positive duration such as 72h or 7d"})
+		return
+	}
+
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	exceptions, err := h.service.GetExpiringExceptions(within, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exceptions": exceptions,
+		"count":      len(exceptions),
+		"within":     within.String(),
+	})
+}
+
+// parseWithin parses a duration, accepting days such as "7d"
+func parseWithin(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(value)
+}
+
+// parseExceptionParams parses the policy and exception IDs of an exception route
+func parseExceptionParams(c *gin.Context) (uint, uint, bool) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return 0, 0, false
+	}
+	exceptionID, err := strconv.ParseUint(c.Param("exceptionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exception ID"})
+		return 0, 0, false
+	}
+	return uint(policyID), uint(exceptionID), true
+}
+
+// exceptionErrorStatus maps exception errors to HTTP status codes
+func exceptionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrExceptionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidException):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrExceptionNotAllowed):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
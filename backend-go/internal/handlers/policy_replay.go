@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartReplay queues a replay of stored inputs of a policy against draft content
+func (h *PolicyHandler) StartReplay(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	var request struct {
+		Content string     `json:"content" binding:"required"`
+		Limit   int        `json:"limit"`
+		From    *time.Time `json:"from"`
+		To      *time.Time `json:"to"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	replay, err := h.service.StartReplay(uint(policyID), services.ReplayRequest{
+		Content: request.Content,
+		Limit:   request.Limit,
+		From:    request.From,
+		To:      request.To,
+	}, userID, orgID, userRole)
+	if err != nil {
+		if respondValidationError(c, err) {
+			return
+		}
+		c.JSON(replayErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Replay started",
+		"replay":  replay,
+	})
+}
+
+// GetReplays lists the replays of a policy
+func (h *PolicyHandler) GetReplays(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	replays, err := h.service.GetReplays(uint(policyID), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"replays": replays,
+		"count":   len(replays),
+	})
+}
+
+// GetReplay retrieves the progress and outcome counts of a replay
+func (h *PolicyHandler) GetReplay(c *gin.Context) {
+	policyID, replayID, ok := parseReplayParams(c)
+	if !ok {
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	replay, err := h.service.GetReplay(policyID, replayID, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(replayErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"replay": replay})
+}
+
+// GetReplayFlips lists the inputs whose decision the draft of a replay changes
+func (h *PolicyHandler) GetReplayFlips(c *gin.Context) {
+	policyID, replayID, ok := parseReplayParams(c)
+	if !ok {
+		return
+	}
+
+	filter := services.ReplayFlipFilter{Change: c.Query("change")}
+	switch filter.Change {
+	case "", models.ReplayFlipAllowToDeny, models.ReplayFlipDenyToAllow:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "change must be allow_to_deny or deny_to_allow"})
+		return
+	}
+
+	// Parse pagination parameters
+	var err error
+	filter.Limit, err = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || filter.Limit <= 0 || filter.Limit > 1000 {
+		filter.Limit = 50
+	}
+
+	filter.Offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || filter.Offset < 0 {
+		filter.Offset = 0
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	flips, total, err := h.service.GetReplayFlips(policyID, replayID, userID, orgID, userRole, filter)
+	if err != nil {
+		c.JSON(replayErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"flips": flips,
+		"meta": gin.H{
+			"total":  total,
+			"limit":  filter.Limit,
+			"offset": filter.Offset,
+		},
+	})
+}
+
+// parseReplayParams parses the policy and replay IDs of a replay route
+func parseReplayParams(c *gin.Context) (uint, uint, bool) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return 0, 0, false
+	}
+	replayID, err := strconv.ParseUint(c.Param("replayId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid replay ID"})
+		return 0, 0, false
+	}
+	return uint(policyID), uint(replayID), true
+}
+
+// replayErrorStatus maps replay errors to HTTP status codes
+func replayErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrReplayNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidReplay):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
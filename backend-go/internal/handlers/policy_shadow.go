@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartShadow runs candidate content, or a stored version, in shadow alongside a policy
+func (h *PolicyHandler) StartShadow(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	var request struct {
+		Content string `json:"content"`
+		Version int    `json:"version"`
+		Message string `json:"message"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Content == "" && request.Version == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "content or version is required"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	shadow, err := h.service.StartShadow(uint(policyID), request.Content, request.Version, request.Message, userID, orgID, userRole)
+	if err != nil {
+		if respondValidationError(c, err) {
+			return
+		}
+		c.JSON(shadowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Shadow candidate started",
+		"shadow":  shadow,
+	})
+}
+
+// GetShadow retrieves the running shadow candidate of a policy
+func (h *PolicyHandler) GetShadow(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	shadow, err := h.service.GetShadow(uint(policyID), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(shadowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shadow": shadow})
+}
+
+// StopShadow stops the shadow candidate of a policy
+func (h *PolicyHandler) StopShadow(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.StopShadow(uint(policyID), userID, orgID, userRole); err != nil {
+		c.JSON(shadowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Shadow candidate stopped"})
+}
+
+// GetShadowReport summarizes how often the shadow candidate disagreed with the policy
+func (h *PolicyHandler) GetShadowReport(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	var shadowID uint64
+	if value := c.Query("shadow_id"); value != "" {
+		if shadowID, err = strconv.ParseUint(value, 10, 32); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shadow ID"})
+			return
+		}
+	}
+	samples, err := strconv.Atoi(c.DefaultQuery("samples", "10"))
+	if err != nil || samples < 0 || samples > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "samples must be between 0 and 100"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	report, err := h.service.GetShadowReport(uint(policyID), uint(shadowID), samples, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(shadowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// shadowErrorStatus maps missing shadows and versions to 404
+func shadowErrorStatus(err error) int {
+	if errors.Is(err, services.ErrNoShadow) || errors.Is(err, services.ErrVersionNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
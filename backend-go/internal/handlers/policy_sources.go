@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPolicySources lists the organization's git policy sources
+func (h *PolicyHandler) GetPolicySources(c *gin.Context) {
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	sources, err := h.service.GetPolicySources(orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sources": sources,
+		"count":   len(sources),
+	})
+}
+
+// GetPolicySource retrieves a policy source
+func (h *PolicyHandler) GetPolicySource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	source, err := h.service.GetPolicySource(uint(sourceID), orgID)
+	if err != nil {
+		c.JSON(sourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"source": source})
+}
+
+// CreatePolicySource adds a git repository, branch and path to sync policies from
+func (h *PolicyHandler) CreatePolicySource(c *gin.Context) {
+	var source models.PolicySource
+	if err := c.ShouldBindJSON(&source); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.CreatePolicySource(&source, userID, orgID, userRole); err != nil {
+		c.JSON(sourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Policy source created successfully",
+		"source":  source,
+	})
+}
+
+// UpdatePolicySource changes the repository or branch of a source and replaces its path and export mode
+func (h *PolicyHandler) UpdatePolicySource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	var updates models.PolicySource
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	source, err := h.service.UpdatePolicySource(uint(sourceID), &updates, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(sourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Policy source updated successfully",
+		"source":  source,
+	})
+}
+
+// DeletePolicySource removes a policy source, keeping the policies synced from it
+func (h *PolicyHandler) DeletePolicySource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	if err := h.service.DeletePolicySource(uint(sourceID), userID, orgID, userRole); err != nil {
+		c.JSON(sourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Policy source deleted successfully"})
+}
+
+// SyncPolicySource imports the policies of a source at the head of its branch
+func (h *PolicyHandler) SyncPolicySource(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	sync, err := h.service.SyncPolicySource(uint(sourceID), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(sourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sync": sync})
+}
+
+// GetPolicySyncs lists the sync history of a source
+func (h *PolicyHandler) GetPolicySyncs(c *gin.Context) {
+	sourceID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid source ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	orgID := uint(1)
+
+	syncs, err := h.service.GetPolicySyncs(uint(sourceID), orgID)
+	if err != nil {
+		c.JSON(sourceErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"syncs": syncs,
+		"count": len(syncs),
+	})
+}
+
+// GetPolicyExports lists the commits of a policy to the organization's policy sources
+func (h *PolicyHandler) GetPolicyExports(c *gin.Context) {
+	policyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	exports, err := h.service.GetPolicyExports(uint(policyID), userID, orgID, userRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exports": exports,
+		"count":   len(exports),
+	})
+}
+
+// sourceErrorStatus maps policy source errors to HTTP status codes
+func sourceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrPolicySourceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrInvalidPolicySource):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrSourceSyncFailed):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
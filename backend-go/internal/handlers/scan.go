@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"niyama-backend/internal/models"
+	"niyama-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScanTerraformPlan evaluates the resource changes of a `terraform show -json`
+// plan against the selected policies
+func (h *PolicyHandler) ScanTerraformPlan(c *gin.Context) {
+	selection, ok := parseScanSelection(c)
+	if !ok {
+		return
+	}
+
+	// Plans are capped like batch evaluations, whose inputs they become
+	if _, maxBytes := h.service.BatchLimits(); maxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+	}
+	data, err := c.GetRawData()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	options := services.TerraformScanOptions{
+		ScanSelection: selection,
+		IncludePlan:   c.Query("plan") == "true",
+		IncludeNoOp:   c.Query("no_op") == "true",
+	}
+	result, err := h.service.ScanTerraformPlan(data, options, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(scanErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ScanFiles evaluates uploaded files against the selected policies. Files are sent
+// as multipart "file" fields; their format is detected unless the "format" field or
+// query parameter names it.
+func (h *PolicyHandler) ScanFiles(c *gin.Context) {
+	selection, ok := parseScanSelection(c)
+	if !ok {
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.PostForm("format")
+	if format == "" {
+		format = c.Query("format")
+	}
+
+	var files []services.ScanFile
+	for _, header := range form.File["file"] {
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		files = append(files, services.ScanFile{Name: header.Filename, Format: format, Content: content})
+	}
+
+	// For development, use mock user and org data
+	userID := uint(1)
+	orgID := uint(1)
+	userRole := models.RoleAdmin
+
+	result, err := h.service.ScanFiles(files, selection, userID, orgID, userRole)
+	if err != nil {
+		c.JSON(scanErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// parseScanSelection reads the policy_id, tags and category query parameters
+func parseScanSelection(c *gin.Context) (services.ScanSelection, bool) {
+	selection := services.ScanSelection{
+		Tags:     queryList(c, "tags"),
+		Category: c.Query("category"),
+	}
+	for _, value := range queryList(c, "policy_id") {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+			return selection, false
+		}
+		selection.PolicyIDs = append(selection.PolicyIDs, uint(id))
+	}
+	return selection, true
+}
+
+// scanErrorStatus maps scan errors to HTTP status codes
+func scanErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidTerraformPlan), errors.Is(err, services.ErrNoScanPolicies),
+		errors.Is(err, services.ErrInvalidScanFile), errors.Is(err, services.ErrUnknownScanFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrBatchTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
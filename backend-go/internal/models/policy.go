@@ -21,15 +21,30 @@ type Policy struct {
 	Organization   Organization           `json:"organization" gorm:"foreignKey:OrganizationID"`
 	Tags           []string               `json:"tags" gorm:"serializer:json"`
 	Metadata       map[string]interface{} `json:"metadata" gorm:"serializer:json"`
+	RequireTests   bool                   `json:"require_tests" gorm:"default:false"` // content changes must pass the policy's test cases
+	Version        int                    `json:"version" gorm:"default:1"`           // current PolicyVersion
+	ChangeMessage  string                 `json:"change_message,omitempty" gorm:"-"`  // recorded on the version created by a write
+	SourceID       *uint                  `json:"source_id,omitempty" gorm:"index"`   // PolicySource the policy is synced from
+	SourcePath     string                 `json:"source_path,omitempty"`              // file path within the source repository
+	SourceCommit   string                 `json:"source_commit,omitempty"`            // commit the current content was synced from
+	ArchivedFrom   PolicyStatus           `json:"archived_from,omitempty"`            // status before a sync archived the policy for its removed file
 	CreatedAt      time.Time              `json:"created_at"`
 	UpdatedAt      time.Time              `json:"updated_at"`
 	DeletedAt      gorm.DeletedAt         `json:"-" gorm:"index"`
 }
 
+// Languages of a Policy, each evaluated by its own engine
+const (
+	LanguageRego  = "rego"
+	LanguageCedar = "cedar"
+)
+
 type PolicyStatus string
 
 const (
 	StatusDraft    PolicyStatus = "draft"
+	StatusInReview PolicyStatus = "in_review"
+	StatusApproved PolicyStatus = "approved"
 	StatusActive   PolicyStatus = "active"
 	StatusInactive PolicyStatus = "inactive"
 	StatusArchived PolicyStatus = "archived"
@@ -41,7 +56,7 @@ func (s PolicyStatus) String() string {
 
 func (s PolicyStatus) IsValid() bool {
 	switch s {
-	case StatusDraft, StatusActive, StatusInactive, StatusArchived:
+	case StatusDraft, StatusInReview, StatusApproved, StatusActive, StatusInactive, StatusArchived:
 		return true
 	default:
 		return false
@@ -67,19 +82,307 @@ type PolicyTemplate struct {
 }
 
 type PolicyEvaluation struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	PolicyID  uint           `json:"policy_id"`
-	Policy    Policy         `json:"policy" gorm:"foreignKey:PolicyID"`
-	Input     string         `json:"input" gorm:"type:text"`
-	Output    string         `json:"output" gorm:"type:text"`
-	Decision  string         `json:"decision"`
-	Duration  int64          `json:"duration"` // in milliseconds
-	UserID    uint           `json:"user_id"`
-	User      User           `json:"user" gorm:"foreignKey:UserID"`
-	CreatedAt time.Time      `json:"created_at"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index"`
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	PolicyID       uint           `json:"policy_id"`
+	Policy         Policy         `json:"policy" gorm:"foreignKey:PolicyID"`
+	Input          string         `json:"input" gorm:"type:text"`
+	Output         string         `json:"output" gorm:"type:text"`
+	Decision       string         `json:"decision"`
+	Duration       int64          `json:"duration"` // in milliseconds
+	UserID         *uint          `json:"user_id"`  // nil for decisions reported by OPA agents
+	User           *User          `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Source         string         `json:"source" gorm:"default:api;index"`
+	DecisionID     string         `json:"decision_id,omitempty" gorm:"index"`
+	BundleRevision string         `json:"bundle_revision,omitempty" gorm:"index"`
+	Path           string         `json:"path,omitempty" gorm:"index"`
+	ShadowID       *uint          `json:"shadow_id,omitempty" gorm:"index"` // PolicyShadow evaluated alongside
+	ShadowOutput   string         `json:"shadow_output,omitempty" gorm:"type:text"`
+	ShadowDecision string         `json:"shadow_decision,omitempty"`
+	ShadowDiffers  bool           `json:"shadow_differs,omitempty"`
+	ShadowError    string         `json:"shadow_error,omitempty"`
+	ExceptionID    *uint          `json:"exception_id,omitempty" gorm:"index"` // PolicyException that waived a deny
+	CreatedAt      time.Time      `json:"created_at"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Decisions recorded on a PolicyEvaluation
+const (
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+)
+
+// PolicyVersion is an immutable snapshot of a policy's content, created on every content change
+type PolicyVersion struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	PolicyID  uint      `json:"policy_id" gorm:"uniqueIndex:idx_policy_version"`
+	Version   int       `json:"version" gorm:"uniqueIndex:idx_policy_version"`
+	Content   string    `json:"content" gorm:"type:text"`
+	Language  string    `json:"language"`
+	Message   string    `json:"message"`
+	AuthorID  uint      `json:"author_id"`
+	Author    *User     `json:"author,omitempty" gorm:"foreignKey:AuthorID"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PolicyReview is one step of the review of a policy version: its submission,
+// an approval, or a request for changes
+type PolicyReview struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	PolicyID   uint      `json:"policy_id" gorm:"index"`
+	Version    int       `json:"version"`
+	Action     string    `json:"action"`
+	ReviewerID uint      `json:"reviewer_id"`
+	Reviewer   *User     `json:"reviewer,omitempty" gorm:"foreignKey:ReviewerID"`
+	Comment    string    `json:"comment" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Actions recorded on a PolicyReview
+const (
+	ReviewSubmitted        = "submitted"
+	ReviewApproved         = "approved"
+	ReviewChangesRequested = "changes_requested"
+)
+
+// PolicySource is a directory of a git repository whose .rego files are synced
+// into an organization's policies
+type PolicySource struct {
+	ID             uint           `json:"id" gorm:"primaryKey"`
+	OrganizationID uint           `json:"organization_id" gorm:"index"`
+	Name           string         `json:"name" gorm:"not null"`
+	RepositoryURL  string         `json:"repository_url" gorm:"not null"`
+	Branch         string         `json:"branch" gorm:"default:main"`
+	Path           string         `json:"path"`   // directory within the repository, empty for the root
+	Export         string         `json:"export"` // when policy changes are committed back, one of the SourceExport modes
+	LastCommit     string         `json:"last_commit"`
+	LastSyncedAt   *time.Time     `json:"last_synced_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// Export modes of a PolicySource
+const (
+	SourceExportNone     = ""         // read-only source
+	SourceExportUpdate   = "update"   // commit every new policy version
+	SourceExportApproval = "approval" // commit versions once they are approved
+)
+
+// PolicyExport records a policy version committed to a policy source
+type PolicyExport struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	PolicyID  uint      `json:"policy_id" gorm:"index"`
+	SourceID  uint      `json:"source_id"`
+	Version   int       `json:"version"`
+	Path      string    `json:"path"`
+	Commit    string    `json:"commit"`          // empty when the file was already up to date or the export failed
+	Error     string    `json:"error,omitempty"` // set when the commit could not be pushed
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PolicySync records one sync of a policy source and the commit it read
+type PolicySync struct {
+	ID        uint              `json:"id" gorm:"primaryKey"`
+	SourceID  uint              `json:"source_id" gorm:"index"`
+	Commit    string            `json:"commit"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Archived  int               `json:"archived"` // policies whose file was removed
+	Restored  int               `json:"restored"` // archived policies whose file came back
+	Failed    int               `json:"failed"`
+	Errors    map[string]string `json:"errors" gorm:"serializer:json"` // file path to error
+	UserID    uint              `json:"user_id"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// PolicyShadow is a candidate version of a policy that is evaluated alongside it.
+// Its decisions are recorded on the evaluations but never returned to callers.
+type PolicyShadow struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	PolicyID  uint       `json:"policy_id" gorm:"index"`
+	Version   int        `json:"version,omitempty"` // PolicyVersion the content was taken from, if any
+	Content   string     `json:"content" gorm:"type:text"`
+	Message   string     `json:"message"`
+	AuthorID  uint       `json:"author_id"`
+	Author    *User      `json:"author,omitempty" gorm:"foreignKey:AuthorID"`
+	StoppedAt *time.Time `json:"stopped_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PolicyException waives a policy for the inputs its selector matches until it
+// expires. Only approved exceptions are applied; revoking one deletes it.
+type PolicyException struct {
+	ID            uint                   `json:"id" gorm:"primaryKey"`
+	PolicyID      uint                   `json:"policy_id" gorm:"index"`
+	Policy        *Policy                `json:"policy,omitempty" gorm:"foreignKey:PolicyID"`
+	Selector      map[string]interface{} `json:"selector" gorm:"serializer:json"` // dotted input paths to the values they must match
+	Justification string                 `json:"justification" gorm:"type:text"`
+	RequesterID   uint                   `json:"requester_id"`
+	Requester     *User                  `json:"requester,omitempty" gorm:"foreignKey:RequesterID"`
+	ApproverID    *uint                  `json:"approver_id"`
+	Approver      *User                  `json:"approver,omitempty" gorm:"foreignKey:ApproverID"`
+	ApprovedAt    *time.Time             `json:"approved_at"`
+	ExpiresAt     time.Time              `json:"expires_at" gorm:"index"`
+	CreatedAt     time.Time              `json:"created_at"`
+	UpdatedAt     time.Time              `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt         `json:"-" gorm:"index"`
+}
+
+// PolicyReplay is a background job re-evaluating stored inputs of a policy against
+// draft content to find the decisions the draft would change
+type PolicyReplay struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	PolicyID    uint       `json:"policy_id" gorm:"index"`
+	Content     string     `json:"content" gorm:"type:text"`
+	Limit       int        `json:"limit"` // latest stored inputs to replay, 0 for all in the window
+	From        *time.Time `json:"from"`
+	To          *time.Time `json:"to"`
+	Status      string     `json:"status"`
+	Total       int        `json:"total"`
+	Processed   int        `json:"processed"`
+	Unchanged   int        `json:"unchanged"`
+	AllowToDeny int        `json:"allow_to_deny"`
+	DenyToAllow int        `json:"deny_to_allow"`
+	Errors      int        `json:"errors"` // inputs the draft failed to evaluate
+	Error       string     `json:"error,omitempty"`
+	UserID      uint       `json:"user_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// Statuses of a PolicyReplay
+const (
+	ReplayPending   = "pending"
+	ReplayRunning   = "running"
+	ReplayCompleted = "completed"
+	ReplayFailed    = "failed"
+)
+
+// PolicyReplayFlip is a stored input whose decision the draft of a replay changes
+type PolicyReplayFlip struct {
+	ID               uint                   `json:"id" gorm:"primaryKey"`
+	ReplayID         uint                   `json:"replay_id" gorm:"index"`
+	EvaluationID     uint                   `json:"evaluation_id"`
+	Change           string                 `json:"change" gorm:"index"` // one of the ReplayFlip changes
+	Input            map[string]interface{} `json:"input" gorm:"serializer:json"`
+	Decision         string                 `json:"decision"`
+	ReplayDecision   string                 `json:"replay_decision"`
+	ReplayViolations []string               `json:"replay_violations" gorm:"serializer:json"`
+	EvaluatedAt      time.Time              `json:"evaluated_at"` // when the input was originally evaluated
+}
+
+// Changes recorded on a PolicyReplayFlip
+const (
+	ReplayFlipAllowToDeny = "allow_to_deny"
+	ReplayFlipDenyToAllow = "deny_to_allow"
+)
+
+// PolicyTestCase is a named input with the outcome a policy is expected to produce
+type PolicyTestCase struct {
+	ID               uint                   `json:"id" gorm:"primaryKey"`
+	PolicyID         uint                   `json:"policy_id" gorm:"index"`
+	Name             string                 `json:"name" gorm:"not null"`
+	Description      string                 `json:"description"`
+	Input            map[string]interface{} `json:"input" gorm:"serializer:json"`
+	ExpectedDecision string                 `json:"expected_decision"`
+	ExpectedMessages []string               `json:"expected_messages" gorm:"serializer:json"`
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        time.Time              `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt         `json:"-" gorm:"index"`
+}
+
+// PolicyData is a JSON document of an organization that policies read as
+// data.<path> during evaluation, e.g. the document at kubernetes/networkpolicies
+// is data.kubernetes.networkpolicies
+type PolicyData struct {
+	ID             uint        `json:"id" gorm:"primaryKey"`
+	OrganizationID uint        `json:"organization_id" gorm:"uniqueIndex:idx_policy_data_path"`
+	Path           string      `json:"path" gorm:"uniqueIndex:idx_policy_data_path;not null"` // slash separated
+	Value          interface{} `json:"value" gorm:"serializer:json"`
+	Version        int         `json:"version"`
+	Size           int         `json:"size"` // bytes of the JSON encoded value
+	UpdatedByID    uint        `json:"updated_by_id"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+// PolicyDataVersion is an immutable snapshot of a data document, created on every
+// change. Deleting a document records a version without a value.
+type PolicyDataVersion struct {
+	ID             uint        `json:"id" gorm:"primaryKey"`
+	OrganizationID uint        `json:"organization_id" gorm:"index:idx_policy_data_version"`
+	Path           string      `json:"path" gorm:"index:idx_policy_data_version"`
+	Version        int         `json:"version"`
+	Value          interface{} `json:"value" gorm:"serializer:json"`
+	Deleted        bool        `json:"deleted"`
+	Message        string      `json:"message"`
+	AuthorID       uint        `json:"author_id"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+// PolicyDataSchema constrains the data documents at or below a path with a JSON
+// Schema and a size limit. The schema with the longest matching path applies.
+type PolicyDataSchema struct {
+	ID             uint                   `json:"id" gorm:"primaryKey"`
+	OrganizationID uint                   `json:"organization_id" gorm:"uniqueIndex:idx_policy_data_schema_path"`
+	Path           string                 `json:"path" gorm:"uniqueIndex:idx_policy_data_schema_path;not null"`
+	Schema         map[string]interface{} `json:"schema" gorm:"serializer:json"` // JSON Schema, optional
+	MaxBytes       int                    `json:"max_bytes"`                     // 0 for the configured default
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+}
+
+// DataSource is an HTTP endpoint returning JSON, such as a CMDB export, that is
+// polled on an interval into the organization's data document at Path
+type DataSource struct {
+	ID                  uint           `json:"id" gorm:"primaryKey"`
+	OrganizationID      uint           `json:"organization_id" gorm:"index"`
+	Name                string         `json:"name" gorm:"not null"`
+	URL                 string         `json:"url" gorm:"not null"`
+	Path                string         `json:"path" gorm:"not null"` // data document written with the response
+	IntervalSeconds     int            `json:"interval_seconds"`
+	ETag                string         `json:"etag" gorm:"column:etag"` // of the last response, sent as If-None-Match
+	LastFetchedAt       *time.Time     `json:"last_fetched_at"`
+	LastSuccessAt       *time.Time     `json:"last_success_at"`
+	LastError           string         `json:"last_error,omitempty"`
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	NextFetchAt         *time.Time     `json:"next_fetch_at" gorm:"index"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `json:"-" gorm:"index"`
 }
 
+// DataSourceFetch records one poll of a data source
+type DataSourceFetch struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	SourceID   uint      `json:"source_id" gorm:"index"`
+	Status     string    `json:"status"` // one of the DataFetch statuses
+	StatusCode int       `json:"status_code,omitempty"`
+	Version    int       `json:"version,omitempty"` // PolicyDataVersion written by the fetch
+	Error      string    `json:"error,omitempty"`
+	Duration   int64     `json:"duration"` // milliseconds
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Statuses of a DataSourceFetch
+const (
+	DataFetchUpdated     = "updated"      // the response changed the document
+	DataFetchUnchanged   = "unchanged"    // the response matched the document
+	DataFetchNotModified = "not_modified" // the server answered 304 to the ETag
+	DataFetchFailed      = "failed"
+)
+
+// Sources of a PolicyEvaluation
+const (
+	EvaluationSourceAPI         = "api"          // evaluated by the backend
+	EvaluationSourceDecisionLog = "decision_log" // reported by an OPA agent
+	EvaluationSourceAdmission   = "admission"    // decided for a Kubernetes admission request
+	EvaluationSourceScan        = "scan"         // evaluated while scanning a plan or files
+	EvaluationSourceMutation    = "mutation"     // patch applied to a Kubernetes admission request
+)
+
 // AccessLevel defines who can access a policy
 type AccessLevel string
 
@@ -206,12 +206,9 @@ func (s *AIService) generateWithGemini(req PolicyGenerationRequest) (*PolicyGene
 
 	// Fallback to simple response format
 	return &PolicyGenerationResponse{
-		Policy:      responseText,
-		Description: req.Description,
-		Framework:   req.Framework,
-		Language:    req.Language,
-		Source:      "gemini",
-		Confidence:  0.8, // Default confidence
+		Policy:     responseText,
+		Source:     "gemini",
+		Confidence: 0.8, // Default confidence
 	}, nil
 }
 
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/bundle"
+)
+
+type BundleService struct {
+	db        *database.Database
+	cfg       *config.Config
+	signer    *bundleSigner
+	signerErr error
+}
+
+func NewBundleService(db *database.Database, cfg *config.Config) *BundleService {
+	signer, err := newBundleSigner(cfg.Bundle)
+	if err != nil {
+		slog.Error("Bundle signing misconfigured, bundles will not be served", "error", err)
+	}
+
+	return &BundleService{
+		db:        db,
+		cfg:       cfg,
+		signer:    signer,
+		signerErr: err,
+	}
+}
+
+// PolicyBundle is the set of active policies and data documents served to OPA
+// agents for one organization
+type PolicyBundle struct {
+	Organization models.Organization
+	Revision     string
+	Roots        []string
+	Modules      []bundle.ModuleFile
+	Data         map[string]interface{} // data documents nested by path
+
+	signer *bundleSigner
+}
+
+// GetBundle collects the active policies and the data documents of an organization
+// (by ID or slug) into a bundle
+func (s *BundleService) GetBundle(orgRef string) (*PolicyBundle, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	if s.signerErr != nil {
+		return nil, fmt.Errorf("bundle signing misconfigured: %v", s.signerErr)
+	}
+
+	org, err := findOrganization(s.db, orgRef)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []models.Policy
+	err = s.db.DB.Where("organization_id = ? AND status = ?", org.ID, models.StatusActive).
+		Order("id").Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	b := &PolicyBundle{Organization: *org, signer: s.signer}
+	var roots []string
+	for _, policy := range policies {
+		// OPA bundles carry Rego modules only
+		if !isRegoPolicy(&policy) {
+			continue
+		}
+
+		pkg, err := PackagePath(policy.Content)
+		if err != nil {
+			return nil, fmt.Errorf("policy %d: %v", policy.ID, err)
+		}
+
+		root := strings.ReplaceAll(pkg, ".", "/")
+		path := fmt.Sprintf("%s/policy-%d.rego", root, policy.ID)
+		b.Modules = append(b.Modules, bundle.ModuleFile{
+			URL:  path,
+			Path: path,
+			Raw:  []byte(policy.Content),
+		})
+		roots = append(roots, root)
+	}
+
+	data, err := readPolicyData(s.db, org.ID, 0)
+	if err != nil {
+		return nil, err
+	}
+	for path := range data.Documents {
+		roots = append(roots, path)
+	}
+	b.Data = data.Tree()
+
+	b.Roots = bundleRoots(roots)
+	b.Revision, err = bundleRevision(b.Modules, b.Data)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ETag returns the HTTP entity tag for the bundle revision
+func (b *PolicyBundle) ETag() string {
+	return `"` + b.Revision + `"`
+}
+
+// Write serializes the bundle as a gzipped OPA bundle tarball, signed when
+// bundle signing is enabled
+func (b *PolicyBundle) Write(w io.Writer) error {
+	regoVersion := 0 // modules are authored in Rego v0 syntax (with optional rego.v1 imports)
+	roots := b.Roots
+
+	opaBundle := bundle.Bundle{
+		Manifest: bundle.Manifest{
+			Revision:    b.Revision,
+			Roots:       &roots,
+			RegoVersion: &regoVersion,
+			Metadata: map[string]interface{}{
+				"organization": b.Organization.Slug,
+			},
+		},
+		Modules: b.Modules,
+		Data:    b.Data,
+	}
+
+	if b.signer != nil {
+		if err := b.signer.sign(&opaBundle); err != nil {
+			return err
+		}
+	}
+
+	return bundle.NewWriter(w).DisableFormat(true).Write(opaBundle)
+}
+
+// bundleRevision hashes the module paths and contents and the data so the revision
+// only changes when the served policies or documents change
+func bundleRevision(modules []bundle.ModuleFile, data map[string]interface{}) (string, error) {
+	h := sha256.New()
+	for _, module := range modules {
+		h.Write([]byte(module.Path))
+		h.Write([]byte{0})
+		h.Write(module.Raw)
+		h.Write([]byte{0})
+	}
+	if len(data) > 0 {
+		// Maps marshal with sorted keys, so equal data hashes equally
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode bundle data: %v", err)
+		}
+		h.Write(encoded)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// bundleRoots deduplicates roots and drops roots nested under another root,
+// since OPA rejects manifests with overlapping roots
+func bundleRoots(roots []string) []string {
+	sort.Strings(roots)
+
+	result := []string{}
+	for _, root := range roots {
+		if n := len(result); n > 0 {
+			last := result[n-1]
+			if root == last || strings.HasPrefix(root, last+"/") {
+				continue
+			}
+		}
+		result = append(result, root)
+	}
+	return result
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBundleService(t *testing.T) (*BundleService, *database.Database) {
+	db := &database.Database{DB: setupTestDB(t)}
+	return NewBundleService(db, &config.Config{}), db
+}
+
+func createTestOrganization(t *testing.T, db *database.Database, slug string) *models.Organization {
+	org := &models.Organization{Name: slug, Slug: slug}
+	require.NoError(t, db.DB.Create(org).Error)
+	return org
+}
+
+func TestBundleService_GetBundle(t *testing.T) {
+	service, db := newTestBundleService(t)
+	org := createTestOrganization(t, db, "acme")
+	other := createTestOrganization(t, db, "other")
+
+	policies := []models.Policy{
+		{Name: "security", Content: podSecurityPolicy, Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "containers", Content: containerSecurityPolicy, Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "nested", Content: "package kubernetes.security.pods\n\ndeny[msg] { msg := \"x\"; false }", Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "draft", Content: "package drafts\n", Status: models.StatusDraft, OrganizationID: org.ID},
+		{Name: "foreign", Content: "package foreign\n", Status: models.StatusActive, OrganizationID: other.ID},
+	}
+	for i := range policies {
+		require.NoError(t, db.DB.Create(&policies[i]).Error)
+	}
+
+	b, err := service.GetBundle("acme")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kubernetes/security", "policy/container_security"}, b.Roots)
+	assert.Len(t, b.Modules, 3)
+
+	byID, err := service.GetBundle("1")
+	require.NoError(t, err)
+	assert.Equal(t, b.Revision, byID.Revision, "revision is stable when nothing changes")
+
+	var buf bytes.Buffer
+	require.NoError(t, b.Write(&buf))
+
+	loaded, err := bundle.NewReader(&buf).Read()
+	require.NoError(t, err)
+	assert.Equal(t, b.Revision, loaded.Manifest.Revision)
+	assert.Equal(t, []string{"kubernetes/security", "policy/container_security"}, *loaded.Manifest.Roots)
+	require.Len(t, loaded.Modules, 3)
+	assert.Equal(t, podSecurityPolicy, string(loaded.Modules[0].Raw))
+
+	// Changing an active policy changes the revision
+	require.NoError(t, db.DB.Model(&policies[1]).Update("status", models.StatusInactive).Error)
+	changed, err := service.GetBundle("acme")
+	require.NoError(t, err)
+	assert.NotEqual(t, b.Revision, changed.Revision)
+	assert.Equal(t, []string{"kubernetes/security"}, changed.Roots)
+
+	_, err = service.GetBundle("missing")
+	assert.ErrorIs(t, err, ErrOrganizationNotFound)
+}
+
+func TestBundleService_SignedBundle(t *testing.T) {
+	db := &database.Database{DB: setupTestDB(t)}
+	org := createTestOrganization(t, db, "acme")
+	policy := &models.Policy{Name: "security", Content: podSecurityPolicy, Status: models.StatusActive, OrganizationID: org.ID}
+	require.NoError(t, db.DB.Create(policy).Error)
+
+	tests := []struct {
+		name string
+		alg  string
+	}{
+		{"rsa", "RS256"},
+		{"ecdsa", "ES256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewBundleService(db, &config.Config{
+				Bundle: config.BundleConfig{SigningKeyID: "niyama-" + tt.name, SigningKeyFile: writeSigningKey(t, tt.alg), SigningAlg: tt.alg},
+			})
+			require.NoError(t, service.SigningError())
+
+			keys, err := service.PublicKeys()
+			require.NoError(t, err)
+			require.Contains(t, keys, "niyama-"+tt.name)
+			assert.Equal(t, tt.alg, keys["niyama-"+tt.name].Algorithm)
+			assert.Contains(t, keys["niyama-"+tt.name].Key, "BEGIN PUBLIC KEY")
+
+			b, err := service.GetBundle("acme")
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, b.Write(&buf))
+			signed := buf.Bytes()
+
+			verified, err := service.VerifyBundle(bytes.NewReader(signed))
+			require.NoError(t, err)
+			assert.Equal(t, b.Revision, verified.Manifest.Revision)
+
+			// A tampered module fails verification
+			tampered := rewriteBundleFile(t, signed, "kubernetes/security/policy-1.rego", []byte("package kubernetes.security\n\ndeny[msg] { false; msg := \"\" }"))
+			_, err = service.VerifyBundle(bytes.NewReader(tampered))
+			assert.Error(t, err)
+
+			// An unsigned bundle fails verification
+			unsigned, err := NewBundleService(db, &config.Config{}).GetBundle("acme")
+			require.NoError(t, err)
+			buf.Reset()
+			require.NoError(t, unsigned.Write(&buf))
+			_, err = service.VerifyBundle(&buf)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// writeSigningKey generates a private key for the algorithm and returns the file holding it
+func writeSigningKey(t *testing.T, alg string) string {
+	key, err := generateSigningKey(alg)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "bundle.pem")
+	require.NoError(t, os.WriteFile(keyFile, key, 0600))
+	return keyFile
+}
+
+func TestBundleService_SigningKeyFile(t *testing.T) {
+	keyFile := writeSigningKey(t, "RS256")
+	key, err := os.ReadFile(keyFile)
+	require.NoError(t, err)
+
+	service, _ := newTestBundleService(t)
+	service.signer, service.signerErr = newBundleSigner(config.BundleConfig{SigningKeyID: "prod", SigningKeyFile: keyFile, SigningAlg: "RS256"})
+	require.NoError(t, service.signerErr)
+
+	expected, err := publicKeyPEM(key)
+	require.NoError(t, err)
+	keys, err := service.PublicKeys()
+	require.NoError(t, err)
+	assert.Equal(t, expected, keys["prod"].Key)
+
+	_, err = newBundleSigner(config.BundleConfig{SigningKeyID: "prod", SigningKeyFile: keyFile, SigningAlg: "HS256"})
+	assert.Error(t, err)
+
+	// Without a key file every replica would sign with a key of its own
+	service = NewBundleService(service.db, &config.Config{Bundle: config.BundleConfig{SigningKeyID: "prod", SigningAlg: "RS256"}})
+	assert.ErrorContains(t, service.SigningError(), "BUNDLE_SIGNING_KEY_FILE")
+	_, err = service.PublicKeys()
+	assert.Error(t, err)
+}
+
+// rewriteBundleFile replaces the contents of one file in a gzipped bundle tarball
+func rewriteBundleFile(t *testing.T, tarball []byte, name string, content []byte) []byte {
+	gr, err := gzip.NewReader(bytes.NewReader(tarball))
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+
+	var out bytes.Buffer
+	gw := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gw)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if strings.TrimPrefix(hdr.Name, "/") == name {
+			data = content
+		}
+
+		hdr.Size = int64(len(data))
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return out.Bytes()
+}
+
+// generateSigningKey creates a PEM-encoded private key for the algorithm family
+func generateSigningKey(alg string) ([]byte, error) {
+	if strings.HasPrefix(alg, "ES") {
+		curve, err := ecdsaCurve(alg)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %v", err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode signing key: %v", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+	der := x509.MarshalPKCS1PrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), nil
+}
+
+func ecdsaCurve(alg string) (elliptic.Curve, error) {
+	switch alg {
+	case "ES256":
+		return elliptic.P256(), nil
+	case "ES384":
+		return elliptic.P384(), nil
+	case "ES512":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unsupported bundle signing algorithm %q", alg)
+	}
+}
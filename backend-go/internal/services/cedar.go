@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"niyama-backend/internal/models"
+
+	cedar "github.com/cedar-policy/cedar-go"
+)
+
+// Diagnostic codes of Cedar policies
+const (
+	cedarParseErr = "cedar_parse_error"
+)
+
+// cedarErrorPosition matches the position cedar-go reports in parse errors
+var cedarErrorPosition = regexp.MustCompile(`^(?:parser error: )?(?:parse error at )?<input>:(\d+):(\d+):? ?`)
+
+// CedarEngine evaluates Cedar policy sets with cedar-go.
+// The input is a Cedar authorization request:
+//
+//	{
+//	  "principal": {"type": "User", "id": "alice"},
+//	  "action":    {"type": "Action", "id": "view"},
+//	  "resource":  {"type": "Photo", "id": "vacation.jpg"},
+//	  "context":   {"mfa": true},
+//	  "entities":  [{"uid": {"type": "User", "id": "alice"}, "attrs": {}, "parents": []}]
+//	}
+//
+// The request is allowed when a permit policy matches and no forbid policy does.
+// Policies that fail to evaluate are skipped and reported in the result's errors.
+type CedarEngine struct {
+	mu       sync.Mutex
+	compiled map[[sha256.Size]byte]*cedar.PolicySet
+}
+
+func NewCedarEngine() *CedarEngine {
+	return &CedarEngine{
+		compiled: make(map[[sha256.Size]byte]*cedar.PolicySet),
+	}
+}
+
+func (e *CedarEngine) Language() string {
+	return models.LanguageCedar
+}
+
+// Validate parses the policy set and returns its syntax errors
+func (e *CedarEngine) Validate(content string) []PolicyDiagnostic {
+	diagnostics := []PolicyDiagnostic{}
+	if _, diagnostic := parseCedar(content); diagnostic != nil {
+		diagnostics = append(diagnostics, *diagnostic)
+	}
+	return diagnostics
+}
+
+// Compile parses the policy set, caching it by content
+func (e *CedarEngine) Compile(ctx context.Context, policy *models.Policy) error {
+	_, err := e.compile(policy.Content)
+	return err
+}
+
+// Evaluate authorizes the request in the input against the policy set
+func (e *CedarEngine) Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error) {
+	policies, err := e.compile(policy.Content)
+	if err != nil {
+		return nil, err
+	}
+	entities, request, err := newCedarRequest(input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Cedar request: %v", err)
+	}
+
+	decision, diagnostic := policies.IsAuthorized(entities, request)
+
+	// cedar-go reports policies in no particular order; keep the order of the document
+	sort.Slice(diagnostic.Reasons, func(i, j int) bool {
+		return diagnostic.Reasons[i].Position.Offset < diagnostic.Reasons[j].Position.Offset
+	})
+	sort.Slice(diagnostic.Errors, func(i, j int) bool {
+		return diagnostic.Errors[i].Position.Offset < diagnostic.Errors[j].Position.Offset
+	})
+
+	result := &EvaluationResult{Decision: models.DecisionDeny, Violations: []string{}}
+	if decision == cedar.Allow {
+		result.Decision = models.DecisionAllow
+	}
+	reasons := []string{}
+	for _, reason := range diagnostic.Reasons {
+		reasons = append(reasons, string(reason.PolicyID))
+		if decision == cedar.Allow {
+			continue
+		}
+		// Reasons of a deny are the forbid policies that matched
+		message := string(policies.Get(reason.PolicyID).Annotations()["message"])
+		if message == "" {
+			message = fmt.Sprintf("forbidden by %s", reason.PolicyID)
+		}
+		result.Violations = append(result.Violations, message)
+	}
+	errs := []string{}
+	for _, evalErr := range diagnostic.Errors {
+		errs = append(errs, fmt.Sprintf("%s: %s", evalErr.PolicyID, evalErr.Message))
+	}
+	result.Result = map[string]interface{}{
+		"decision": result.Decision,
+		"reasons":  reasons,
+		"errors":   errs,
+	}
+	return result, nil
+}
+
+// compile parses a policy set on first use
+func (e *CedarEngine) compile(content string) (*cedar.PolicySet, error) {
+	key := sha256.Sum256([]byte(content))
+
+	e.mu.Lock()
+	policies, ok := e.compiled[key]
+	e.mu.Unlock()
+	if ok {
+		return policies, nil
+	}
+
+	policies, diagnostic := parseCedar(content)
+	if diagnostic != nil {
+		return nil, &ValidationError{Diagnostics: []PolicyDiagnostic{*diagnostic}}
+	}
+
+	e.mu.Lock()
+	if len(e.compiled) >= maxPreparedQueries {
+		e.compiled = make(map[[sha256.Size]byte]*cedar.PolicySet)
+	}
+	e.compiled[key] = policies
+	e.mu.Unlock()
+
+	return policies, nil
+}
+
+// parseCedar parses a policy set, naming each policy after its @id annotation
+// or, without one, policy<n> for its index in the document
+func parseCedar(content string) (*cedar.PolicySet, *PolicyDiagnostic) {
+	list, err := cedar.NewPolicyListFromBytes("", []byte(content))
+	if err != nil {
+		diagnostic := PolicyDiagnostic{Line: 1, Column: 1, Code: cedarParseErr, Message: err.Error()}
+		if match := cedarErrorPosition.FindStringSubmatch(diagnostic.Message); match != nil {
+			diagnostic.Line, _ = strconv.Atoi(match[1])
+			diagnostic.Column, _ = strconv.Atoi(match[2])
+			diagnostic.Message = strings.TrimPrefix(diagnostic.Message, match[0])
+		}
+		return nil, &diagnostic
+	}
+
+	policies := cedar.NewPolicySet()
+	for i, policy := range list {
+		id := cedar.PolicyID(policy.Annotations()["id"])
+		if id == "" {
+			id = cedar.PolicyID(fmt.Sprintf("policy%d", i))
+		}
+		if !policies.Add(id, policy) {
+			position := policy.Position()
+			return nil, &PolicyDiagnostic{Line: position.Line, Column: position.Column, Code: cedarParseErr, Message: fmt.Sprintf("duplicate policy id %s", id)}
+		}
+	}
+	return policies, nil
+}
+
+// newCedarRequest reads the authorization request and the entities it refers to
+func newCedarRequest(input map[string]interface{}) (cedar.EntityMap, cedar.Request, error) {
+	var request cedar.Request
+	var err error
+	if request.Principal, err = cedarEntityRef(input["principal"]); err != nil {
+		return nil, request, fmt.Errorf("principal: %v", err)
+	}
+	if request.Action, err = cedarEntityRef(input["action"]); err != nil {
+		return nil, request, fmt.Errorf("action: %v", err)
+	}
+	if request.Resource, err = cedarEntityRef(input["resource"]); err != nil {
+		return nil, request, fmt.Errorf("resource: %v", err)
+	}
+	if request.Context, err = cedarRecord(input["context"]); err != nil {
+		return nil, request, fmt.Errorf("context: %v", err)
+	}
+
+	entities := cedar.EntityMap{}
+	items, _ := input["entities"].([]interface{})
+	for i, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, request, fmt.Errorf("entities[%d] must be an object", i)
+		}
+		entity := cedar.Entity{}
+		if entity.UID, err = cedarEntityRef(object["uid"]); err != nil {
+			return nil, request, fmt.Errorf("entities[%d].uid: %v", i, err)
+		}
+		if entity.Attributes, err = cedarRecord(object["attrs"]); err != nil {
+			return nil, request, fmt.Errorf("entities[%d].attrs: %v", i, err)
+		}
+		parents, _ := object["parents"].([]interface{})
+		uids := make([]cedar.EntityUID, 0, len(parents))
+		for j, parent := range parents {
+			uid, err := cedarEntityRef(parent)
+			if err != nil {
+				return nil, request, fmt.Errorf("entities[%d].parents[%d]: %v", i, j, err)
+			}
+			uids = append(uids, uid)
+		}
+		entity.Parents = cedar.NewEntityUIDSet(uids...)
+		entities[entity.UID] = entity
+	}
+
+	return entities, request, nil
+}
+
+// cedarEntityRef reads an entity reference written as {"type": ..., "id": ...},
+// optionally wrapped in "__entity", or as the string Type::"id"
+func cedarEntityRef(value interface{}) (cedar.EntityUID, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if wrapped, ok := v["__entity"]; ok {
+			return cedarEntityRef(wrapped)
+		}
+		entityType, _ := v["type"].(string)
+		id, ok := v["id"].(string)
+		if entityType == "" || !ok {
+			return cedar.EntityUID{}, fmt.Errorf("entity reference needs a type and an id")
+		}
+		return cedar.NewEntityUID(cedar.EntityType(entityType), cedar.String(id)), nil
+	case string:
+		entityType, quoted, ok := strings.Cut(v, "::\"")
+		id, err := strconv.Unquote(`"` + quoted)
+		if !ok || entityType == "" || err != nil {
+			return cedar.EntityUID{}, fmt.Errorf("invalid entity reference %q", v)
+		}
+		return cedar.NewEntityUID(cedar.EntityType(entityType), cedar.String(id)), nil
+	default:
+		return cedar.EntityUID{}, fmt.Errorf("entity reference is required")
+	}
+}
+
+// cedarRecord converts a JSON object into a Cedar record using Cedar's JSON
+// value format: "__entity" objects are entity references and numbers must be integers
+func cedarRecord(value interface{}) (cedar.Record, error) {
+	if value == nil {
+		return cedar.NewRecord(nil), nil
+	}
+	if _, ok := value.(map[string]interface{}); !ok {
+		return cedar.Record{}, fmt.Errorf("must be an object")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return cedar.Record{}, err
+	}
+	var record cedar.Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return cedar.Record{}, err
+	}
+	return record, nil
+}
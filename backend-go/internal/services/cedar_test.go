@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const photoPolicies = `
+// Members of the vacation album can view its photos
+@id("view-album")
+permit(
+  principal in Group::"friends",
+  action in [Action::"view", Action::"comment"],
+  resource is Photo in Album::"vacation"
+) when {
+  context.authenticated && resource.tags.containsAny(["beach", "mountain"])
+};
+
+@id("private")
+@message("private photos cannot be shared")
+forbid(principal, action == Action::"share", resource)
+when { resource has private && resource.private }
+unless { principal == User::"alice" };
+
+@id("drafts")
+forbid(principal, action, resource)
+when { resource.name like "draft-*" };
+`
+
+func photoRequest(principal, action, photo string, attrs map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"principal": map[string]interface{}{"type": "User", "id": principal},
+		"action":    `Action::"` + action + `"`,
+		"resource":  map[string]interface{}{"type": "Photo", "id": photo},
+		"context":   map[string]interface{}{"authenticated": true},
+		"entities": []interface{}{
+			map[string]interface{}{
+				"uid":     map[string]interface{}{"type": "User", "id": "bob"},
+				"parents": []interface{}{map[string]interface{}{"type": "Group", "id": "friends"}},
+			},
+			map[string]interface{}{
+				"uid":     map[string]interface{}{"type": "Photo", "id": photo},
+				"attrs":   attrs,
+				"parents": []interface{}{map[string]interface{}{"type": "Album", "id": "vacation"}},
+			},
+		},
+	}
+}
+
+func TestCedarEngine_Evaluate(t *testing.T) {
+	engine := NewCedarEngine()
+	policy := &models.Policy{Content: photoPolicies, Language: models.LanguageCedar}
+	beach := map[string]interface{}{"name": "sunset.jpg", "tags": []interface{}{"beach"}}
+
+	tests := []struct {
+		name       string
+		input      map[string]interface{}
+		decision   string
+		violations []string
+	}{
+		{"permitted through group", photoRequest("bob", "view", "sunset.jpg", beach), models.DecisionAllow, []string{}},
+		{"action outside the list", photoRequest("bob", "edit", "sunset.jpg", beach), models.DecisionDeny, []string{}},
+		{"principal outside the group", photoRequest("carol", "view", "sunset.jpg", beach), models.DecisionDeny, []string{}},
+		{"condition not met", photoRequest("bob", "view", "city.jpg", map[string]interface{}{"name": "city.jpg", "tags": []interface{}{"city"}}), models.DecisionDeny, []string{}},
+		{"forbid overrides permit", photoRequest("bob", "view", "draft-1.jpg", map[string]interface{}{"name": "draft-1.jpg", "tags": []interface{}{"beach"}}), models.DecisionDeny, []string{"forbidden by drafts"}},
+		{"forbid message", photoRequest("bob", "share", "sunset.jpg", map[string]interface{}{"name": "sunset.jpg", "tags": []interface{}{}, "private": true}), models.DecisionDeny, []string{"private photos cannot be shared"}},
+		{"unless exempts", photoRequest("alice", "share", "sunset.jpg", map[string]interface{}{"name": "sunset.jpg", "tags": []interface{}{}, "private": true}), models.DecisionDeny, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.Evaluate(context.Background(), policy, tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.decision, result.Decision)
+			assert.Equal(t, tt.violations, result.Violations)
+		})
+	}
+}
+
+func TestCedarEngine_EvaluationErrors(t *testing.T) {
+	engine := NewCedarEngine()
+	policy := &models.Policy{Language: models.LanguageCedar, Content: `
+@id("level")
+permit(principal, action, resource) when { context.level > 3 };
+
+@id("any")
+permit(principal, action, resource) when { context has level };
+`}
+
+	input := map[string]interface{}{
+		"principal": `User::"bob"`,
+		"action":    `Action::"view"`,
+		"resource":  `Photo::"sunset.jpg"`,
+		"context":   map[string]interface{}{"level": "high"},
+	}
+	result, err := engine.Evaluate(context.Background(), policy, input)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionAllow, result.Decision, "policies that error are skipped")
+	document := result.Result.(map[string]interface{})
+	assert.Equal(t, []string{"any"}, document["reasons"])
+	assert.Len(t, document["errors"], 1)
+
+	_, err = engine.Evaluate(context.Background(), policy, map[string]interface{}{"action": `Action::"view"`})
+	assert.Error(t, err, "requests need a principal and a resource")
+}
+
+func TestCedarEngine_Validate(t *testing.T) {
+	engine := NewCedarEngine()
+
+	assert.Empty(t, engine.Validate(photoPolicies))
+
+	tests := []struct {
+		name    string
+		content string
+		line    int
+	}{
+		{"missing semicolon", "permit(principal, action, resource)", 1},
+		{"unknown effect", "allow(principal, action, resource);", 1},
+		{"bad scope", "permit(\n  principal == \"alice\",\n  action,\n  resource\n);", 2},
+		{"unterminated string", "permit(principal, action, resource) when { context.name == \"x };", 1},
+		{"duplicate id", "@id(\"a\") permit(principal, action, resource);\n@id(\"a\") forbid(principal, action, resource);", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := engine.Validate(tt.content)
+			require.Len(t, diagnostics, 1)
+			assert.Equal(t, cedarParseErr, diagnostics[0].Code)
+			assert.Equal(t, tt.line, diagnostics[0].Line)
+		})
+	}
+
+	var validationErr *ValidationError
+	err := engine.Compile(context.Background(), &models.Policy{Content: "permit(principal);"})
+	assert.True(t, errors.As(err, &validationErr))
+}
+
+func TestPolicyEngines(t *testing.T) {
+	engines := NewPolicyEngines(NewRegoEngine(NewPolicyEvaluator(config.OPAConfig{Mode: OPAModeEmbedded}, nil)), NewCedarEngine())
+	assert.Equal(t, []string{models.LanguageCedar, models.LanguageRego}, engines.Languages())
+
+	engine, err := engines.Engine("")
+	require.NoError(t, err)
+	assert.Equal(t, models.LanguageRego, engine.Language())
+	engine, err = engines.Engine("Cedar")
+	require.NoError(t, err)
+	assert.Equal(t, models.LanguageCedar, engine.Language())
+
+	_, err = engines.Engine("sentinel")
+	assert.ErrorIs(t, err, ErrUnsupportedLanguage)
+	diagnostics := engines.Validate(&models.Policy{Content: "policy", Language: "sentinel"})
+	require.Len(t, diagnostics, 1)
+	assert.Equal(t, "unsupported_language", diagnostics[0].Code)
+	assert.Equal(t, "unsupported policy language: sentinel (supported: cedar, rego)", diagnostics[0].Message)
+	_, err = engines.Evaluate(context.Background(), &models.Policy{Content: "policy", Language: "sentinel"}, nil)
+	assert.ErrorIs(t, err, ErrUnsupportedLanguage)
+}
+
+func TestPolicyService_CedarPolicy(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	invalid := &models.Policy{Name: "Photos", Content: "permit(principal, action, resource)", Language: models.LanguageCedar}
+	var validationErr *ValidationError
+	assert.True(t, errors.As(service.CreatePolicy(invalid, 1, 1), &validationErr))
+
+	policy := &models.Policy{Name: "Photos", Content: photoPolicies, Language: models.LanguageCedar}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	beach := map[string]interface{}{"name": "sunset.jpg", "tags": []interface{}{"beach"}}
+	evaluation, err := service.TestPolicy(policy.ID, photoRequest("bob", "view", "sunset.jpg", beach), 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionAllow, evaluation.Decision)
+
+	evaluation, err = service.TestPolicy(policy.ID, photoRequest("bob", "view", "draft-1.jpg", map[string]interface{}{"name": "draft-1.jpg", "tags": []interface{}{}}), 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, evaluation.Decision)
+	assert.Contains(t, evaluation.Output, `"reasons":["drafts"]`)
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+)
+
+var (
+	// ErrInvalidDecisionLog is returned when an upload is not a JSON array of decision log events
+	ErrInvalidDecisionLog = errors.New("invalid decision log upload")
+	// ErrDecisionLogTooLarge is returned when an upload exceeds DecisionLogConfig.MaxBytes
+	ErrDecisionLogTooLarge = errors.New("decision log upload too large")
+)
+
+type DecisionLogService struct {
+	db  *database.Database
+	cfg *config.Config
+}
+
+func NewDecisionLogService(db *database.Database, cfg *config.Config) *DecisionLogService {
+	return &DecisionLogService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// DecisionLogEntry is one event uploaded by an OPA agent's decision_logs plugin
+type DecisionLogEntry struct {
+	DecisionID string                `json:"decision_id"`
+	Path       string                `json:"path"`
+	Input      interface{}           `json:"input"`
+	Result     interface{}           `json:"result"`
+	Bundles    map[string]BundleInfo `json:"bundles"`
+	Timestamp  time.Time             `json:"timestamp"`
+	Metrics    map[string]float64    `json:"metrics"`
+}
+
+// BundleInfo is the bundle state reported with a decision
+type BundleInfo struct {
+	Revision string `json:"revision"`
+}
+
+// DecisionLogIngestResult summarizes an uploaded batch of decision log entries
+type DecisionLogIngestResult struct {
+	Accepted   int `json:"accepted"`
+	Duplicates int `json:"duplicates"`
+	Unmatched  int `json:"unmatched"`
+}
+
+// decisionLogPolicy is a policy of the organization and the data path of its package
+type decisionLogPolicy struct {
+	policy *models.Policy
+	root   string
+}
+
+// MaxUploadBytes returns the largest upload accepted, 0 for no limit
+func (s *DecisionLogService) MaxUploadBytes() int64 {
+	return s.cfg.DecisionLog.MaxBytes
+}
+
+// ReadUpload decodes an upload of an OPA agent, decompressing it first when gzipped.
+// At most DecisionLogConfig.MaxBytes of decompressed content are read.
+func (s *DecisionLogService) ReadUpload(body io.Reader, gzipped bool) ([]DecisionLogEntry, error) {
+	if gzipped {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid gzip body: %v", ErrInvalidDecisionLog, err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	limit := s.cfg.DecisionLog.MaxBytes
+	if limit > 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decision log upload: %w", err)
+	}
+	if limit > 0 && int64(len(content)) > limit {
+		return nil, fmt.Errorf("%w: the upload exceeds %d bytes", ErrDecisionLogTooLarge, limit)
+	}
+
+	var entries []DecisionLogEntry
+	if err := json.NewDecoder(bytes.NewReader(content)).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("%w: expected a JSON array of decision log entries: %v", ErrInvalidDecisionLog, err)
+	}
+	return entries, nil
+}
+
+// Ingest records the decisions of an organization's OPA agents in the evaluation
+// history. Each entry is attributed to the policy whose package contains the
+// queried path; entries outside any policy package, and decision IDs already
+// recorded, are skipped.
+func (s *DecisionLogService) Ingest(orgRef string, entries []DecisionLogEntry) (*DecisionLogIngestResult, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	org, err := findOrganization(s.db, orgRef)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := s.decisionLogPolicies(org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen, err := s.recordedDecisionIDs(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DecisionLogIngestResult{}
+	var records []*models.PolicyEvaluation
+	for i := range entries {
+		entry := &entries[i]
+		if entry.DecisionID != "" {
+			if seen[entry.DecisionID] {
+				result.Duplicates++
+				continue
+			}
+			seen[entry.DecisionID] = true
+		}
+
+		path := decisionLogPath(entry.Path)
+		match := matchDecisionLogPolicy(policies, path)
+		if match == nil {
+			result.Unmatched++
+			continue
+		}
+
+		evaluation, err := newDecisionLogEvaluation(entry, match, path)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, evaluation)
+		result.Accepted++
+	}
+
+	if len(records) > 0 {
+		if err := s.db.DB.CreateInBatches(records, 100).Error; err != nil {
+			return nil, fmt.Errorf("failed to record decisions: %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// decisionLogPolicies loads the organization's policies with their package paths,
+// active policies first so they win over drafts declaring the same package
+func (s *DecisionLogService) decisionLogPolicies(orgID uint) ([]decisionLogPolicy, error) {
+	var policies []models.Policy
+	err := s.db.DB.Where("organization_id = ?", orgID).Order("id").Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var result []decisionLogPolicy
+	for i := range policies {
+		pkg, err := PackagePath(policies[i].Content)
+		if err != nil {
+			continue
+		}
+		result = append(result, decisionLogPolicy{
+			policy: &policies[i],
+			root:   strings.ReplaceAll(pkg, ".", "/"),
+		})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].policy.Status == models.StatusActive && result[j].policy.Status != models.StatusActive
+	})
+	return result, nil
+}
+
+// recordedDecisionIDs returns the decision IDs of the entries that were already ingested
+func (s *DecisionLogService) recordedDecisionIDs(entries []DecisionLogEntry) (map[string]bool, error) {
+	var ids []string
+	for _, entry := range entries {
+		if entry.DecisionID != "" {
+			ids = append(ids, entry.DecisionID)
+		}
+	}
+
+	seen := make(map[string]bool)
+	if len(ids) == 0 {
+		return seen, nil
+	}
+
+	var recorded []string
+	err := s.db.DB.Model(&models.PolicyEvaluation{}).
+		Where("source = ? AND decision_id IN ?", models.EvaluationSourceDecisionLog, ids).
+		Pluck("decision_id", &recorded).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range recorded {
+		seen[id] = true
+	}
+	return seen, nil
+}
+
+// decisionLogPath normalizes a decision path to slash form, e.g.
+// "data.kubernetes.security.deny" becomes "kubernetes/security/deny"
+func decisionLogPath(path string) string {
+	path = strings.TrimPrefix(path, "data.")
+	if path == "data" {
+		path = ""
+	}
+	if !strings.Contains(path, "/") {
+		path = strings.ReplaceAll(path, ".", "/")
+	}
+	return strings.Trim(path, "/")
+}
+
+// matchDecisionLogPolicy returns the policy with the longest package path that
+// contains path
+func matchDecisionLogPolicy(policies []decisionLogPolicy, path string) *decisionLogPolicy {
+	var best *decisionLogPolicy
+	for i := range policies {
+		root := policies[i].root
+		if path != root && !strings.HasPrefix(path, root+"/") {
+			continue
+		}
+		if best == nil || len(root) > len(best.root) {
+			best = &policies[i]
+		}
+	}
+	return best
+}
+
+// newDecisionLogEvaluation converts a decision log entry into an evaluation record
+func newDecisionLogEvaluation(entry *DecisionLogEntry, match *decisionLogPolicy, path string) (*models.PolicyEvaluation, error) {
+	// Decisions on a rule inside the package are placed back into the package
+	// document so the decision is derived the same way as for backend evaluations
+	doc := entry.Result
+	rest := strings.TrimPrefix(strings.TrimPrefix(path, match.root), "/")
+	if rest != "" {
+		segments := strings.Split(rest, "/")
+		for i := len(segments) - 1; i >= 0; i-- {
+			doc = map[string]interface{}{segments[i]: doc}
+		}
+	}
+	result := newEvaluationResult(doc)
+
+	inputJSON, err := json.Marshal(entry.Input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal decision input: %v", err)
+	}
+	outputJSON, err := json.Marshal(result.Result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal decision result: %v", err)
+	}
+
+	createdAt := entry.Timestamp
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	return &models.PolicyEvaluation{
+		PolicyID:       match.policy.ID,
+		Input:          string(inputJSON),
+		Output:         string(outputJSON),
+		Decision:       result.Decision,
+		Duration:       decisionLogDuration(entry.Metrics),
+		Source:         models.EvaluationSourceDecisionLog,
+		DecisionID:     entry.DecisionID,
+		BundleRevision: decisionLogRevision(entry.Bundles),
+		Path:           path,
+		CreatedAt:      createdAt,
+	}, nil
+}
+
+// decisionLogDuration reports the decision latency in milliseconds from the
+// entry's timers, preferring the full server handler time
+func decisionLogDuration(metrics map[string]float64) int64 {
+	for _, timer := range []string{"timer_server_handler_ns", "timer_rego_query_eval_ns"} {
+		if ns, ok := metrics[timer]; ok {
+			return time.Duration(ns).Milliseconds()
+		}
+	}
+	return 0
+}
+
+// decisionLogRevision returns the revision of the bundle that served the decision.
+// Agents loading several bundles report the revision of the first one by name.
+func decisionLogRevision(bundles map[string]BundleInfo) string {
+	names := make([]string, 0, len(bundles))
+	for name := range bundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if revision := bundles[name].Revision; revision != "" {
+			return revision
+		}
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decisionLogUpload is a batch in the format uploaded by OPA's decision_logs plugin
+const decisionLogUpload = `[
+  {
+    "labels": {"id": "agent-1", "version": "0.70.0"},
+    "decision_id": "4ca636c1-55e4-417a-b1d8-4aceb67960d1",
+    "bundles": {"niyama": {"revision": "rev-1"}},
+    "path": "kubernetes/security",
+    "input": {"kind": "Pod", "spec": {}},
+    "result": {"deny": ["pods must run as non-root"]},
+    "timestamp": "2024-05-01T10:00:00Z",
+    "metrics": {"timer_rego_query_eval_ns": 2000000, "timer_server_handler_ns": 3500000}
+  },
+  {
+    "decision_id": "c0b3a6d2-1e0f-4f38-9a56-1f1d0f2b9c11",
+    "bundles": {"niyama": {"revision": "rev-1"}},
+    "path": "kubernetes/security/pods/allow",
+    "input": {"kind": "Pod"},
+    "result": true,
+    "timestamp": "2024-05-01T10:00:01Z"
+  },
+  {
+    "decision_id": "0f7a9a5e-8d3b-4c1e-b6a2-7c9e2d4f1a00",
+    "path": "unknown/package",
+    "result": true
+  }
+]`
+
+func newTestDecisionLogService(t *testing.T) (*DecisionLogService, *database.Database) {
+	db := &database.Database{DB: setupTestDB(t)}
+	return NewDecisionLogService(db, &config.Config{}), db
+}
+
+func TestDecisionLogService_Ingest(t *testing.T) {
+	service, db := newTestDecisionLogService(t)
+	org := createTestOrganization(t, db, "acme")
+
+	policies := []models.Policy{
+		{Name: "security", Content: podSecurityPolicy, Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "pods", Content: "package kubernetes.security.pods\n\nallow { true }", Status: models.StatusActive, OrganizationID: org.ID},
+	}
+	for i := range policies {
+		require.NoError(t, db.DB.Create(&policies[i]).Error)
+	}
+
+	var entries []DecisionLogEntry
+	require.NoError(t, json.Unmarshal([]byte(decisionLogUpload), &entries))
+
+	result, err := service.Ingest("acme", entries)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionLogIngestResult{Accepted: 2, Unmatched: 1}, *result)
+
+	var evaluations []models.PolicyEvaluation
+	require.NoError(t, db.DB.Order("id").Find(&evaluations).Error)
+	require.Len(t, evaluations, 2)
+
+	deny := evaluations[0]
+	assert.Equal(t, policies[0].ID, deny.PolicyID)
+	assert.Equal(t, models.DecisionDeny, deny.Decision)
+	assert.Equal(t, models.EvaluationSourceDecisionLog, deny.Source)
+	assert.Equal(t, "rev-1", deny.BundleRevision)
+	assert.Equal(t, "kubernetes/security", deny.Path)
+	assert.Equal(t, int64(3), deny.Duration)
+	assert.Nil(t, deny.UserID)
+	assert.JSONEq(t, `{"kind": "Pod", "spec": {}}`, deny.Input)
+	assert.Equal(t, 2024, deny.CreatedAt.Year())
+
+	// Rule decisions are attributed to the most specific package
+	allow := evaluations[1]
+	assert.Equal(t, policies[1].ID, allow.PolicyID)
+	assert.Equal(t, models.DecisionAllow, allow.Decision)
+	assert.JSONEq(t, `{"allow": true}`, allow.Output)
+
+	// Agents retry uploads, so decisions already recorded are skipped
+	result, err = service.Ingest(org.Slug, entries)
+	require.NoError(t, err)
+	assert.Equal(t, DecisionLogIngestResult{Duplicates: 2, Unmatched: 1}, *result)
+
+	_, err = service.Ingest("missing", entries)
+	assert.ErrorIs(t, err, ErrOrganizationNotFound)
+}
+
+func TestDecisionLogService_ReadUpload(t *testing.T) {
+	service, _ := newTestDecisionLogService(t)
+	service.cfg.DecisionLog.MaxBytes = 4096
+
+	gzipped := func(content string) *bytes.Buffer {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		return &buf
+	}
+
+	entries, err := service.ReadUpload(gzipped(decisionLogUpload), true)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	// A small gzip body that decompresses past the limit is rejected
+	bomb := gzipped(`[{"path": "` + strings.Repeat("a", 1<<20) + `"}]`)
+	require.Less(t, bomb.Len(), 4096)
+	_, err = service.ReadUpload(bomb, true)
+	assert.ErrorIs(t, err, ErrDecisionLogTooLarge)
+
+	_, err = service.ReadUpload(strings.NewReader(`[{"path": "`+strings.Repeat("a", 8192)+`"}]`), false)
+	assert.ErrorIs(t, err, ErrDecisionLogTooLarge)
+
+	_, err = service.ReadUpload(strings.NewReader(decisionLogUpload), true)
+	assert.ErrorIs(t, err, ErrInvalidDecisionLog)
+	_, err = service.ReadUpload(strings.NewReader(`{"path": "kubernetes"}`), false)
+	assert.ErrorIs(t, err, ErrInvalidDecisionLog)
+}
+
+func TestDecisionLogService_HistoryFilter(t *testing.T) {
+	service, db := newTestDecisionLogService(t)
+	createTestOrganization(t, db, "acme")
+
+	policies := NewPolicyService(db, &config.Config{OPA: config.OPAConfig{Mode: OPAModeEmbedded, Timeout: 5 * time.Second}})
+	policy := &models.Policy{Name: "security", Content: podSecurityPolicy}
+	require.NoError(t, policies.CreatePolicy(policy, 1, 1))
+
+	_, err := policies.TestPolicy(policy.ID, map[string]interface{}{"kind": "Pod"}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	var entries []DecisionLogEntry
+	require.NoError(t, json.Unmarshal([]byte(decisionLogUpload), &entries))
+	_, err = service.Ingest("acme", entries)
+	require.NoError(t, err)
+
+	// Without a more specific package, rule decisions below the package belong to it
+	evaluations, total, err := policies.GetEvaluations(policy.ID, 1, 1, models.RoleAdmin, EvaluationFilter{Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, evaluations, 3)
+
+	evaluations, total, err = policies.GetEvaluations(policy.ID, 1, 1, models.RoleAdmin, EvaluationFilter{
+		Source:         models.EvaluationSourceDecisionLog,
+		BundleRevision: "rev-1",
+		Limit:          10,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, evaluations, 2)
+	assert.Equal(t, "kubernetes/security", evaluations[1].Path)
+
+	_, total, err = policies.GetEvaluations(policy.ID, 1, 1, models.RoleAdmin, EvaluationFilter{Source: models.EvaluationSourceAPI, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestDecisionLogPath(t *testing.T) {
+	assert.Equal(t, "kubernetes/security/deny", decisionLogPath("kubernetes/security/deny"))
+	assert.Equal(t, "kubernetes/security/deny", decisionLogPath("data.kubernetes.security.deny"))
+	assert.Equal(t, "kubernetes/security", decisionLogPath("/kubernetes/security/"))
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"niyama-backend/internal/models"
+)
+
+// ErrUnsupportedLanguage is returned when no engine is registered for a policy's language
+var ErrUnsupportedLanguage = errors.New("unsupported policy language")
+
+// PolicyEngine compiles, validates and evaluates policies written in one language
+type PolicyEngine interface {
+	// Language is the Policy.Language the engine handles, in lower case
+	Language() string
+	// Validate returns the diagnostics that reject policy content, empty when it compiles
+	Validate(content string) []PolicyDiagnostic
+	// Compile prepares a policy for evaluation, returning a ValidationError for invalid content
+	Compile(ctx context.Context, policy *models.Policy) error
+	// Evaluate evaluates a policy against an input document
+	Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error)
+}
+
+// PolicyEngines routes policies to the engine registered for their language.
+// It is the PolicyEvaluator of the PolicyService.
+type PolicyEngines struct {
+	engines map[string]PolicyEngine
+}
+
+func NewPolicyEngines(engines ...PolicyEngine) *PolicyEngines {
+	registry := &PolicyEngines{engines: make(map[string]PolicyEngine)}
+	for _, engine := range engines {
+		registry.Register(engine)
+	}
+	return registry
+}
+
+// Register adds an engine, replacing any engine registered for the same language
+func (r *PolicyEngines) Register(engine PolicyEngine) {
+	r.engines[normalizeLanguage(engine.Language())] = engine
+}
+
+// Engine returns the engine for a policy language; an empty language is Rego.
+// The error for an unknown language lists the supported ones.
+func (r *PolicyEngines) Engine(language string) (PolicyEngine, error) {
+	engine, ok := r.engines[normalizeLanguage(language)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s (supported: %s)", ErrUnsupportedLanguage, language, strings.Join(r.Languages(), ", "))
+	}
+	return engine, nil
+}
+
+// Languages lists the registered languages in alphabetical order
+func (r *PolicyEngines) Languages() []string {
+	languages := make([]string, 0, len(r.engines))
+	for language := range r.engines {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Validate returns the diagnostics of a policy from the engine for its language
+func (r *PolicyEngines) Validate(policy *models.Policy) []PolicyDiagnostic {
+	engine, err := r.Engine(policy.Language)
+	if err != nil {
+		return []PolicyDiagnostic{{Line: 1, Column: 1, Code: "unsupported_language", Message: err.Error()}}
+	}
+	return engine.Validate(policy.Content)
+}
+
+// Compile prepares a policy with the engine for its language
+func (r *PolicyEngines) Compile(ctx context.Context, policy *models.Policy) error {
+	engine, err := r.Engine(policy.Language)
+	if err != nil {
+		return err
+	}
+	return engine.Compile(ctx, policy)
+}
+
+// Evaluate evaluates a policy with the engine for its language
+func (r *PolicyEngines) Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error) {
+	engine, err := r.Engine(policy.Language)
+	if err != nil {
+		return nil, err
+	}
+	return engine.Evaluate(ctx, policy, input)
+}
+
+// normalizeLanguage lower-cases a policy language, defaulting to Rego
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if language == "" {
+		return models.LanguageRego
+	}
+	return language
+}
+
+// RegoEngine evaluates Rego policies with the evaluator selected by the OPA
+// configuration, embedded or remote
+type RegoEngine struct {
+	evaluator PolicyEvaluator
+}
+
+func NewRegoEngine(evaluator PolicyEvaluator) *RegoEngine {
+	return &RegoEngine{evaluator: evaluator}
+}
+
+func (e *RegoEngine) Language() string {
+	return models.LanguageRego
+}
+
+// Validate compiles the module on its own and returns its diagnostics
+func (e *RegoEngine) Validate(content string) []PolicyDiagnostic {
+	return regoDiagnostics(content)
+}
+
+// Compile validates the module and, when evaluating in-process, caches its prepared query.
+// A remote OPA server compiles modules as they are uploaded.
+func (e *RegoEngine) Compile(ctx context.Context, policy *models.Policy) error {
+	if diagnostics := regoDiagnostics(policy.Content); len(diagnostics) > 0 {
+		return &ValidationError{Diagnostics: diagnostics}
+	}
+	if embedded, ok := e.evaluator.(*RegoEvaluator); ok {
+		_, err := embedded.prepare(ctx, policy, nil)
+		return err
+	}
+	return nil
+}
+
+func (e *RegoEngine) Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error) {
+	return e.evaluator.Evaluate(ctx, policy, input)
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// ErrInvalidPatch is returned when a policy's patch rule produces an operation that is
+// malformed or does not apply to the object being mutated
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// PatchOperation is one RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON writes the value of add, replace and test operations even when it is
+// null, and leaves it out of the operations that take none
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	switch op.Op {
+	case "add", "replace", "test":
+		return json.Marshal(struct {
+			Op    string      `json:"op"`
+			Path  string      `json:"path"`
+			Value interface{} `json:"value"`
+		}{op.Op, op.Path, op.Value})
+	default:
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+			From string `json:"from,omitempty"`
+		}{op.Op, op.Path, op.From})
+	}
+}
+
+// patchOperations reads the operations produced by a patch rule. Rules may produce a
+// set or an array of operations, or a single operation.
+func patchOperations(value interface{}) ([]PatchOperation, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		items = v
+	case map[string]interface{}:
+		items = []interface{}{v}
+	default:
+		return nil, fmt.Errorf("%w: patch must be a set of operations", ErrInvalidPatch)
+	}
+
+	operations := make([]PatchOperation, 0, len(items))
+	for i, item := range items {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: operation %d is not an object", ErrInvalidPatch, i)
+		}
+		op, err := newPatchOperation(object)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d: %v", ErrInvalidPatch, i, err)
+		}
+		operations = append(operations, op)
+	}
+	return operations, nil
+}
+
+func newPatchOperation(object map[string]interface{}) (PatchOperation, error) {
+	var op PatchOperation
+	var ok bool
+	if op.Op, ok = object["op"].(string); !ok {
+		return op, fmt.Errorf("op is required")
+	}
+	if op.Path, ok = object["path"].(string); !ok {
+		return op, fmt.Errorf("path is required")
+	}
+	if err := checkPointer(op.Path); err != nil {
+		return op, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value, ok = object["value"]; !ok {
+			return op, fmt.Errorf("%s requires a value", op.Op)
+		}
+	case "move", "copy":
+		if op.From, ok = object["from"].(string); !ok {
+			return op, fmt.Errorf("%s requires from", op.Op)
+		}
+		if err := checkPointer(op.From); err != nil {
+			return op, err
+		}
+	case "remove":
+	default:
+		return op, fmt.Errorf("unknown op %q", op.Op)
+	}
+	return op, nil
+}
+
+// applyPatch applies operations to a copy of doc and returns the patched copy
+func applyPatch(doc interface{}, operations []PatchOperation) (interface{}, error) {
+	encodedPatch, err := json.Marshal(operations)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	patch, err := jsonpatch.DecodePatch(encodedPatch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	options := jsonpatch.NewApplyOptions()
+	// Negative indices are an extension of the library, not RFC 6902
+	options.SupportNegativeIndices = false
+	patched, err := patch.ApplyWithOptions(encoded, options)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkPointer rejects paths that are not RFC 6901 JSON pointers
+func checkPointer(pointer string) error {
+	if pointer != "" && !strings.HasPrefix(pointer, "/") {
+		return fmt.Errorf("path %q must start with /", pointer)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyPatch(t *testing.T) {
+	doc := func() interface{} {
+		var v interface{}
+		json.Unmarshal([]byte(`{"a": {"b": 1}, "list": ["x", "y"], "k~/": true}`), &v)
+		return v
+	}
+
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"add member", `[{"op": "add", "path": "/a/c", "value": 2}]`, `{"a": {"b": 1, "c": 2}, "list": ["x", "y"], "k~/": true}`},
+		{"add replaces member", `[{"op": "add", "path": "/a/b", "value": 5}]`, `{"a": {"b": 5}, "list": ["x", "y"], "k~/": true}`},
+		{"insert into array", `[{"op": "add", "path": "/list/1", "value": "z"}]`, `{"a": {"b": 1}, "list": ["x", "z", "y"], "k~/": true}`},
+		{"append to array", `[{"op": "add", "path": "/list/-", "value": "z"}]`, `{"a": {"b": 1}, "list": ["x", "y", "z"], "k~/": true}`},
+		{"remove", `[{"op": "remove", "path": "/list/0"}, {"op": "remove", "path": "/k~0~1"}]`, `{"a": {"b": 1}, "list": ["y"]}`},
+		{"replace", `[{"op": "replace", "path": "/a", "value": []}]`, `{"a": [], "list": ["x", "y"], "k~/": true}`},
+		{"move", `[{"op": "move", "from": "/a/b", "path": "/b"}]`, `{"a": {}, "b": 1, "list": ["x", "y"], "k~/": true}`},
+		{"copy", `[{"op": "copy", "from": "/list", "path": "/a/list"}]`, `{"a": {"b": 1, "list": ["x", "y"]}, "list": ["x", "y"], "k~/": true}`},
+		{"test", `[{"op": "test", "path": "/a/b", "value": 1}]`, `{"a": {"b": 1}, "list": ["x", "y"], "k~/": true}`},
+		{"null value", `[{"op": "replace", "path": "/a/b", "value": null}]`, `{"a": {"b": null}, "list": ["x", "y"], "k~/": true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			require.NoError(t, json.Unmarshal([]byte(tt.patch), &raw))
+			operations, err := patchOperations(raw)
+			require.NoError(t, err)
+
+			original := doc()
+			patched, err := applyPatch(original, operations)
+			require.NoError(t, err)
+			got, _ := json.Marshal(patched)
+			assert.JSONEq(t, tt.want, string(got))
+			assert.Equal(t, doc(), original, "the input document is not modified")
+		})
+	}
+
+	invalid := []string{
+		`"add"`,
+		`[{"op": "merge", "path": "/a"}]`,
+		`[{"op": "add", "path": "a", "value": 1}]`,
+		`[{"op": "add", "path": "/a"}]`,
+		`[{"op": "move", "path": "/a"}]`,
+		`[{"op": "remove", "path": "/missing"}]`,
+		`[{"op": "add", "path": "/missing/b", "value": 1}]`,
+		`[{"op": "add", "path": "/list/3", "value": 1}]`,
+		`[{"op": "replace", "path": "/list/-1", "value": 1}]`,
+		`[{"op": "move", "from": "/a", "path": "/a/c"}]`,
+		`[{"op": "test", "path": "/a/b", "value": 2}]`,
+	}
+	for _, patch := range invalid {
+		var raw interface{}
+		require.NoError(t, json.Unmarshal([]byte(patch), &raw))
+		operations, err := patchOperations(raw)
+		if err == nil {
+			_, err = applyPatch(doc(), operations)
+		}
+		assert.ErrorIs(t, err, ErrInvalidPatch, patch)
+	}
+}
+
+func TestPatchOperation_MarshalJSON(t *testing.T) {
+	encoded, err := json.Marshal([]PatchOperation{
+		{Op: "add", Path: "/a", Value: nil},
+		{Op: "test", Path: "/b", Value: false},
+		{Op: "remove", Path: "/c"},
+		{Op: "move", Path: "/d", From: "/e"},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"op": "add", "path": "/a", "value": null},
+		{"op": "test", "path": "/b", "value": false},
+		{"op": "remove", "path": "/c"},
+		{"op": "move", "path": "/d", "from": "/e"}
+	]`, string(encoded))
+}
@@ -1,8 +1,12 @@
 package services
 
 import (
+	"fmt"
+	"time"
+
 	"niyama-backend/internal/config"
 	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
 )
 
 type MonitoringService struct {
@@ -16,3 +20,50 @@ func NewMonitoringService(db *database.Database, cfg *config.Config) *Monitoring
 		cfg: cfg,
 	}
 }
+
+// Alert is a condition operators should look at
+type Alert struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"` // error, warning or info
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+	Severity  string    `json:"severity"` // high, medium or low
+}
+
+// dataSourceAlertThreshold is the number of consecutive failures that makes a
+// failing data source a high severity alert
+const dataSourceAlertThreshold = 3
+
+// DataSourceAlerts reports the organization's data sources whose last fetch failed.
+// Policies keep evaluating against the last document such a source wrote.
+func (s *MonitoringService) DataSourceAlerts(orgID uint) ([]Alert, error) {
+	if s.db == nil {
+		return nil, nil
+	}
+
+	var sources []models.DataSource
+	err := s.db.DB.Where("organization_id = ? AND consecutive_failures > 0", orgID).
+		Order("consecutive_failures DESC, id").Find(&sources).Error
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := make([]Alert, 0, len(sources))
+	for _, source := range sources {
+		alert := Alert{
+			ID:       fmt.Sprintf("data-source-%d", source.ID),
+			Type:     "warning",
+			Message:  fmt.Sprintf("Data source %q failed %d time(s) in a row: %s", source.Name, source.ConsecutiveFailures, source.LastError),
+			Severity: "medium",
+		}
+		if source.ConsecutiveFailures >= dataSourceAlertThreshold {
+			alert.Type = "error"
+			alert.Severity = "high"
+		}
+		if source.LastFetchedAt != nil {
+			alert.Timestamp = *source.LastFetchedAt
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
@@ -0,0 +1,427 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+)
+
+// Evaluation modes selectable through OPAConfig.Mode
+const (
+	OPAModeEmbedded = "embedded"
+	OPAModeRemote   = "remote"
+)
+
+// PolicyEvaluator evaluates a stored policy against an input document
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error)
+}
+
+// EvaluationResult is the outcome of a single policy evaluation
+type EvaluationResult struct {
+	Decision    string      `json:"decision"`
+	Violations  []string    `json:"violations"`
+	Waived      []string    `json:"waived,omitempty"`       // violations waived by an exception
+	ExceptionID *uint       `json:"exception_id,omitempty"` // PolicyException that waived the deny
+	Result      interface{} `json:"result"`                 // document produced by the policy package
+}
+
+// NewPolicyEvaluator returns the evaluator selected by the OPA configuration. Policies
+// of an organization are evaluated with the documents data returns mounted under data.
+func NewPolicyEvaluator(cfg config.OPAConfig, data PolicyDataLoader) PolicyEvaluator {
+	switch cfg.Mode {
+	case OPAModeRemote:
+		client := NewOPAClient(cfg.URL, cfg.Timeout)
+		client.data = data
+		return client
+	case OPAModeEmbedded, "":
+	default:
+		slog.Warn("Unknown OPA mode, using embedded evaluator", "mode", cfg.Mode)
+	}
+	evaluator := NewRegoEvaluator()
+	evaluator.data = data
+	return evaluator
+}
+
+// OPAClient evaluates policies against a remote OPA server through its REST API
+type OPAClient struct {
+	baseURL string
+	client  *http.Client
+	data    PolicyDataLoader
+	adhoc   atomic.Uint64 // numbers the modules of policies that are not stored
+
+	mu     sync.Mutex
+	pushed map[uint]*opaPushedData
+}
+
+// opaPushedData is the revision of an organization's data on the OPA server
+type opaPushedData struct {
+	mu       sync.Mutex // held while the organization's data is uploaded
+	uploaded bool
+	revision uint
+}
+
+func NewOPAClient(baseURL string, timeout time.Duration) *OPAClient {
+	return &OPAClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+		pushed:  make(map[uint]*opaPushedData),
+	}
+}
+
+var packagePattern = regexp.MustCompile(`(?m)^\s*package\s+([A-Za-z_][\w.]*)`)
+
+// PackagePath returns the package declared by a Rego module, e.g. "kubernetes.security"
+func PackagePath(content string) (string, error) {
+	match := packagePattern.FindStringSubmatch(content)
+	if match == nil {
+		return "", fmt.Errorf("policy has no package declaration")
+	}
+	return match[1], nil
+}
+
+// Evaluate uploads the policy module to OPA and queries its package document. The
+// server is shared, so the module is not uploaded as written: OPA merges the modules
+// that declare the same package, and data holds every organization's documents. The
+// module is moved to a package of its own and reads the documents of the policy's
+// organization under data instead. Modules of policies that are not stored, such as
+// candidates and templates, are removed again after the query.
+func (c *OPAClient) Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error) {
+	id, pkg := c.moduleLocation(policy)
+	module, err := isolateModule(policy.Content, pkg, opaDataRoot(policy.OrganizationID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.putPolicy(ctx, id, module); err != nil {
+		return nil, err
+	}
+	if policy.ID == 0 {
+		defer c.deletePolicy(id)
+	}
+	if err := c.syncData(ctx, policy.OrganizationID); err != nil {
+		return nil, err
+	}
+
+	result, err := c.queryData(ctx, refPath(pkg), input)
+	if err != nil {
+		return nil, err
+	}
+
+	return newEvaluationResult(result), nil
+}
+
+// moduleLocation returns the module ID and package a policy is uploaded under. A stored
+// policy keeps its module between evaluations; any other module gets a new ID each
+// time, so concurrent evaluations of the same content do not remove each other's.
+func (c *OPAClient) moduleLocation(policy *models.Policy) (string, ast.Ref) {
+	if policy.ID != 0 {
+		return opaPolicyID(policy), ast.MustParseRef(fmt.Sprintf("data.niyama.policies.p%d", policy.ID))
+	}
+	hash := sha256.Sum256([]byte(policy.Content))
+	name := fmt.Sprintf("adhoc_%x_%d", hash[:8], c.adhoc.Add(1))
+	return "niyama/" + name, ast.MustParseRef("data.niyama.adhoc." + name)
+}
+
+// opaDataRoot returns where an organization's data documents are kept on the server
+func opaDataRoot(orgID uint) ast.Ref {
+	return ast.MustParseRef("data.niyama.organizations").Append(ast.StringTerm(fmt.Sprint(orgID)))
+}
+
+// isolateModule moves a module to the package pkg. References to the module's own
+// package move along with it and any other reference under data is resolved below
+// dataRoot.
+func isolateModule(content string, pkg, dataRoot ast.Ref) (string, error) {
+	module, err := ast.ParseModule("policy.rego", content)
+	if err != nil {
+		return "", err
+	}
+	original := module.Package.Path
+
+	transformer := ast.NewGenericTransformer(func(x interface{}) (interface{}, error) {
+		ref, ok := x.(ast.Ref)
+		switch {
+		case !ok:
+			return x, nil
+		case ref.HasPrefix(original):
+			return pkg.Concat(ref[len(original):]), nil
+		case ref[0].Equal(ast.DefaultRootDocument):
+			return dataRoot.Concat(ref[1:]), nil
+		}
+		return ref, nil
+	})
+	if _, err := ast.Transform(transformer, module); err != nil {
+		return "", err
+	}
+	// Transform skips the declarations of "some x in xs"
+	var declErr error
+	ast.WalkExprs(module, func(expr *ast.Expr) bool {
+		if decl, ok := expr.Terms.(*ast.SomeDecl); ok {
+			for i := range decl.Symbols {
+				value, err := ast.Transform(transformer, decl.Symbols[i].Value)
+				if err != nil {
+					declErr = err
+					return true
+				}
+				decl.Symbols[i].Value = value.(ast.Value)
+			}
+		}
+		return false
+	})
+	if declErr != nil {
+		return "", declErr
+	}
+
+	formatted, err := format.Ast(module)
+	if err != nil {
+		return "", fmt.Errorf("failed to format policy: %v", err)
+	}
+	return string(formatted), nil
+}
+
+// refPath returns the /v1/data path of a data reference, e.g. "niyama/policies/p1"
+func refPath(ref ast.Ref) string {
+	segments := make([]string, 0, len(ref)-1)
+	for _, term := range ref[1:] {
+		if s, ok := term.Value.(ast.String); ok {
+			segments = append(segments, string(s))
+		} else {
+			segments = append(segments, term.String())
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// putPolicy creates or replaces a policy module on the OPA server
+func (c *OPAClient) putPolicy(ctx context.Context, id, content string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/v1/policies/"+id, strings.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to create OPA request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call OPA: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return opaError(resp)
+	}
+	return nil
+}
+
+// deletePolicy removes a policy module from the OPA server. It runs after the
+// evaluation that uploaded the module, so it is not bound to the request's context.
+func (c *OPAClient) deletePolicy(id string) {
+	req, err := http.NewRequest(http.MethodDelete, c.baseURL+"/v1/policies/"+id, nil)
+	if err != nil {
+		return
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		slog.Warn("Failed to remove OPA module", "module", id, "error", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		slog.Warn("Failed to remove OPA module", "module", id, "error", opaError(resp))
+	}
+}
+
+// syncData uploads the organization's data documents to its data root when they
+// changed since the last upload. Uploads of one organization are serialized so an
+// older snapshot cannot replace a newer one; other organizations do not wait.
+func (c *OPAClient) syncData(ctx context.Context, orgID uint) error {
+	if c.data == nil || orgID == 0 {
+		return nil
+	}
+	snapshot, err := c.data(orgID)
+	if err != nil {
+		return fmt.Errorf("failed to load policy data: %v", err)
+	}
+
+	c.mu.Lock()
+	pushed, ok := c.pushed[orgID]
+	if !ok {
+		pushed = &opaPushedData{}
+		c.pushed[orgID] = pushed
+	}
+	c.mu.Unlock()
+
+	pushed.mu.Lock()
+	defer pushed.mu.Unlock()
+	if pushed.uploaded && pushed.revision >= snapshot.Revision {
+		return nil
+	}
+
+	// The whole tree replaces the previous one, removing deleted documents with it
+	body, err := json.Marshal(snapshot.Tree())
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy data: %v", err)
+	}
+	if err := c.putData(ctx, refPath(opaDataRoot(orgID)), body); err != nil {
+		return err
+	}
+	pushed.uploaded, pushed.revision = true, snapshot.Revision
+	return nil
+}
+
+// putData creates or replaces a base document on the OPA server
+func (c *OPAClient) putData(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/v1/data/"+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create OPA request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call OPA: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return opaError(resp)
+	}
+	return nil
+}
+
+// queryData evaluates the document at path with the given input
+func (c *OPAClient) queryData(ctx context.Context, path string, input map[string]interface{}) (interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal OPA input: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/v1/data/"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OPA request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call OPA: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, opaError(resp)
+	}
+
+	var dataResp struct {
+		Result interface{} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&dataResp); err != nil {
+		return nil, fmt.Errorf("failed to decode OPA response: %v", err)
+	}
+
+	return dataResp.Result, nil
+}
+
+// opaError converts an OPA error response into a Go error
+func opaError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var opaErr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &opaErr); err == nil && opaErr.Message != "" {
+		messages := []string{opaErr.Message}
+		for _, e := range opaErr.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("OPA error %d: %s", resp.StatusCode, strings.Join(messages, ": "))
+	}
+
+	return fmt.Errorf("OPA error %d: %s", resp.StatusCode, string(body))
+}
+
+// opaPolicyID returns a stable module ID for a policy
+func opaPolicyID(policy *models.Policy) string {
+	if policy.ID != 0 {
+		return fmt.Sprintf("niyama/policy-%d", policy.ID)
+	}
+	hash := sha256.Sum256([]byte(policy.Content))
+	return fmt.Sprintf("niyama/adhoc-%x", hash[:8])
+}
+
+// newEvaluationResult derives a decision from a package document.
+// Any deny or violation messages deny the request; otherwise an allow rule,
+// when present, decides. A package with neither allows by default.
+func newEvaluationResult(result interface{}) *EvaluationResult {
+	evaluation := &EvaluationResult{
+		Decision:   models.DecisionAllow,
+		Violations: []string{},
+		Result:     result,
+	}
+
+	doc, ok := result.(map[string]interface{})
+	if !ok {
+		return evaluation
+	}
+
+	for _, rule := range []string{"deny", "violation"} {
+		evaluation.Violations = append(evaluation.Violations, ruleMessages(doc[rule])...)
+	}
+
+	if len(evaluation.Violations) > 0 {
+		evaluation.Decision = models.DecisionDeny
+	} else if allow, ok := doc["allow"]; ok && allow != true {
+		evaluation.Decision = models.DecisionDeny
+	}
+
+	return evaluation
+}
+
+// ruleMessages flattens the value of a deny/violation rule into messages.
+// Rules may produce strings, objects with a "msg" field, or plain booleans.
+func ruleMessages(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case bool:
+		if v {
+			return []string{"denied"}
+		}
+		return nil
+	case string:
+		return []string{v}
+	case []interface{}:
+		var messages []string
+		for _, item := range v {
+			messages = append(messages, ruleMessages(item)...)
+		}
+		return messages
+	case map[string]interface{}:
+		if msg, ok := v["msg"].(string); ok {
+			return []string{msg}
+		}
+	}
+
+	encoded, _ := json.Marshal(value)
+	return []string{string(encoded)}
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrOrganizationNotFound is returned when an organization ID or slug does not resolve
+var ErrOrganizationNotFound = errors.New("organization not found")
+
+// findOrganization resolves an organization by numeric ID or slug
+func findOrganization(db *database.Database, ref string) (*models.Organization, error) {
+	var org models.Organization
+	query := db.DB
+	if id, err := strconv.ParseUint(ref, 10, 32); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("slug = ?", ref)
+	}
+
+	if err := query.First(&org).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrganizationNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
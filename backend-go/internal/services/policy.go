@@ -1,23 +1,44 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"niyama-backend/internal/config"
 	"niyama-backend/internal/database"
 	"niyama-backend/internal/models"
+	"sync"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type PolicyService struct {
-	db  *database.Database
-	cfg *config.Config
+	db         *database.Database
+	cfg        *config.Config
+	engines    *PolicyEngines
+	evaluator  PolicyEvaluator // the engines, dispatching on Policy.Language
+	replays    sync.WaitGroup  // running replay jobs
+	exports    sync.WaitGroup  // running exports
+	exportMu   sync.Mutex      // exports run one at a time so their pushes do not race
+	data       *policyDataCache
+	dataClient *http.Client // fetches data sources
 }
 
 func NewPolicyService(db *database.Database, cfg *config.Config) *PolicyService {
-	return &PolicyService{
-		db:  db,
-		cfg: cfg,
+	service := &PolicyService{
+		db:         db,
+		cfg:        cfg,
+		data:       newPolicyDataCache(),
+		dataClient: newDataSourceClient(&cfg.Data),
 	}
+	service.engines = NewPolicyEngines(
+		NewRegoEngine(NewPolicyEvaluator(cfg.OPA, service.loadPolicyData)),
+		NewCedarEngine(),
+	)
+	service.evaluator = service.engines
+	return service
 }
 
 // CreatePolicy creates a new policy with organization and RBAC support
@@ -42,18 +63,47 @@ func (s *PolicyService) CreatePolicy(policy *models.Policy, userID, orgID uint)
 		policy.Status = models.StatusDraft
 	}
 
-	return s.db.DB.Create(policy).Error
+	if err := s.validatePolicy(policy); err != nil {
+		return err
+	}
+	if err := s.checkNewPolicyStatus(policy); err != nil {
+		return err
+	}
+
+	policy.Version = 1
+	err := s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(policy).Error; err != nil {
+			return err
+		}
+		message := policy.ChangeMessage
+		if message == "" {
+			message = "Initial version"
+		}
+		return recordVersion(tx, policy.ID, policy.Version, policy.Content, policy.Language, userID, message)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.startExport(policy, models.SourceExportUpdate, policy.SourceID)
+	return nil
 }
 
-// GetPolicies retrieves policies based on user permissions and organization
-func (s *PolicyService) GetPolicies(userID, orgID uint, userRole models.Role) ([]models.Policy, error) {
+// GetPolicies retrieves policies based on user permissions and organization,
+// narrowed by the query's filters and search and paged by its cursor
+func (s *PolicyService) GetPolicies(userID, orgID uint, userRole models.Role, q PolicyQuery) (*PolicyPage, error) {
 	if s.db == nil {
 		// Return mock data for development
-		return s.getMockPolicies(), nil
+		policies := s.getMockPolicies()
+		return &PolicyPage{Policies: policies, Total: int64(len(policies)), Facets: policyFacets(policies)}, nil
 	}
 
-	var policies []models.Policy
-	query := s.db.DB.Preload("Author").Preload("Organization")
+	sortColumn, err := policySortColumn(q.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.db.DB.Model(&models.Policy{})
 
 	// Apply RBAC filters
 	switch userRole {
@@ -62,7 +112,7 @@ func (s *PolicyService) GetPolicies(userID, orgID uint, userRole models.Role) ([
 		query = query.Where("organization_id = ?", orgID)
 	case models.RoleEditor, models.RoleViewer:
 		// Can see org and public policies
-		query = query.Where("organization_id = ? OR access_level = ?", orgID, models.AccessPublic)
+		query = query.Where("(organization_id = ? OR access_level = ?)", orgID, models.AccessPublic)
 	case models.RoleMember:
 		// Can only see public policies
 		query = query.Where("access_level = ?", models.AccessPublic)
@@ -71,8 +121,35 @@ func (s *PolicyService) GetPolicies(userID, orgID uint, userRole models.Role) ([
 		query = query.Where("access_level = ?", models.AccessPublic)
 	}
 
-	err := query.Find(&policies).Error
-	return policies, err
+	query = s.filterPolicies(query, q)
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	// Facets count every matching policy, not only the current page
+	var facetRows []models.Policy
+	if err := query.Session(&gorm.Session{}).Select("tags", "category").Find(&facetRows).Error; err != nil {
+		return nil, err
+	}
+
+	page, err := pagePolicies(query.Session(&gorm.Session{}), sortColumn, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []models.Policy
+	if err := page.Preload("Author").Preload("Organization").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+
+	result := &PolicyPage{Policies: policies, Total: total, Facets: policyFacets(facetRows)}
+	if limit := policyPageLimit(q.Limit); len(policies) > limit {
+		result.Policies = policies[:limit]
+		result.NextCursor = encodePolicyCursor(sortColumn, &policies[limit-1])
+	}
+	return result, nil
 }
 
 // GetPolicy retrieves a specific policy with permission check
@@ -96,30 +173,100 @@ func (s *PolicyService) GetPolicy(policyID, userID, orgID uint, userRole models.
 	return &policy, nil
 }
 
-// UpdatePolicy updates a policy with permission check
-func (s *PolicyService) UpdatePolicy(policyID uint, updates *models.Policy, userID, orgID uint, userRole models.Role) error {
+// UpdatePolicy updates a policy with permission check. Fields of updates that hold
+// their zero value are left unchanged, except for the given columns, which are always
+// written so that, for example, require_tests can be turned off.
+func (s *PolicyService) UpdatePolicy(policyID uint, updates *models.Policy, userID, orgID uint, userRole models.Role, columns ...string) error {
 	if s.db == nil {
 		return fmt.Errorf("database not available")
 	}
 
-	// Get existing policy
-	policy, err := s.GetPolicy(policyID, userID, orgID, userRole)
+	policy, err := s.getEditablePolicy(policyID, userID, orgID, userRole)
 	if err != nil {
 		return err
 	}
 
-	// Check if user can edit
-	if !s.canEditPolicy(policy, userID, userRole) {
-		return fmt.Errorf("insufficient permissions to edit policy")
+	// New content must compile in the policy's language
+	if updates.Content != "" {
+		candidate := *policy
+		candidate.Content = updates.Content
+		if updates.Language != "" {
+			candidate.Language = updates.Language
+		}
+		if err := s.validatePolicy(&candidate); err != nil {
+			return err
+		}
+	}
+
+	contentChanged := updates.Content != "" && updates.Content != policy.Content
+	if err := s.checkStatusTransition(policy, updates, contentChanged); err != nil {
+		return err
+	}
+	// A policy taken out of the archive is no longer waiting for its source file
+	if policy.Status == models.StatusArchived && updates.Status != "" && updates.Status != models.StatusArchived {
+		updates.ArchivedFrom = ""
+		columns = append(columns, "archived_from")
+	}
+
+	// Policies that require tests only take new content, go active, or start
+	// requiring tests when every test case passes against the resulting content
+	if policy.RequireTests || updates.RequireTests {
+		content := policy.Content
+		if updates.Content != "" {
+			content = updates.Content
+		}
+		activating := updates.Status == models.StatusActive && policy.Status != models.StatusActive
+		enabling := updates.RequireTests && !policy.RequireTests
+		if content != policy.Content || activating || enabling {
+			if err := s.checkPolicyTests(policy, content); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Content changes create a new version; the version number is never set by the client
+	updates.Version = 0
+	if contentChanged {
+		updates.Version = currentVersion(policy) + 1
 	}
 
 	// Update fields
 	updates.UpdatedAt = time.Now()
-	return s.db.DB.Model(policy).Updates(updates).Error
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if contentChanged {
+			if err := s.recordContentChange(tx, policy, updates, userID); err != nil {
+				return err
+			}
+			// Promoting the shadow candidate ends its shadow run
+			err := tx.Model(&models.PolicyShadow{}).
+				Where("policy_id = ? AND stopped_at IS NULL AND content = ?", policy.ID, updates.Content).
+				Update("stopped_at", time.Now()).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(policy).Updates(updates).Error; err != nil {
+			return err
+		}
+		if len(columns) > 0 {
+			return tx.Model(policy).Select(columns).Updates(updates).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Changes synced from a source are not committed back to it
+	if contentChanged {
+		s.startExport(policy, models.SourceExportUpdate, updates.SourceID)
+	}
+	return nil
 }
 
-// DeletePolicy deletes a policy with permission check
-func (s *PolicyService) DeletePolicy(policyID, userID, orgID uint, userRole models.Role) error {
+// DeletePolicy deletes a policy with permission check. Policies that other active
+// policies depend on are only deleted when force is set.
+func (s *PolicyService) DeletePolicy(policyID, userID, orgID uint, userRole models.Role, force bool) error {
 	if s.db == nil {
 		return fmt.Errorf("database not available")
 	}
@@ -135,14 +282,181 @@ func (s *PolicyService) DeletePolicy(policyID, userID, orgID uint, userRole mode
 		return fmt.Errorf("insufficient permissions to delete policy")
 	}
 
+	if !force {
+		dependents, err := s.activeDependents(policy)
+		if err != nil {
+			return err
+		}
+		if len(dependents) > 0 {
+			return &DependentsError{Dependents: dependents}
+		}
+	}
+
 	return s.db.DB.Delete(policy).Error
 }
 
 // TestPolicy evaluates a policy against test input
 func (s *PolicyService) TestPolicy(policyID uint, testInput map[string]interface{}, userID, orgID uint, userRole models.Role) (*models.PolicyEvaluation, error) {
-	// Always return mock evaluation for development
-	// This allows testing without database dependencies
-	return s.getMockEvaluation(policyID, testInput), nil
+	policy, err := s.getTestablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluation, _, err := s.evaluatePolicy(policy, s.activeShadow(policy.ID), s.activeExceptions(policy.ID), testInput, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.recordEvaluation(evaluation); err != nil {
+		return nil, err
+	}
+
+	return evaluation, nil
+}
+
+// getTestablePolicy loads a policy the user is allowed to evaluate
+func (s *PolicyService) getTestablePolicy(policyID, userID, orgID uint, userRole models.Role) (*models.Policy, error) {
+	policy, err := s.GetPolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, fmt.Errorf("policy not found")
+	}
+
+	// Check if user can test
+	if !s.canTestPolicy(policy, userID, userRole) {
+		return nil, fmt.Errorf("insufficient permissions to test policy")
+	}
+
+	return policy, nil
+}
+
+// getEditablePolicy loads a policy the user is allowed to edit
+func (s *PolicyService) getEditablePolicy(policyID, userID, orgID uint, userRole models.Role) (*models.Policy, error) {
+	policy, err := s.GetPolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if user can edit
+	if !s.canEditPolicy(policy, userID, userRole) {
+		return nil, fmt.Errorf("insufficient permissions to edit policy")
+	}
+
+	return policy, nil
+}
+
+// evaluatePolicy runs a single evaluation and builds the record to persist. A
+// shadow candidate, when given, is evaluated on the same input and recorded alongside.
+func (s *PolicyService) evaluatePolicy(policy *models.Policy, shadow *models.PolicyShadow, exceptions []models.PolicyException, input map[string]interface{}, userID uint) (*models.PolicyEvaluation, *EvaluationResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.OPA.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := s.evaluator.Evaluate(ctx, policy, input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("policy evaluation failed: %v", err)
+	}
+	duration := time.Since(start)
+
+	// An active exception matching the input waives a deny
+	exception := matchException(exceptions, input)
+	if exception != nil && result.Decision == models.DecisionDeny {
+		waive(result)
+		result.ExceptionID = &exception.ID
+	}
+
+	inputJSON, err := json.Marshal(input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal input: %v", err)
+	}
+	outputJSON, err := json.Marshal(result.Result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal output: %v", err)
+	}
+
+	evaluation := &models.PolicyEvaluation{
+		PolicyID:  policy.ID,
+		Input:     string(inputJSON),
+		Output:    string(outputJSON),
+		Decision:  result.Decision,
+		Duration:  duration.Milliseconds(),
+		UserID:    &userID,
+		Source:    models.EvaluationSourceAPI,
+		CreatedAt: time.Now(),
+	}
+	evaluation.ExceptionID = result.ExceptionID
+
+	if shadow != nil {
+		s.evaluateShadow(policy, shadow, exception, input, result, evaluation)
+	}
+
+	return evaluation, result, nil
+}
+
+// EvaluationFilter narrows the evaluation history of a policy
+type EvaluationFilter struct {
+	Decision       string
+	Source         string
+	BundleRevision string
+	From           *time.Time
+	To             *time.Time
+	Limit          int
+	Offset         int
+}
+
+// GetEvaluations returns the stored evaluations of a policy, newest first
+func (s *PolicyService) GetEvaluations(policyID, userID, orgID uint, userRole models.Role, filter EvaluationFilter) ([]models.PolicyEvaluation, int64, error) {
+	if s.db == nil {
+		return nil, 0, fmt.Errorf("database not available")
+	}
+
+	// Check access to the policy
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, 0, err
+	}
+
+	query := s.db.DB.Model(&models.PolicyEvaluation{}).Where("policy_id = ?", policyID)
+	if filter.Decision != "" {
+		query = query.Where("decision = ?", filter.Decision)
+	}
+	if filter.Source != "" {
+		query = query.Where("source = ?", filter.Source)
+	}
+	if filter.BundleRevision != "" {
+		query = query.Where("bundle_revision = ?", filter.BundleRevision)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var evaluations []models.PolicyEvaluation
+	err := query.Order("created_at DESC").Order("id DESC").
+		Limit(filter.Limit).Offset(filter.Offset).
+		Find(&evaluations).Error
+	return evaluations, total, err
+}
+
+// recordEvaluation persists an evaluation to the policy_evaluations table.
+// Without a database (development mode) evaluations are not recorded.
+func (s *PolicyService) recordEvaluation(evaluation *models.PolicyEvaluation) error {
+	if s.db == nil {
+		return nil
+	}
+
+	if err := s.db.DB.Create(evaluation).Error; err != nil {
+		return fmt.Errorf("failed to record evaluation: %v", err)
+	}
+	return nil
 }
 
 // Helper methods for RBAC
@@ -194,38 +508,49 @@ func (s *PolicyService) canTestPolicy(policy *models.Policy, userID uint, userRo
 func (s *PolicyService) getMockPolicies() []models.Policy {
 	return []models.Policy{
 		{
-			ID:             1,
-			Name:           "Kubernetes Security Policy",
-			Description:    "Ensures all pods have security contexts",
-			Content:        `package kubernetes.security\n\ndefault allow = false\n\nallow {\n    input.kind == "Pod"\n    input.spec.securityContext.runAsNonRoot == true\n}`,
+			ID:          1,
+			Name:        "Kubernetes Security Policy",
+			Description: "Ensures all pods have security contexts",
+			Content: `package kubernetes.security
+
+default allow = false
+
+allow {
+    input.kind == "Pod"
+    input.spec.securityContext.runAsNonRoot == true
+}`,
 			Language:       "rego",
 			Category:       "security",
 			Tags:           []string{"kubernetes", "security", "pods"},
 			Status:         models.StatusActive,
-			Version:        1,
 			AuthorID:       1,
 			OrganizationID: 1,
-			IsActive:       true,
-			IsPublic:       false,
 			AccessLevel:    models.AccessOrg,
+			Version:        1,
 			CreatedAt:      time.Now(),
 			UpdatedAt:      time.Now(),
 		},
 		{
-			ID:             2,
-			Name:           "Resource Limits Policy",
-			Description:    "Enforces resource limits on containers",
-			Content:        `package kubernetes.resources\n\ndefault allow = false\n\nallow {\n    input.kind == "Pod"\n    input.spec.containers[_].resources.limits.cpu\n    input.spec.containers[_].resources.limits.memory\n}`,
+			ID:          2,
+			Name:        "Resource Limits Policy",
+			Description: "Enforces resource limits on containers",
+			Content: `package kubernetes.resources
+
+default allow = false
+
+allow {
+    input.kind == "Pod"
+    input.spec.containers[_].resources.limits.cpu
+    input.spec.containers[_].resources.limits.memory
+}`,
 			Language:       "rego",
 			Category:       "resources",
 			Tags:           []string{"kubernetes", "resources", "limits"},
 			Status:         models.StatusActive,
-			Version:        1,
 			AuthorID:       1,
 			OrganizationID: 1,
-			IsActive:       true,
-			IsPublic:       true,
 			AccessLevel:    models.AccessPublic,
+			Version:        1,
 			CreatedAt:      time.Now(),
 			UpdatedAt:      time.Now(),
 		},
@@ -241,16 +566,3 @@ func (s *PolicyService) getMockPolicy(id uint) *models.Policy {
 	}
 	return nil
 }
-
-func (s *PolicyService) getMockEvaluation(policyID uint, testInput map[string]interface{}) *models.PolicyEvaluation {
-	return &models.PolicyEvaluation{
-		ID:        1,
-		PolicyID:  policyID,
-		Input:     fmt.Sprintf("%v", testInput),
-		Output:    `{"decision": "allow", "reason": "Policy evaluation successful", "details": {"resource": "pod", "namespace": "default"}}`,
-		Decision:  "allow",
-		Duration:  150,
-		UserID:    1,
-		CreatedAt: time.Now(),
-	}
-}
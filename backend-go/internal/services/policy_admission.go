@@ -0,0 +1,245 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"niyama-backend/internal/models"
+)
+
+// ErrInvalidAdmissionReview is returned for requests that are not admission.k8s.io/v1 AdmissionReviews
+var ErrInvalidAdmissionReview = errors.New("invalid admission review")
+
+// Identifiers of the AdmissionReview versions served by the admission webhook
+const (
+	AdmissionAPIVersion = "admission.k8s.io/v1"
+	AdmissionKind       = "AdmissionReview"
+)
+
+// AdmissionReview is the admission.k8s.io/v1 envelope exchanged with the Kubernetes API server
+type AdmissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest describes the object being admitted
+type AdmissionRequest struct {
+	UID       string                 `json:"uid"`
+	Kind      AdmissionGroupKind     `json:"kind"`
+	Resource  AdmissionResource      `json:"resource"`
+	Name      string                 `json:"name,omitempty"`
+	Namespace string                 `json:"namespace,omitempty"`
+	Operation string                 `json:"operation"`
+	UserInfo  AdmissionUserInfo      `json:"userInfo"`
+	Object    map[string]interface{} `json:"object,omitempty"`
+	OldObject map[string]interface{} `json:"oldObject,omitempty"`
+	DryRun    *bool                  `json:"dryRun,omitempty"`
+}
+
+// AdmissionGroupKind is the group, version and kind of an admitted object
+type AdmissionGroupKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+// AdmissionResource is the group, version and resource of an admitted object
+type AdmissionResource struct {
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+}
+
+// AdmissionUserInfo identifies the user making the request
+type AdmissionUserInfo struct {
+	Username string   `json:"username,omitempty"`
+	UID      string   `json:"uid,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// AdmissionResponse is the admission decision returned to the API server
+type AdmissionResponse struct {
+	UID       string           `json:"uid"`
+	Allowed   bool             `json:"allowed"`
+	Status    *AdmissionStatus `json:"status,omitempty"`
+	Warnings  []string         `json:"warnings,omitempty"`
+	PatchType *string          `json:"patchType,omitempty"`
+	Patch     []byte           `json:"patch,omitempty"` // base64 encoded JSONPatch of a mutating webhook
+}
+
+// AdmissionStatus explains a rejected request to the client
+type AdmissionStatus struct {
+	Code    int    `json:"code"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message"`
+}
+
+// ReviewAdmission evaluates the object of an AdmissionReview against the active Rego
+// policies of an organization (by ID or slug) and returns the AdmissionReview response.
+// The request is denied when any policy denies it or fails to evaluate; the deny
+// messages are aggregated into the response status. Every evaluation is recorded with
+// the admission request UID as its decision ID, except for dry-run requests, which
+// change nothing in the cluster. Requests without an object, such as deletes, are
+// allowed without evaluation.
+func (s *PolicyService) ReviewAdmission(orgRef string, review *AdmissionReview) (*AdmissionReview, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	if err := validateAdmissionReview(review); err != nil {
+		return nil, err
+	}
+
+	org, err := findOrganization(s.db, orgRef)
+	if err != nil {
+		return nil, err
+	}
+
+	request := review.Request
+	response := &AdmissionResponse{UID: request.UID, Allowed: true}
+	if request.Object == nil {
+		return newAdmissionReview(response), nil
+	}
+
+	policies, err := s.admissionPolicies(org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Policies are evaluated in parallel; their outcomes are read back in policy order
+	type outcome struct {
+		evaluation *models.PolicyEvaluation
+		result     *EvaluationResult
+		err        error
+	}
+	outcomes := make([]outcome, len(policies))
+	shadows, exceptions := s.policyOverrides(policies)
+
+	workers := s.cfg.OPA.BatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				policy := &policies[i]
+				evaluation, result, err := s.evaluatePolicy(policy, shadows[policy.ID], exceptions[policy.ID], request.Object, 0)
+				outcomes[i] = outcome{evaluation: evaluation, result: result, err: err}
+			}
+		}()
+	}
+	for i := range policies {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var messages []string
+	var evaluations []*models.PolicyEvaluation
+	for i, out := range outcomes {
+		policy := &policies[i]
+		evaluation, result := out.evaluation, out.result
+		if out.err != nil {
+			// Fail closed: an object no policy could judge is not admitted
+			messages = append(messages, fmt.Sprintf("[%s] %v", policy.Name, out.err))
+			continue
+		}
+
+		evaluation.UserID = nil
+		evaluation.Source = models.EvaluationSourceAdmission
+		evaluation.DecisionID = request.UID
+		evaluations = append(evaluations, evaluation)
+
+		if result.Decision == models.DecisionDeny {
+			if len(result.Violations) == 0 {
+				messages = append(messages, fmt.Sprintf("[%s] denied", policy.Name))
+			}
+			for _, violation := range result.Violations {
+				messages = append(messages, fmt.Sprintf("[%s] %s", policy.Name, violation))
+			}
+		}
+		for _, waived := range result.Waived {
+			response.Warnings = append(response.Warnings, fmt.Sprintf("[%s] %s (waived by exception %d)", policy.Name, waived, *result.ExceptionID))
+		}
+	}
+
+	if !isDryRun(request) {
+		if err := s.recordEvaluations(evaluations); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(messages) > 0 {
+		response.Allowed = false
+		response.Status = &AdmissionStatus{
+			Code:    http.StatusForbidden,
+			Reason:  "Forbidden",
+			Message: strings.Join(messages, "; "),
+		}
+	}
+
+	return newAdmissionReview(response), nil
+}
+
+// policyOverrides loads the running shadow candidates and active exceptions of
+// policies with one query each, keyed by policy ID
+func (s *PolicyService) policyOverrides(policies []models.Policy) (map[uint]*models.PolicyShadow, map[uint][]models.PolicyException) {
+	ids := make([]uint, len(policies))
+	for i := range policies {
+		ids[i] = policies[i].ID
+	}
+	return s.activeShadows(ids), s.activeExceptionsByPolicy(ids)
+}
+
+// isDryRun reports whether the API server will not persist the admitted object
+func isDryRun(request *AdmissionRequest) bool {
+	return request.DryRun != nil && *request.DryRun
+}
+
+// admissionPolicies loads the active Rego policies of an organization
+func (s *PolicyService) admissionPolicies(orgID uint) ([]models.Policy, error) {
+	var policies []models.Policy
+	err := s.db.DB.Where("organization_id = ? AND status = ?", orgID, models.StatusActive).
+		Order("id").Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Other languages evaluate requests of their own shape, not Kubernetes objects
+	var result []models.Policy
+	for _, policy := range policies {
+		if isRegoPolicy(&policy) {
+			result = append(result, policy)
+		}
+	}
+	return result, nil
+}
+
+func validateAdmissionReview(review *AdmissionReview) error {
+	if review.APIVersion != AdmissionAPIVersion || review.Kind != AdmissionKind {
+		return fmt.Errorf("%w: expected %s %s, got %s %s", ErrInvalidAdmissionReview, AdmissionAPIVersion, AdmissionKind, review.APIVersion, review.Kind)
+	}
+	if review.Request == nil {
+		return fmt.Errorf("%w: request is required", ErrInvalidAdmissionReview)
+	}
+	if review.Request.UID == "" {
+		return fmt.Errorf("%w: request uid is required", ErrInvalidAdmissionReview)
+	}
+	return nil
+}
+
+func newAdmissionReview(response *AdmissionResponse) *AdmissionReview {
+	return &AdmissionReview{
+		APIVersion: AdmissionAPIVersion,
+		Kind:       AdmissionKind,
+		Response:   response,
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdmissionWebhook serves the admission webhook of an organization over TLS, the
+// way the Kubernetes API server calls it
+func newAdmissionWebhook(t *testing.T, service *PolicyService, org string) *httptest.Server {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response, err := service.ReviewAdmission(org, &review)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPolicyService_ReviewAdmission(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded, BatchWorkers: 4})
+	org := createTestOrganization(t, db, "acme")
+	other := createTestOrganization(t, db, "other")
+
+	policies := []models.Policy{
+		{Name: "Pod Security", Content: podSecurityPolicy, Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "Host Network", Content: hostNetworkPolicy, Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "Draft", Content: "package drafts\n\ndeny[\"draft\"] { true }", Status: models.StatusDraft, OrganizationID: org.ID},
+		{Name: "Photos", Content: "forbid(principal, action, resource);", Language: models.LanguageCedar, Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "Foreign", Content: "package foreign\n\ndeny[\"foreign\"] { true }", Status: models.StatusActive, OrganizationID: other.ID},
+	}
+	for i := range policies {
+		require.NoError(t, db.DB.Create(&policies[i]).Error)
+	}
+
+	webhook := newAdmissionWebhook(t, service, "acme")
+	for _, fixture := range []string{"pod-create-root", "pod-create-nonroot", "pod-delete"} {
+		t.Run(fixture, func(t *testing.T) {
+			request, err := os.ReadFile(filepath.Join("testdata", "admission", fixture+".request.json"))
+			require.NoError(t, err)
+			expected, err := os.ReadFile(filepath.Join("testdata", "admission", fixture+".response.json"))
+			require.NoError(t, err)
+
+			resp, err := webhook.Client().Post(webhook.URL, "application/json", bytes.NewReader(request))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			assert.JSONEq(t, string(expected), string(body))
+		})
+	}
+
+	// Each policy's decision is recorded under the request UID
+	var evaluations []models.PolicyEvaluation
+	require.NoError(t, db.DB.Where("decision_id = ?", "705ab4f5-6393-11e8-b7cc-42010a800002").Order("policy_id").Find(&evaluations).Error)
+	require.Len(t, evaluations, 2)
+	assert.Equal(t, policies[0].ID, evaluations[0].PolicyID)
+	assert.Equal(t, models.EvaluationSourceAdmission, evaluations[0].Source)
+	assert.Equal(t, models.DecisionDeny, evaluations[0].Decision)
+	assert.Nil(t, evaluations[0].UserID)
+
+	var deletes int64
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Where("decision_id = ?", "3e1f4a2b-8c9d-4e5f-9a0b-1c2d3e4f5a6b").Count(&deletes).Error)
+	assert.Zero(t, deletes, "requests without an object are not evaluated")
+
+	// Approved exceptions turn denies into warnings
+	exception := &models.PolicyException{
+		PolicyID:      policies[1].ID,
+		Selector:      map[string]interface{}{"metadata.namespace": "kube-system"},
+		Justification: "CNI daemon set",
+		ExpiresAt:     time.Now().Add(time.Hour),
+		RequesterID:   1,
+	}
+	approver, approvedAt := uint(2), time.Now()
+	exception.ApproverID, exception.ApprovedAt = &approver, &approvedAt
+	require.NoError(t, db.DB.Create(exception).Error)
+
+	review := &AdmissionReview{
+		APIVersion: AdmissionAPIVersion,
+		Kind:       AdmissionKind,
+		Request: &AdmissionRequest{
+			UID:       "c1d2e3f4",
+			Operation: "CREATE",
+			Object: map[string]interface{}{
+				"kind":     "Pod",
+				"metadata": map[string]interface{}{"namespace": "kube-system"},
+				"spec":     map[string]interface{}{"hostNetwork": true, "securityContext": map[string]interface{}{"runAsNonRoot": true}},
+			},
+		},
+	}
+	result, err := service.ReviewAdmission("acme", review)
+	require.NoError(t, err)
+	assert.True(t, result.Response.Allowed)
+	assert.Equal(t, []string{"[Host Network] pods must not use the host network (waived by exception 1)"}, result.Response.Warnings)
+
+	// Dry runs are answered but not recorded
+	dryRun := true
+	review.Request.UID = "d1e2f3a4"
+	review.Request.DryRun = &dryRun
+	result, err = service.ReviewAdmission("acme", review)
+	require.NoError(t, err)
+	assert.True(t, result.Response.Allowed)
+	var dryRuns int64
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Where("decision_id = ?", "d1e2f3a4").Count(&dryRuns).Error)
+	assert.Zero(t, dryRuns)
+
+	_, err = service.ReviewAdmission("acme", &AdmissionReview{APIVersion: "admission.k8s.io/v1beta1", Kind: AdmissionKind, Request: review.Request})
+	assert.ErrorIs(t, err, ErrInvalidAdmissionReview)
+	_, err = service.ReviewAdmission("acme", &AdmissionReview{APIVersion: AdmissionAPIVersion, Kind: AdmissionKind})
+	assert.ErrorIs(t, err, ErrInvalidAdmissionReview)
+	_, err = service.ReviewAdmission("missing", review)
+	assert.ErrorIs(t, err, ErrOrganizationNotFound)
+}
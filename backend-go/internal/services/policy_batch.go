@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"niyama-backend/internal/models"
+)
+
+// ErrBatchTooLarge is returned when a batch exceeds OPAConfig.BatchMaxInputs
+var ErrBatchTooLarge = errors.New("batch too large")
+
+// BatchItemResult is the outcome of one input in a batch evaluation
+type BatchItemResult struct {
+	Index        int      `json:"index"`
+	Decision     string   `json:"decision,omitempty"`
+	Violations   []string `json:"violations,omitempty"`
+	Waived       []string `json:"waived,omitempty"`       // violations waived by an exception
+	ExceptionID  *uint    `json:"exception_id,omitempty"` // PolicyException that waived the deny
+	Duration     int64    `json:"duration"`               // in milliseconds
+	EvaluationID uint     `json:"evaluation_id,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// BatchSummary aggregates the decisions of a batch evaluation
+type BatchSummary struct {
+	Total   int `json:"total"`
+	Allowed int `json:"allowed"`
+	Denied  int `json:"denied"`
+	Errored int `json:"errored"`
+}
+
+// BatchEvaluation is the response of a batch evaluation
+type BatchEvaluation struct {
+	PolicyID uint              `json:"policy_id"`
+	Results  []BatchItemResult `json:"results"`
+	Summary  BatchSummary      `json:"summary"`
+}
+
+// BatchLimits returns the most inputs and request body bytes a batch evaluation
+// accepts; 0 means unlimited
+func (s *PolicyService) BatchLimits() (maxInputs int, maxBytes int64) {
+	return s.cfg.OPA.BatchMaxInputs, s.cfg.OPA.BatchMaxBytes
+}
+
+// EvaluateBatch evaluates many inputs against one policy with a bounded worker pool.
+// Successful evaluations are persisted like single evaluations; failed inputs are
+// reported per item without aborting the batch.
+func (s *PolicyService) EvaluateBatch(policyID uint, inputs []map[string]interface{}, userID, orgID uint, userRole models.Role) (*BatchEvaluation, error) {
+	if limit := s.cfg.OPA.BatchMaxInputs; limit > 0 && len(inputs) > limit {
+		return nil, fmt.Errorf("%w: %d inputs, maximum is %d", ErrBatchTooLarge, len(inputs), limit)
+	}
+
+	policy, err := s.getTestablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	workers := s.cfg.OPA.BatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+
+	shadow := s.activeShadow(policy.ID)
+	exceptions := s.activeExceptions(policy.ID)
+	results := make([]BatchItemResult, len(inputs))
+	evaluations := make([]*models.PolicyEvaluation, len(inputs))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i].Index = i
+				evaluation, result, err := s.evaluatePolicy(policy, shadow, exceptions, inputs[i], userID)
+				if err != nil {
+					results[i].Error = err.Error()
+					continue
+				}
+				results[i].Decision = result.Decision
+				results[i].Violations = result.Violations
+				results[i].Waived = result.Waived
+				results[i].ExceptionID = result.ExceptionID
+				results[i].Duration = evaluation.Duration
+				evaluations[i] = evaluation
+			}
+		}()
+	}
+
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := s.recordEvaluations(evaluations); err != nil {
+		return nil, err
+	}
+
+	batch := &BatchEvaluation{
+		PolicyID: policy.ID,
+		Results:  results,
+		Summary:  BatchSummary{Total: len(inputs)},
+	}
+	for i := range results {
+		if evaluations[i] != nil {
+			results[i].EvaluationID = evaluations[i].ID
+		}
+
+		switch {
+		case results[i].Error != "":
+			batch.Summary.Errored++
+		case results[i].Decision == models.DecisionAllow:
+			batch.Summary.Allowed++
+		default:
+			batch.Summary.Denied++
+		}
+	}
+
+	return batch, nil
+}
+
+// recordEvaluations persists the non-nil evaluations of a batch in one insert
+func (s *PolicyService) recordEvaluations(evaluations []*models.PolicyEvaluation) error {
+	if s.db == nil {
+		return nil
+	}
+
+	var records []*models.PolicyEvaluation
+	for _, evaluation := range evaluations {
+		if evaluation != nil {
+			records = append(records, evaluation)
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := s.db.DB.CreateInBatches(records, 100).Error; err != nil {
+		return fmt.Errorf("failed to record evaluations: %v", err)
+	}
+	return nil
+}
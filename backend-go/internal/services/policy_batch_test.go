@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingEvaluator fails for inputs marked with "fail" and delegates otherwise
+type failingEvaluator struct {
+	next PolicyEvaluator
+}
+
+func (e *failingEvaluator) Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error) {
+	if input["fail"] == true {
+		return nil, errors.New("evaluation exploded")
+	}
+	return e.next.Evaluate(ctx, policy, input)
+}
+
+func TestPolicyService_EvaluateBatch(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded, BatchWorkers: 3})
+	service.evaluator = &failingEvaluator{next: service.evaluator}
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	compliant := map[string]interface{}{
+		"kind": "Pod",
+		"spec": map[string]interface{}{"securityContext": map[string]interface{}{"runAsNonRoot": true}},
+	}
+	inputs := []map[string]interface{}{compliant, {"kind": "Pod"}, {"fail": true}, compliant, {"kind": "Pod"}}
+
+	batch, err := service.EvaluateBatch(policy.ID, inputs, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	assert.Equal(t, BatchSummary{Total: 5, Allowed: 2, Denied: 2, Errored: 1}, batch.Summary)
+	require.Len(t, batch.Results, 5)
+	for i, result := range batch.Results {
+		assert.Equal(t, i, result.Index)
+	}
+	assert.Equal(t, models.DecisionAllow, batch.Results[0].Decision)
+	assert.Equal(t, []string{"pods must run as non-root"}, batch.Results[1].Violations)
+	assert.Contains(t, batch.Results[2].Error, "evaluation exploded")
+	assert.Zero(t, batch.Results[2].EvaluationID)
+	assert.NotZero(t, batch.Results[4].EvaluationID)
+
+	var stored int64
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Where("policy_id = ?", policy.ID).Count(&stored).Error)
+	assert.Equal(t, int64(4), stored)
+}
+
+func TestPolicyService_EvaluateBatch_TooLarge(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded, BatchMaxInputs: 2})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	_, err := service.EvaluateBatch(policy.ID, make([]map[string]interface{}, 3), 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrBatchTooLarge)
+}
@@ -0,0 +1,593 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/rego"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDataNotFound is returned for data documents, versions or schemas the organization does not have
+	ErrDataNotFound = errors.New("data document not found")
+	// ErrInvalidDataPath is returned for paths that cannot be mounted under data
+	ErrInvalidDataPath = errors.New("invalid data path")
+	// ErrDataConflict is returned when a document would contain or be contained by another document
+	ErrDataConflict = errors.New("data path conflicts with another document")
+	// ErrDataTooLarge is returned when a document exceeds its size limit or the organization's total
+	ErrDataTooLarge = errors.New("data document too large")
+	// ErrInvalidData is returned for documents that do not match the schema of their path
+	ErrInvalidData = errors.New("data document does not match its schema")
+	// ErrInvalidDataSchema is returned for schemas that are not valid JSON Schemas or have a negative size limit
+	ErrInvalidDataSchema = errors.New("invalid data schema")
+)
+
+var dataSegmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// PolicyDataSnapshot is the data documents of an organization at a revision, the
+// ID of the latest PolicyDataVersion. Documents maps paths to values.
+type PolicyDataSnapshot struct {
+	Revision  uint
+	Documents map[string]interface{}
+}
+
+// PolicyDataLoader returns the data documents of an organization for evaluation
+type PolicyDataLoader func(orgID uint) (*PolicyDataSnapshot, error)
+
+// Tree nests the documents by path segment into the data document OPA evaluates against
+func (d *PolicyDataSnapshot) Tree() map[string]interface{} {
+	tree := map[string]interface{}{}
+	for path, value := range d.Documents {
+		segments := strings.Split(path, "/")
+		node := tree
+		for _, segment := range segments[:len(segments)-1] {
+			child, ok := node[segment].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[segment] = child
+			}
+			node = child
+		}
+		node[segments[len(segments)-1]] = copyJSON(value)
+	}
+	return tree
+}
+
+// copyJSON deep-copies a decoded JSON value
+func copyJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			copied[key] = copyJSON(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = copyJSON(element)
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// policyDataCache keeps the latest snapshot of each organization so evaluations only
+// query the revision
+type policyDataCache struct {
+	mu        sync.Mutex
+	snapshots map[uint]*PolicyDataSnapshot
+}
+
+func newPolicyDataCache() *policyDataCache {
+	return &policyDataCache{snapshots: make(map[uint]*PolicyDataSnapshot)}
+}
+
+// loadPolicyData returns the organization's data documents, reading them from the
+// database only when their revision changed
+func (s *PolicyService) loadPolicyData(orgID uint) (*PolicyDataSnapshot, error) {
+	if s.db == nil {
+		return &PolicyDataSnapshot{Documents: map[string]interface{}{}}, nil
+	}
+
+	revision, err := policyDataRevision(s.db, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.data.mu.Lock()
+	cached, ok := s.data.snapshots[orgID]
+	s.data.mu.Unlock()
+	if ok && cached.Revision == revision {
+		return cached, nil
+	}
+
+	snapshot, err := readPolicyData(s.db, orgID, revision)
+	if err != nil {
+		return nil, err
+	}
+	s.data.mu.Lock()
+	s.data.snapshots[orgID] = snapshot
+	s.data.mu.Unlock()
+	return snapshot, nil
+}
+
+// policyDataRevision returns the ID of the organization's latest data version, 0 without data
+func policyDataRevision(db *database.Database, orgID uint) (uint, error) {
+	var revision uint
+	err := db.DB.Model(&models.PolicyDataVersion{}).Where("organization_id = ?", orgID).
+		Select("COALESCE(MAX(id), 0)").Scan(&revision).Error
+	return revision, err
+}
+
+func readPolicyData(db *database.Database, orgID, revision uint) (*PolicyDataSnapshot, error) {
+	var documents []models.PolicyData
+	if err := db.DB.Where("organization_id = ?", orgID).Find(&documents).Error; err != nil {
+		return nil, err
+	}
+	snapshot := &PolicyDataSnapshot{Revision: revision, Documents: make(map[string]interface{}, len(documents))}
+	for _, document := range documents {
+		snapshot.Documents[document.Path] = document.Value
+	}
+	return snapshot, nil
+}
+
+// GetDataDocuments returns the organization's data documents under a path prefix,
+// all documents for an empty prefix
+func (s *PolicyService) GetDataDocuments(prefix string, orgID uint) ([]models.PolicyData, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if prefix != "" {
+		var err error
+		if prefix, err = normalizeDataPath(prefix); err != nil {
+			return nil, err
+		}
+	}
+
+	var documents []models.PolicyData
+	if err := s.db.DB.Where("organization_id = ?", orgID).Order("path").Find(&documents).Error; err != nil {
+		return nil, err
+	}
+	result := []models.PolicyData{}
+	for _, document := range documents {
+		if prefix == "" || dataPathWithin(document.Path, prefix) {
+			result = append(result, document)
+		}
+	}
+	return result, nil
+}
+
+// GetDataDocument returns the document at a path
+func (s *PolicyService) GetDataDocument(path string, orgID uint) (*models.PolicyData, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	path, err := normalizeDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return findDataDocument(s.db.DB, path, orgID)
+}
+
+// PutDataDocument creates or replaces the document at a path and records a new
+// version. The value is checked against the size limits and the schema of its path.
+// Writing the current value again changes nothing; created reports a new document.
+func (s *PolicyService) PutDataDocument(path string, value interface{}, message string, userID, orgID uint, userRole models.Role) (*models.PolicyData, bool, error) {
+	if s.db == nil {
+		return nil, false, fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return nil, false, fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	return s.putDataDocument(path, value, message, userID, orgID)
+}
+
+// putDataDocument writes a document on behalf of a user or, with a zero userID, of the
+// backend itself
+func (s *PolicyService) putDataDocument(path string, value interface{}, message string, userID, orgID uint) (*models.PolicyData, bool, error) {
+	path, err := normalizeDataPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+	value = normalizeJSON(value)
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode data document: %v", err)
+	}
+
+	var document *models.PolicyData
+	created := false
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		existing, err := findDataDocument(tx, path, orgID)
+		if err != nil && !errors.Is(err, ErrDataNotFound) {
+			return err
+		}
+		if existing != nil && reflect.DeepEqual(normalizeJSON(existing.Value), value) {
+			document = existing
+			return nil
+		}
+
+		if err := s.checkDataDocument(tx, path, value, len(encoded), orgID); err != nil {
+			return err
+		}
+
+		version, err := nextDataVersion(tx, path, orgID)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			existing = &models.PolicyData{OrganizationID: orgID, Path: path}
+			created = true
+		}
+		existing.Value = value
+		existing.Version = version
+		existing.Size = len(encoded)
+		existing.UpdatedByID = userID
+		if err := tx.Save(existing).Error; err != nil {
+			return err
+		}
+		document = existing
+
+		return tx.Create(&models.PolicyDataVersion{
+			OrganizationID: orgID,
+			Path:           path,
+			Version:        version,
+			Value:          value,
+			Message:        message,
+			AuthorID:       userID,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return document, created, nil
+}
+
+// DeleteDataDocument removes the document at a path, recording the deletion as a version
+func (s *PolicyService) DeleteDataDocument(path, message string, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	path, err := normalizeDataPath(path)
+	if err != nil {
+		return err
+	}
+
+	return s.db.DB.Transaction(func(tx *gorm.DB) error {
+		document, err := findDataDocument(tx, path, orgID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(document).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.PolicyDataVersion{
+			OrganizationID: orgID,
+			Path:           path,
+			Version:        document.Version + 1,
+			Deleted:        true,
+			Message:        message,
+			AuthorID:       userID,
+		}).Error
+	})
+}
+
+// GetDataVersions returns the versions of the document at a path, newest first.
+// Versions outlive the document, so the history of a deleted path remains readable.
+func (s *PolicyService) GetDataVersions(path string, orgID uint) ([]models.PolicyDataVersion, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	path, err := normalizeDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []models.PolicyDataVersion
+	err = s.db.DB.Where("organization_id = ? AND path = ?", orgID, path).
+		Order("version DESC").Find(&versions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrDataNotFound
+	}
+	return versions, nil
+}
+
+// GetDataVersion returns one version of the document at a path
+func (s *PolicyService) GetDataVersion(path string, version int, orgID uint) (*models.PolicyDataVersion, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	path, err := normalizeDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result models.PolicyDataVersion
+	err = s.db.DB.Where("organization_id = ? AND path = ? AND version = ?", orgID, path, version).First(&result).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDataNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDataSchemas returns the organization's data schemas
+func (s *PolicyService) GetDataSchemas(orgID uint) ([]models.PolicyDataSchema, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var schemas []models.PolicyDataSchema
+	err := s.db.DB.Where("organization_id = ?", orgID).Order("path").Find(&schemas).Error
+	return schemas, err
+}
+
+// PutDataSchema sets the JSON Schema and size limit of the documents at or below a
+// path. It is rejected when an existing document does not satisfy it.
+func (s *PolicyService) PutDataSchema(path string, schema map[string]interface{}, maxBytes int, userID, orgID uint, userRole models.Role) (*models.PolicyDataSchema, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return nil, fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	path, err := normalizeDataPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("%w: max_bytes must not be negative", ErrInvalidDataSchema)
+	}
+	if len(schema) == 0 {
+		schema = nil
+	}
+	if schema != nil {
+		if err := verifyDataSchema(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	var result *models.PolicyDataSchema
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		var existing models.PolicyDataSchema
+		err := tx.Where("organization_id = ? AND path = ?", orgID, path).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		existing.OrganizationID = orgID
+		existing.Path = path
+		existing.Schema = schema
+		existing.MaxBytes = maxBytes
+		if err := tx.Save(&existing).Error; err != nil {
+			return err
+		}
+		result = &existing
+
+		// Documents must still be accepted under the schemas that now apply to them
+		var documents []models.PolicyData
+		if err := tx.Where("organization_id = ?", orgID).Order("path").Find(&documents).Error; err != nil {
+			return err
+		}
+		for _, document := range documents {
+			if !dataPathWithin(document.Path, path) {
+				continue
+			}
+			if err := s.checkDataSchema(tx, document.Path, document.Value, document.Size, orgID); err != nil {
+				return fmt.Errorf("%s: %w", document.Path, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteDataSchema removes the schema of a path
+func (s *PolicyService) DeleteDataSchema(path string, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	path, err := normalizeDataPath(path)
+	if err != nil {
+		return err
+	}
+
+	result := s.db.DB.Where("organization_id = ? AND path = ?", orgID, path).Delete(&models.PolicyDataSchema{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDataNotFound
+	}
+	return nil
+}
+
+// checkDataDocument validates a document before it is written to a path: it may not
+// overlap another document, and it must satisfy the size limits and the schema
+func (s *PolicyService) checkDataDocument(tx *gorm.DB, path string, value interface{}, size int, orgID uint) error {
+	var paths []string
+	if err := tx.Model(&models.PolicyData{}).Where("organization_id = ?", orgID).Order("path").Pluck("path", &paths).Error; err != nil {
+		return err
+	}
+	for _, other := range paths {
+		if other != path && (dataPathWithin(other, path) || dataPathWithin(path, other)) {
+			return fmt.Errorf("%w: %s", ErrDataConflict, other)
+		}
+	}
+
+	if limit := s.cfg.Data.MaxOrganizationBytes; limit > 0 {
+		var total int64
+		err := tx.Model(&models.PolicyData{}).Where("organization_id = ? AND path <> ?", orgID, path).
+			Select("COALESCE(SUM(size), 0)").Scan(&total).Error
+		if err != nil {
+			return err
+		}
+		if total+int64(size) > int64(limit) {
+			return fmt.Errorf("%w: the organization's documents would use %d bytes, maximum is %d", ErrDataTooLarge, total+int64(size), limit)
+		}
+	}
+
+	return s.checkDataSchema(tx, path, value, size, orgID)
+}
+
+// checkDataSchema checks a document against the size limit and JSON Schema of the
+// closest schema at or above its path
+func (s *PolicyService) checkDataSchema(tx *gorm.DB, path string, value interface{}, size int, orgID uint) error {
+	var schemas []models.PolicyDataSchema
+	if err := tx.Where("organization_id = ?", orgID).Find(&schemas).Error; err != nil {
+		return err
+	}
+	var schema *models.PolicyDataSchema
+	for i := range schemas {
+		if dataPathWithin(path, schemas[i].Path) {
+			if schema == nil || len(schemas[i].Path) > len(schema.Path) {
+				schema = &schemas[i]
+			}
+		}
+	}
+
+	limit := s.cfg.Data.MaxDocumentBytes
+	if schema != nil && schema.MaxBytes > 0 {
+		limit = schema.MaxBytes
+	}
+	if limit > 0 && size > limit {
+		return fmt.Errorf("%w: %d bytes, maximum is %d", ErrDataTooLarge, size, limit)
+	}
+
+	if schema != nil && schema.Schema != nil {
+		return matchDataSchema(schema.Schema, value)
+	}
+	return nil
+}
+
+// verifyDataSchema checks that a schema is a valid JSON Schema
+func verifyDataSchema(schema map[string]interface{}) error {
+	result, err := evalSchemaBuiltin("json.verify_schema(input.schema)", map[string]interface{}{"schema": schema})
+	if err != nil {
+		return err
+	}
+	if result[0] != true {
+		return fmt.Errorf("%w: %v", ErrInvalidDataSchema, result[1])
+	}
+	return nil
+}
+
+// matchDataSchema validates a value against a JSON Schema with OPA's json.match_schema
+func matchDataSchema(schema map[string]interface{}, value interface{}) error {
+	result, err := evalSchemaBuiltin("json.match_schema(input.document, input.schema)", map[string]interface{}{"document": value, "schema": schema})
+	if err != nil {
+		return err
+	}
+	if result[0] == true {
+		return nil
+	}
+
+	var messages []string
+	errs, _ := result[1].([]interface{})
+	for _, e := range errs {
+		if detail, ok := e.(map[string]interface{}); ok {
+			messages = append(messages, fmt.Sprintf("%v: %v", detail["field"], detail["desc"]))
+		}
+	}
+	sort.Strings(messages)
+	return fmt.Errorf("%w: %s", ErrInvalidData, strings.Join(messages, "; "))
+}
+
+// evalSchemaBuiltin evaluates a schema builtin call, which returns [ok, errors]
+func evalSchemaBuiltin(query string, input map[string]interface{}) ([]interface{}, error) {
+	rs, err := rego.New(rego.Query(query), rego.Input(input)).Eval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to check schema: %v", err)
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, fmt.Errorf("failed to check schema: no result")
+	}
+	result, ok := rs[0].Expressions[0].Value.([]interface{})
+	if !ok || len(result) != 2 {
+		return nil, fmt.Errorf("failed to check schema: unexpected result %v", rs[0].Expressions[0].Value)
+	}
+	return result, nil
+}
+
+// normalizeDataPath turns "kubernetes/networkpolicies", "/kubernetes/networkpolicies/"
+// or "kubernetes.networkpolicies" into the stored slash separated form
+func normalizeDataPath(path string) (string, error) {
+	path = strings.Trim(strings.ReplaceAll(path, ".", "/"), "/")
+	if path == "" {
+		return "", fmt.Errorf("%w: path is required", ErrInvalidDataPath)
+	}
+	segments := strings.Split(path, "/")
+	for _, segment := range segments {
+		if !dataSegmentPattern.MatchString(segment) {
+			return "", fmt.Errorf("%w: %q is not a valid path segment", ErrInvalidDataPath, segment)
+		}
+	}
+	if segments[0] == "system" {
+		return "", fmt.Errorf("%w: data.system is reserved by OPA", ErrInvalidDataPath)
+	}
+	return path, nil
+}
+
+// dataPathWithin reports whether path is parent or one of its descendants
+func dataPathWithin(path, parent string) bool {
+	return path == parent || strings.HasPrefix(path, parent+"/")
+}
+
+func findDataDocument(tx *gorm.DB, path string, orgID uint) (*models.PolicyData, error) {
+	var document models.PolicyData
+	err := tx.Where("organization_id = ? AND path = ?", orgID, path).First(&document).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDataNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+// nextDataVersion numbers versions per path, continuing after a deletion
+func nextDataVersion(tx *gorm.DB, path string, orgID uint) (int, error) {
+	var latest int
+	err := tx.Model(&models.PolicyDataVersion{}).Where("organization_id = ? AND path = ?", orgID, path).
+		Select("COALESCE(MAX(version), 0)").Scan(&latest).Error
+	return latest + 1, err
+}
+
+func canManagePolicyData(userRole models.Role) bool {
+	// Viewers and members may read data but not change it
+	return userRole == models.RoleOwner || userRole == models.RoleAdmin || userRole == models.RoleEditor
+}
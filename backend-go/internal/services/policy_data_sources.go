@@ -0,0 +1,491 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDataSourceNotFound is returned for data sources outside the user's organization
+	ErrDataSourceNotFound = errors.New("data source not found")
+	// ErrInvalidDataSource is returned when a source is missing its URL or path or polls too often
+	ErrInvalidDataSource = errors.New("invalid data source")
+)
+
+// defaultDataSourceInterval is used for sources created without an interval
+const defaultDataSourceInterval = 5 * time.Minute
+
+// maxDataSourceRedirects is the number of redirects a fetch follows
+const maxDataSourceRedirects = 5
+
+// newDataSourceClient returns the client that fetches data sources. Redirects are
+// limited in number, may not leave http(s) or downgrade https to http, and unless
+// cfg.SourceAllowPrivate is set no connection, including one made for a redirect,
+// may reach a loopback, private or link-local address. Addresses are checked when
+// connecting, after DNS resolution, so requests are not sent through a proxy.
+func newDataSourceClient(cfg *config.DataConfig) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if cfg.SourceAllowPrivate {
+				return nil
+			}
+			return checkDataSourceAddress(address)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxDataSourceRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxDataSourceRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			if via[0].URL.Scheme == "https" && req.URL.Scheme == "http" {
+				return fmt.Errorf("redirect from https to http")
+			}
+			return nil
+		},
+	}
+}
+
+// checkDataSourceAddress rejects connections to addresses inside the server's network
+func checkDataSourceAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	return checkPublicIP(ip)
+}
+
+// checkPublicIP rejects loopback, private, link-local and other non-public addresses
+func checkPublicIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("address %s is in a private network", ip)
+	}
+	return nil
+}
+
+// GetDataSources returns the organization's data sources
+func (s *PolicyService) GetDataSources(orgID uint) ([]models.DataSource, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var sources []models.DataSource
+	err := s.db.DB.Where("organization_id = ?", orgID).Order("id").Find(&sources).Error
+	return sources, err
+}
+
+// GetDataSource returns a data source of the organization
+func (s *PolicyService) GetDataSource(sourceID, orgID uint) (*models.DataSource, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var source models.DataSource
+	err := s.db.DB.Where("organization_id = ?", orgID).First(&source, sourceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDataSourceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &source, nil
+}
+
+// CreateDataSource registers an HTTP JSON endpoint to poll into a data document.
+// The first fetch happens on the next poll.
+func (s *PolicyService) CreateDataSource(source *models.DataSource, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	if err := s.normalizeDataSource(source, orgID); err != nil {
+		return err
+	}
+
+	source.ID = 0
+	source.OrganizationID = orgID
+	source.ETag = ""
+	source.LastFetchedAt = nil
+	source.LastSuccessAt = nil
+	source.LastError = ""
+	source.ConsecutiveFailures = 0
+	source.NextFetchAt = nil
+	return s.db.DB.Create(source).Error
+}
+
+// UpdateDataSource changes the name, URL, path or interval of a source. A new URL or
+// path is fetched in full on the next poll.
+func (s *PolicyService) UpdateDataSource(sourceID uint, updates *models.DataSource, userID, orgID uint, userRole models.Role) (*models.DataSource, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return nil, fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	source, err := s.GetDataSource(sourceID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	previousURL, previousPath := source.URL, source.Path
+
+	if updates.Name != "" {
+		source.Name = updates.Name
+	}
+	if updates.URL != "" {
+		source.URL = updates.URL
+	}
+	if updates.Path != "" {
+		source.Path = updates.Path
+	}
+	if updates.IntervalSeconds != 0 {
+		source.IntervalSeconds = updates.IntervalSeconds
+	}
+
+	if err := s.normalizeDataSource(source, orgID); err != nil {
+		return nil, err
+	}
+	if source.URL != previousURL || source.Path != previousPath {
+		source.ETag = ""
+		source.NextFetchAt = nil
+	}
+
+	if err := s.db.DB.Save(source).Error; err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// DeleteDataSource stops polling a source. The document it wrote is kept.
+func (s *PolicyService) DeleteDataSource(sourceID, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	source, err := s.GetDataSource(sourceID, orgID)
+	if err != nil {
+		return err
+	}
+	return s.db.DB.Delete(source).Error
+}
+
+// GetDataSourceFetches returns the fetches of a source, newest first
+func (s *PolicyService) GetDataSourceFetches(sourceID, orgID uint) ([]models.DataSourceFetch, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetDataSource(sourceID, orgID); err != nil {
+		return nil, err
+	}
+
+	var fetches []models.DataSourceFetch
+	err := s.db.DB.Where("source_id = ?", sourceID).Order("id DESC").Limit(100).Find(&fetches).Error
+	return fetches, err
+}
+
+// FetchDataSource polls a source now instead of waiting for its next fetch
+func (s *PolicyService) FetchDataSource(sourceID, userID, orgID uint, userRole models.Role) (*models.DataSourceFetch, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicyData(userRole) {
+		return nil, fmt.Errorf("insufficient permissions to manage policy data")
+	}
+	source, err := s.GetDataSource(sourceID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return s.fetchDataSource(context.Background(), source)
+}
+
+// PollDataSources fetches every source that is due, checking at the configured poll
+// interval until ctx is cancelled. Fetches in flight are cancelled with ctx and
+// PollDataSources returns once they have stopped.
+func (s *PolicyService) PollDataSources(ctx context.Context) {
+	if s.db == nil {
+		return
+	}
+
+	interval := s.cfg.Data.SourcePollInterval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.pollDataSources(ctx, time.Now()); err != nil {
+			slog.Error("Failed to poll data sources", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollDataSources fetches the sources whose next fetch is due at now, up to the
+// configured number of workers at a time. Each source is claimed first so that
+// when several servers poll, only one of them fetches it.
+func (s *PolicyService) pollDataSources(ctx context.Context, now time.Time) error {
+	var due []models.DataSource
+	err := s.db.DB.Where("next_fetch_at IS NULL OR next_fetch_at <= ?", now).Order("id").Find(&due).Error
+	if err != nil {
+		return err
+	}
+
+	workers := s.cfg.Data.SourceWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	jobs := make(chan int)
+	errs := make([]error, len(due))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				errs[i] = s.pollDataSource(ctx, &due[i], now)
+			}
+		}()
+	}
+	for i := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// pollDataSource claims and fetches one due source
+func (s *PolicyService) pollDataSource(ctx context.Context, source *models.DataSource, now time.Time) error {
+	claimed, err := s.claimDataSource(source, now)
+	if err != nil || !claimed {
+		return err
+	}
+	fetch, err := s.fetchDataSource(ctx, source)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	if fetch.Status == models.DataFetchFailed {
+		slog.Warn("Data source fetch failed", "source", source.ID, "name", source.Name, "error", fetch.Error)
+	}
+	return nil
+}
+
+// claimDataSource moves the next fetch of a due source past the fetch timeout,
+// guarded by the value it was read with. It reports false when another server
+// claimed or fetched the source in the meantime. A claim whose fetch never
+// finishes expires, and the source is fetched again.
+func (s *PolicyService) claimDataSource(source *models.DataSource, now time.Time) (bool, error) {
+	query := s.db.DB.Model(&models.DataSource{}).Where("id = ?", source.ID)
+	if source.NextFetchAt == nil {
+		query = query.Where("next_fetch_at IS NULL")
+	} else {
+		query = query.Where("next_fetch_at = ?", *source.NextFetchAt)
+	}
+	result := query.Update("next_fetch_at", now.Add(2*s.dataSourceTimeout()))
+	return result.RowsAffected == 1, result.Error
+}
+
+// dataSourceTimeout bounds one fetch of a source
+func (s *PolicyService) dataSourceTimeout() time.Duration {
+	if s.cfg.Data.SourceTimeout > 0 {
+		return s.cfg.Data.SourceTimeout
+	}
+	return 30 * time.Second
+}
+
+// fetchDataSource requests the source's URL, conditionally on its last ETag, and
+// writes a changed response to its data document. The outcome is recorded as a
+// fetch and on the source; errors are only returned when recording fails or ctx
+// is cancelled, in which case nothing is recorded.
+func (s *PolicyService) fetchDataSource(ctx context.Context, source *models.DataSource) (*models.DataSourceFetch, error) {
+	start := time.Now()
+	fetch := &models.DataSourceFetch{SourceID: source.ID}
+	etag, err := s.requestDataSource(ctx, source, fetch)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		fetch.Status = models.DataFetchFailed
+		fetch.Error = err.Error()
+	}
+	fetch.Duration = time.Since(start).Milliseconds()
+
+	now := time.Now()
+	next := now.Add(time.Duration(source.IntervalSeconds) * time.Second)
+	source.LastFetchedAt = &now
+	source.NextFetchAt = &next
+	if fetch.Status == models.DataFetchFailed {
+		source.LastError = fetch.Error
+		source.ConsecutiveFailures++
+	} else {
+		source.LastError = ""
+		source.ConsecutiveFailures = 0
+		source.LastSuccessAt = &now
+		source.ETag = etag
+	}
+
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(fetch).Error; err != nil {
+			return err
+		}
+		return tx.Model(source).Select("etag", "last_fetched_at", "last_success_at", "last_error", "consecutive_failures", "next_fetch_at").
+			Updates(source).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fetch, nil
+}
+
+// requestDataSource performs the request of a fetch and stores the response, setting
+// the fetch's status. It returns the ETag to send next time.
+func (s *PolicyService) requestDataSource(ctx context.Context, source *models.DataSource, fetch *models.DataSourceFetch) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.dataSourceTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	// Without the document, e.g. after it was deleted by hand, the full response is needed
+	if source.ETag != "" {
+		if _, err := findDataDocument(s.db.DB, source.Path, source.OrganizationID); err == nil {
+			req.Header.Set("If-None-Match", source.ETag)
+		}
+	}
+
+	resp, err := s.dataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	fetch.StatusCode = resp.StatusCode
+
+	if resp.StatusCode == http.StatusNotModified {
+		fetch.Status = models.DataFetchNotModified
+		return source.ETag, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+	}
+
+	// A document may not exceed the organization's total, so neither may the response
+	body := io.Reader(resp.Body)
+	if limit := s.cfg.Data.MaxOrganizationBytes; limit > 0 {
+		body = io.LimitReader(resp.Body, int64(limit)+1)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+	if limit := s.cfg.Data.MaxOrganizationBytes; limit > 0 && len(content) > limit {
+		return "", fmt.Errorf("%w: the response exceeds %d bytes", ErrDataTooLarge, limit)
+	}
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return "", fmt.Errorf("response is not JSON: %v", err)
+	}
+
+	previous := 0
+	if existing, err := findDataDocument(s.db.DB, source.Path, source.OrganizationID); err == nil {
+		previous = existing.Version
+	}
+	document, _, err := s.putDataDocument(source.Path, value, fmt.Sprintf("Fetched from data source %s", source.Name), 0, source.OrganizationID)
+	if err != nil {
+		return "", err
+	}
+	fetch.Version = document.Version
+	fetch.Status = models.DataFetchUpdated
+	if document.Version == previous {
+		fetch.Status = models.DataFetchUnchanged
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// normalizeDataSource validates a source, defaulting its interval
+func (s *PolicyService) normalizeDataSource(source *models.DataSource, orgID uint) error {
+	if source.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidDataSource)
+	}
+	parsed, err := url.Parse(source.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%w: url must be an http or https URL", ErrInvalidDataSource)
+	}
+
+	path, err := normalizeDataPath(source.Path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDataSource, err)
+	}
+	source.Path = path
+
+	if source.IntervalSeconds == 0 {
+		source.IntervalSeconds = int(defaultDataSourceInterval / time.Second)
+	}
+	minimum := s.cfg.Data.SourceMinInterval
+	if source.IntervalSeconds < 0 || time.Duration(source.IntervalSeconds)*time.Second < minimum {
+		return fmt.Errorf("%w: interval_seconds must be at least %d", ErrInvalidDataSource, int(minimum/time.Second))
+	}
+
+	// Two sources writing one document would overwrite each other on every poll
+	var count int64
+	err = s.db.DB.Model(&models.DataSource{}).
+		Where("organization_id = ? AND path = ? AND id <> ?", orgID, path, source.ID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: another source writes %s", ErrInvalidDataSource, path)
+	}
+	return nil
+}
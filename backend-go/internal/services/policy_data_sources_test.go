@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDataSource serves a JSON document with an ETag derived from its revision
+type fakeDataSource struct {
+	mu       sync.Mutex
+	value    interface{}
+	revision int
+	status   int
+	body     string
+	requests []string // If-None-Match of each request
+}
+
+func (f *fakeDataSource) set(value interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.value = value
+	f.revision++
+}
+
+func (f *fakeDataSource) fail(status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.body = status, body
+}
+
+func (f *fakeDataSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Header.Get("If-None-Match"))
+
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.body))
+		return
+	}
+	etag := `"rev-` + string(rune('0'+f.revision)) + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	json.NewEncoder(w).Encode(f.value)
+}
+
+func TestPolicyService_DataSourceFetch(t *testing.T) {
+	fake := &fakeDataSource{}
+	fake.set(networkPolicies("payments"))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	source := &models.DataSource{Name: "cluster", URL: server.URL, Path: "/kubernetes/networkpolicies"}
+	require.NoError(t, service.CreateDataSource(source, 1, 1, models.RoleAdmin))
+	assert.Equal(t, "kubernetes/networkpolicies", source.Path)
+	assert.Equal(t, 300, source.IntervalSeconds)
+
+	fetch, err := service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchUpdated, fetch.Status)
+	assert.Equal(t, http.StatusOK, fetch.StatusCode)
+	assert.Equal(t, 1, fetch.Version)
+
+	document, err := service.GetDataDocument("kubernetes/networkpolicies", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, document.Version)
+
+	// The stored ETag makes the next request conditional
+	fetch, err = service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchNotModified, fetch.Status)
+	assert.Equal(t, http.StatusNotModified, fetch.StatusCode)
+	assert.Equal(t, []string{"", `"rev-1"`}, fake.requests)
+
+	fake.set(networkPolicies("payments", "billing"))
+	fetch, err = service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchUpdated, fetch.Status)
+	assert.Equal(t, 2, fetch.Version)
+
+	versions, err := service.GetDataVersions("kubernetes/networkpolicies", 1)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "Fetched from data source cluster", versions[0].Message)
+
+	// A new ETag for the same value does not create a version
+	fake.set(networkPolicies("payments", "billing"))
+	fetch, err = service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchUnchanged, fetch.Status)
+	assert.Equal(t, 2, fetch.Version)
+
+	// Without its document, the source is fetched in full
+	require.NoError(t, service.DeleteDataDocument("kubernetes/networkpolicies", "", 1, 1, models.RoleAdmin))
+	fetch, err = service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchUpdated, fetch.Status)
+	assert.Equal(t, "", fake.requests[len(fake.requests)-1])
+
+	fetches, err := service.GetDataSourceFetches(source.ID, 1)
+	require.NoError(t, err)
+	require.Len(t, fetches, 5)
+	assert.Equal(t, models.DataFetchUpdated, fetches[0].Status)
+
+	saved, err := service.GetDataSource(source.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, `"rev-3"`, saved.ETag)
+	assert.NotNil(t, saved.LastSuccessAt)
+	require.NotNil(t, saved.NextFetchAt)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), *saved.NextFetchAt, time.Minute)
+
+	_, err = service.GetDataSource(source.ID, 2)
+	assert.ErrorIs(t, err, ErrDataSourceNotFound)
+}
+
+func TestPolicyService_DataSourceFailures(t *testing.T) {
+	fake := &fakeDataSource{}
+	fake.set(networkPolicies("payments"))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	monitoring := NewMonitoringService(db, &config.Config{})
+
+	source := &models.DataSource{Name: "cluster", URL: server.URL, Path: "kubernetes/networkpolicies"}
+	require.NoError(t, service.CreateDataSource(source, 1, 1, models.RoleAdmin))
+	_, err := service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	fake.fail(http.StatusInternalServerError, "upstream unavailable")
+	fetch, err := service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchFailed, fetch.Status)
+	assert.Equal(t, http.StatusInternalServerError, fetch.StatusCode)
+	assert.Contains(t, fetch.Error, "upstream unavailable")
+
+	fake.fail(http.StatusOK, "not json")
+	fetch, err = service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchFailed, fetch.Status)
+	assert.Contains(t, fetch.Error, "response is not JSON")
+
+	saved, err := service.GetDataSource(source.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, saved.ConsecutiveFailures)
+	assert.Equal(t, `"rev-1"`, saved.ETag)
+
+	// The last good document stays in place
+	document, err := service.GetDataDocument("kubernetes/networkpolicies", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, document.Version)
+
+	alerts, err := monitoring.DataSourceAlerts(1)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "medium", alerts[0].Severity)
+	assert.Contains(t, alerts[0].Message, "response is not JSON")
+
+	_, err = service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	alerts, err = monitoring.DataSourceAlerts(1)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "high", alerts[0].Severity)
+
+	// A success clears the failures and the alert
+	fake.fail(0, "")
+	fetch, err = service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataFetchNotModified, fetch.Status)
+	alerts, err = monitoring.DataSourceAlerts(1)
+	require.NoError(t, err)
+	assert.Empty(t, alerts)
+}
+
+func TestPolicyService_PollDataSources(t *testing.T) {
+	fake := &fakeDataSource{}
+	fake.set(networkPolicies("payments"))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	first := &models.DataSource{Name: "first", URL: server.URL, Path: "first"}
+	second := &models.DataSource{Name: "second", URL: server.URL, Path: "second", IntervalSeconds: 60}
+	require.NoError(t, service.CreateDataSource(first, 1, 1, models.RoleAdmin))
+	require.NoError(t, service.CreateDataSource(second, 1, 1, models.RoleAdmin))
+
+	// New sources are due right away
+	require.NoError(t, service.pollDataSources(context.Background(), time.Now()))
+	assert.Len(t, fake.requests, 2)
+
+	// Nothing is due until the shorter interval has passed
+	require.NoError(t, service.pollDataSources(context.Background(), time.Now()))
+	assert.Len(t, fake.requests, 2)
+
+	require.NoError(t, service.pollDataSources(context.Background(), time.Now().Add(2*time.Minute)))
+	assert.Len(t, fake.requests, 3)
+
+	var fetches int64
+	require.NoError(t, db.DB.Model(&models.DataSourceFetch{}).Where("source_id = ?", second.ID).Count(&fetches).Error)
+	assert.Equal(t, int64(2), fetches)
+
+	// A deleted source is no longer polled and keeps its document
+	require.NoError(t, service.DeleteDataSource(second.ID, 1, 1, models.RoleAdmin))
+	require.NoError(t, service.pollDataSources(context.Background(), time.Now().Add(time.Hour)))
+	assert.Len(t, fake.requests, 4)
+	_, err := service.GetDataDocument("second", 1)
+	assert.NoError(t, err)
+}
+
+func TestPolicyService_DataSourceClaim(t *testing.T) {
+	fake := &fakeDataSource{}
+	fake.set(networkPolicies("payments"))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	source := &models.DataSource{Name: "cluster", URL: server.URL, Path: "cluster"}
+	require.NoError(t, service.CreateDataSource(source, 1, 1, models.RoleAdmin))
+
+	// Another server read the same due source; only the first claim wins
+	stale := *source
+	claimed, err := service.claimDataSource(source, time.Now())
+	require.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = service.claimDataSource(&stale, time.Now())
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	// A claimed source is not due until the claim expires
+	require.NoError(t, service.pollDataSources(context.Background(), time.Now()))
+	assert.Empty(t, fake.requests)
+	require.NoError(t, service.pollDataSources(context.Background(), time.Now().Add(2*time.Minute)))
+	assert.Len(t, fake.requests, 1)
+
+	// Polls cancelled by shutdown fetch and record nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, service.pollDataSources(ctx, time.Now().Add(time.Hour)))
+	assert.Len(t, fake.requests, 1)
+	var fetches int64
+	require.NoError(t, db.DB.Model(&models.DataSourceFetch{}).Count(&fetches).Error)
+	assert.Equal(t, int64(1), fetches)
+}
+
+func TestPolicyService_DataSourceClient(t *testing.T) {
+	var target string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/ftp":
+			http.Redirect(w, r, "ftp://example.com/data.json", http.StatusFound)
+		default:
+			target = r.URL.Path
+			w.Write([]byte(`{"ok": true}`))
+		}
+	}))
+	defer server.Close()
+
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	fetchURL := func(path string) *models.DataSourceFetch {
+		source := &models.DataSource{Name: path, URL: server.URL + path, Path: "sources" + path}
+		require.NoError(t, service.CreateDataSource(source, 1, 1, models.RoleAdmin))
+		fetch, err := service.FetchDataSource(source.ID, 1, 1, models.RoleAdmin)
+		require.NoError(t, err)
+		return fetch
+	}
+
+	fetch := fetchURL("/loop")
+	assert.Equal(t, models.DataFetchFailed, fetch.Status)
+	assert.Contains(t, fetch.Error, "stopped after 5 redirects")
+
+	fetch = fetchURL("/ftp")
+	assert.Equal(t, models.DataFetchFailed, fetch.Status)
+	assert.Contains(t, fetch.Error, `redirect to unsupported scheme "ftp"`)
+
+	// The test server listens on loopback, which sources may not reach by default
+	service.cfg.Data.SourceAllowPrivate = false
+	service.dataClient.CloseIdleConnections()
+	fetch = fetchURL("/private")
+	assert.Equal(t, models.DataFetchFailed, fetch.Status)
+	assert.Contains(t, fetch.Error, "is in a private network")
+	assert.Empty(t, target)
+
+	service.cfg.Data.SourceAllowPrivate = true
+	fetch = fetchURL("/public")
+	assert.Equal(t, models.DataFetchUpdated, fetch.Status)
+	assert.Equal(t, "/public", target)
+}
+
+func TestPolicyService_DataSourceValidation(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	service.cfg.Data.SourceMinInterval = 30 * time.Second
+
+	invalid := []models.DataSource{
+		{URL: "https://example.com/data.json", Path: "a"},
+		{Name: "ftp", URL: "ftp://example.com/data.json", Path: "a"},
+		{Name: "no path", URL: "https://example.com/data.json"},
+		{Name: "fast", URL: "https://example.com/data.json", Path: "a", IntervalSeconds: 10},
+		{Name: "negative", URL: "https://example.com/data.json", Path: "a", IntervalSeconds: -1},
+	}
+	for _, source := range invalid {
+		source := source
+		assert.ErrorIs(t, service.CreateDataSource(&source, 1, 1, models.RoleAdmin), ErrInvalidDataSource, source.Name)
+	}
+
+	source := &models.DataSource{Name: "users", URL: "https://example.com/users.json", Path: "users"}
+	require.NoError(t, service.CreateDataSource(source, 1, 1, models.RoleAdmin))
+
+	// Two sources cannot write the same document
+	duplicate := &models.DataSource{Name: "other", URL: "https://example.com/other.json", Path: "/users/"}
+	assert.ErrorIs(t, service.CreateDataSource(duplicate, 1, 1, models.RoleAdmin), ErrInvalidDataSource)
+
+	assert.Error(t, service.CreateDataSource(&models.DataSource{Name: "viewer", URL: "https://example.com", Path: "b"}, 1, 1, models.RoleViewer))
+
+	updated, err := service.UpdateDataSource(source.ID, &models.DataSource{IntervalSeconds: 120}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 120, updated.IntervalSeconds)
+	assert.Equal(t, "users", updated.Path)
+}
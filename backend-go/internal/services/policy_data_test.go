@@ -0,0 +1,286 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/bundle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const namespaceNetworkPolicy = `package policy.network_policy
+
+import rego.v1
+
+deny contains msg if {
+    input.kind == "Namespace"
+    not has_network_policy(input.metadata.name)
+    msg := sprintf("namespace %s must have a NetworkPolicy", [input.metadata.name])
+}
+
+has_network_policy(namespace) if {
+    some policy in data.kubernetes.networkpolicies
+    policy.metadata.namespace == namespace
+}`
+
+func networkPolicies(namespaces ...string) []interface{} {
+	policies := []interface{}{}
+	for _, namespace := range namespaces {
+		policies = append(policies, map[string]interface{}{
+			"kind":     "NetworkPolicy",
+			"metadata": map[string]interface{}{"name": "default-deny", "namespace": namespace},
+		})
+	}
+	return policies
+}
+
+func TestPolicyService_DataDocuments(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	document, created, err := service.PutDataDocument("/kubernetes/networkpolicies/", networkPolicies("payments"), "initial", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "kubernetes/networkpolicies", document.Path)
+	assert.Equal(t, 1, document.Version)
+	assert.Positive(t, document.Size)
+
+	// Writing the same value again records nothing
+	_, created, err = service.PutDataDocument("kubernetes.networkpolicies", networkPolicies("payments"), "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.False(t, created)
+
+	document, created, err = service.PutDataDocument("kubernetes/networkpolicies", networkPolicies("payments", "search"), "add search", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, 2, document.Version)
+
+	fetched, err := service.GetDataDocument("kubernetes/networkpolicies", 1)
+	require.NoError(t, err)
+	assert.Equal(t, networkPolicies("payments", "search"), fetched.Value)
+
+	_, _, err = service.PutDataDocument("teams", map[string]interface{}{"payments": "team-a"}, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	documents, err := service.GetDataDocuments("", 1)
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	assert.Equal(t, "kubernetes/networkpolicies", documents[0].Path)
+	documents, err = service.GetDataDocuments("kubernetes", 1)
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	documents, err = service.GetDataDocuments("", 2)
+	require.NoError(t, err)
+	assert.Empty(t, documents)
+
+	// Documents may not contain one another
+	_, _, err = service.PutDataDocument("kubernetes", map[string]interface{}{}, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrDataConflict)
+	_, _, err = service.PutDataDocument("teams/payments", "team-b", "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrDataConflict)
+
+	for _, path := range []string{"", "system/config", "kubernetes/network policies", "1st"} {
+		_, _, err = service.PutDataDocument(path, true, "", 1, 1, models.RoleAdmin)
+		assert.ErrorIs(t, err, ErrInvalidDataPath, path)
+	}
+	_, _, err = service.PutDataDocument("teams", map[string]interface{}{}, "", 1, 1, models.RoleViewer)
+	assert.Error(t, err)
+
+	// Deleting records a version and keeps the history
+	require.NoError(t, service.DeleteDataDocument("kubernetes/networkpolicies", "retired", 1, 1, models.RoleAdmin))
+	_, err = service.GetDataDocument("kubernetes/networkpolicies", 1)
+	assert.ErrorIs(t, err, ErrDataNotFound)
+	assert.ErrorIs(t, service.DeleteDataDocument("kubernetes/networkpolicies", "", 1, 1, models.RoleAdmin), ErrDataNotFound)
+
+	document, created, err = service.PutDataDocument("kubernetes/networkpolicies", networkPolicies(), "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, 4, document.Version)
+
+	versions, err := service.GetDataVersions("kubernetes/networkpolicies", 1)
+	require.NoError(t, err)
+	require.Len(t, versions, 4)
+	assert.Equal(t, 4, versions[0].Version)
+	assert.True(t, versions[1].Deleted)
+	assert.Equal(t, "retired", versions[1].Message)
+	assert.Nil(t, versions[1].Value)
+
+	version, err := service.GetDataVersion("kubernetes/networkpolicies", 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, networkPolicies("payments"), version.Value)
+	assert.Equal(t, "initial", version.Message)
+	_, err = service.GetDataVersion("kubernetes/networkpolicies", 9, 1)
+	assert.ErrorIs(t, err, ErrDataNotFound)
+	_, err = service.GetDataVersions("missing", 1)
+	assert.ErrorIs(t, err, ErrDataNotFound)
+}
+
+func TestPolicyService_DataLimitsAndSchemas(t *testing.T) {
+	db := &database.Database{DB: setupTestDB(t)}
+	service := NewPolicyService(db, &config.Config{
+		OPA:  config.OPAConfig{Mode: OPAModeEmbedded},
+		Data: config.DataConfig{MaxDocumentBytes: 64, MaxOrganizationBytes: 100},
+	})
+
+	_, _, err := service.PutDataDocument("large", map[string]interface{}{"text": string(bytes.Repeat([]byte("x"), 64))}, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrDataTooLarge)
+
+	_, _, err = service.PutDataDocument("first", map[string]interface{}{"text": string(bytes.Repeat([]byte("x"), 40))}, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	_, _, err = service.PutDataDocument("second", map[string]interface{}{"text": string(bytes.Repeat([]byte("x"), 40))}, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrDataTooLarge, "the organization total is exceeded")
+
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"owner"},
+		"properties": map[string]interface{}{
+			"owner": map[string]interface{}{"type": "string"},
+		},
+	}
+	_, err = service.PutDataSchema("first", schema, 0, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidData, "the existing document does not match")
+
+	saved, err := service.PutDataSchema("teams", schema, 16, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, "teams", saved.Path)
+
+	_, _, err = service.PutDataDocument("teams/payments", map[string]interface{}{"owner": 7}, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidData)
+	_, _, err = service.PutDataDocument("teams/payments", map[string]interface{}{"owner": "team-a"}, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrDataTooLarge, "the schema's limit replaces the default")
+	_, _, err = service.PutDataDocument("teams/payments", map[string]interface{}{"owner": "a"}, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	_, err = service.PutDataSchema("other", map[string]interface{}{"type": 7}, 0, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidDataSchema)
+
+	schemas, err := service.GetDataSchemas(1)
+	require.NoError(t, err)
+	require.Len(t, schemas, 1)
+	require.NoError(t, service.DeleteDataSchema("teams", 1, 1, models.RoleAdmin))
+	assert.ErrorIs(t, service.DeleteDataSchema("teams", 1, 1, models.RoleAdmin), ErrDataNotFound)
+}
+
+func TestPolicyService_EvaluateWithData(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Network Policies", Content: namespaceNetworkPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	namespace := map[string]interface{}{"kind": "Namespace", "metadata": map[string]interface{}{"name": "payments"}}
+
+	evaluation, err := service.TestPolicy(policy.ID, namespace, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, evaluation.Decision)
+
+	_, _, err = service.PutDataDocument("kubernetes/networkpolicies", networkPolicies("payments"), "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	evaluation, err = service.TestPolicy(policy.ID, namespace, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionAllow, evaluation.Decision)
+
+	// Changes are visible to the next evaluation
+	_, _, err = service.PutDataDocument("kubernetes/networkpolicies", networkPolicies("search"), "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	evaluation, err = service.TestPolicy(policy.ID, namespace, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, evaluation.Decision)
+
+	// Another organization's data is not mounted
+	other := &models.Policy{Name: "Network Policies", Content: namespaceNetworkPolicy}
+	require.NoError(t, service.CreatePolicy(other, 2, 2))
+	_, _, err = service.PutDataDocument("kubernetes/networkpolicies", networkPolicies("payments"), "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	evaluation, err = service.TestPolicy(other.ID, namespace, 2, 2, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, evaluation.Decision)
+}
+
+func TestOPAClient_SyncData(t *testing.T) {
+	fake := newFakeOPA(t, map[string]string{})
+	defer fake.Close()
+
+	var mu sync.Mutex
+	writes := []string{}
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/data/") {
+			mu.Lock()
+			writes = append(writes, r.URL.Path)
+			mu.Unlock()
+		}
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer opa.Close()
+
+	// Both organizations keep their network policies at the same path
+	snapshots := map[uint]*PolicyDataSnapshot{
+		1: {Revision: 1, Documents: map[string]interface{}{"kubernetes/networkpolicies": networkPolicies("payments")}},
+		2: {Revision: 2, Documents: map[string]interface{}{"kubernetes/networkpolicies": networkPolicies()}},
+	}
+	client := NewPolicyEvaluator(config.OPAConfig{Mode: OPAModeRemote, URL: opa.URL}, func(orgID uint) (*PolicyDataSnapshot, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return snapshots[orgID], nil
+	})
+	namespace := map[string]interface{}{"kind": "Namespace", "metadata": map[string]interface{}{"name": "payments"}}
+	first := &models.Policy{ID: 1, OrganizationID: 1, Content: namespaceNetworkPolicy}
+	second := &models.Policy{ID: 2, OrganizationID: 2, Content: namespaceNetworkPolicy}
+
+	for i := 0; i < 2; i++ {
+		result, err := client.Evaluate(context.Background(), first, namespace)
+		require.NoError(t, err)
+		assert.Equal(t, models.DecisionAllow, result.Decision)
+
+		result, err = client.Evaluate(context.Background(), second, namespace)
+		require.NoError(t, err)
+		assert.Equal(t, models.DecisionDeny, result.Decision, "organization 2 does not see the documents of organization 1")
+	}
+	assert.Equal(t, []string{
+		"/v1/data/niyama/organizations/1",
+		"/v1/data/niyama/organizations/2",
+	}, writes, "unchanged data is uploaded once")
+
+	// A new revision replaces the organization's documents, dropping deleted ones
+	mu.Lock()
+	snapshots[1] = &PolicyDataSnapshot{Revision: 3, Documents: map[string]interface{}{"teams": map[string]interface{}{}}}
+	mu.Unlock()
+	result, err := client.Evaluate(context.Background(), first, namespace)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, result.Decision)
+	assert.Len(t, writes, 3)
+}
+
+func TestBundleService_GetBundleWithData(t *testing.T) {
+	db := &database.Database{DB: setupTestDB(t)}
+	service := NewBundleService(db, &config.Config{})
+	policies := NewPolicyService(db, &config.Config{})
+	org := createTestOrganization(t, db, "acme")
+
+	policy := models.Policy{Name: "Network Policies", Content: namespaceNetworkPolicy, Status: models.StatusActive, OrganizationID: org.ID}
+	require.NoError(t, db.DB.Create(&policy).Error)
+
+	empty, err := service.GetBundle("acme")
+	require.NoError(t, err)
+
+	_, _, err = policies.PutDataDocument("kubernetes/networkpolicies", networkPolicies("payments"), "", 1, org.ID, models.RoleAdmin)
+	require.NoError(t, err)
+	b, err := service.GetBundle("acme")
+	require.NoError(t, err)
+	assert.NotEqual(t, empty.Revision, b.Revision)
+	assert.Equal(t, []string{"kubernetes/networkpolicies", "policy/network_policy"}, b.Roots)
+
+	var buf bytes.Buffer
+	require.NoError(t, b.Write(&buf))
+	loaded, err := bundle.NewReader(&buf).Read()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"kubernetes": map[string]interface{}{"networkpolicies": networkPolicies("payments")},
+	}, loaded.Data)
+}
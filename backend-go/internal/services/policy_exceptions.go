@@ -0,0 +1,298 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"niyama-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrExceptionNotFound is returned when an exception does not exist for the policy
+	ErrExceptionNotFound = errors.New("exception not found")
+	// ErrInvalidException is returned when an exception's selector or expiry is invalid
+	ErrInvalidException = errors.New("invalid exception")
+	// ErrExceptionNotAllowed is returned when the user may not approve an exception
+	ErrExceptionNotAllowed = errors.New("exception approval not allowed")
+)
+
+// GetExceptions lists the exceptions of a policy, including pending and expired ones
+func (s *PolicyService) GetExceptions(policyID, userID, orgID uint, userRole models.Role) ([]models.PolicyException, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	var exceptions []models.PolicyException
+	err := s.db.DB.Preload("Requester").Preload("Approver").
+		Where("policy_id = ?", policyID).
+		Order("expires_at ASC").Find(&exceptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return exceptions, nil
+}
+
+// GetExpiringExceptions lists the organization's approved exceptions that expire
+// within the given duration, soonest first
+func (s *PolicyService) GetExpiringExceptions(within time.Duration, orgID uint) ([]models.PolicyException, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	now := time.Now()
+	var exceptions []models.PolicyException
+	err := s.db.DB.Preload("Policy").Preload("Requester").Preload("Approver").
+		Joins("JOIN policies ON policies.id = policy_exceptions.policy_id AND policies.deleted_at IS NULL").
+		Where("policies.organization_id = ?", orgID).
+		Where("policy_exceptions.approved_at IS NOT NULL").
+		Where("policy_exceptions.expires_at > ? AND policy_exceptions.expires_at <= ?", now, now.Add(within)).
+		Order("policy_exceptions.expires_at ASC").Find(&exceptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return exceptions, nil
+}
+
+// CreateException requests an exception to a policy. It applies once approved.
+func (s *PolicyService) CreateException(policyID uint, exception *models.PolicyException, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getEditablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return err
+	}
+	if err := s.validateException(exception); err != nil {
+		return err
+	}
+
+	exception.ID = 0
+	exception.PolicyID = policyID
+	exception.RequesterID = userID
+	exception.ApproverID = nil
+	exception.ApprovedAt = nil
+	return s.db.DB.Create(exception).Error
+}
+
+// ApproveException approves a pending exception, which applies from then on until it expires
+func (s *PolicyService) ApproveException(policyID, exceptionID, userID, orgID uint, userRole models.Role) (*models.PolicyException, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+	exception, err := s.findException(policyID, exceptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only owners and admins grant exceptions
+	if userRole != models.RoleOwner && userRole != models.RoleAdmin {
+		return nil, fmt.Errorf("%w: insufficient permissions to approve exceptions", ErrExceptionNotAllowed)
+	}
+	if exception.RequesterID == userID && !s.cfg.Review.AllowSelfApproval {
+		return nil, fmt.Errorf("%w: requesters cannot approve their own exceptions", ErrExceptionNotAllowed)
+	}
+	if exception.ApprovedAt != nil {
+		return nil, fmt.Errorf("%w: exception is already approved", ErrInvalidException)
+	}
+	if !exception.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: exception has expired", ErrInvalidException)
+	}
+
+	now := time.Now()
+	exception.ApproverID = &userID
+	exception.ApprovedAt = &now
+	err = s.db.DB.Model(exception).Updates(map[string]interface{}{
+		"approver_id": userID,
+		"approved_at": now,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return exception, nil
+}
+
+// RevokeException deletes an exception, which stops applying immediately
+func (s *PolicyService) RevokeException(policyID, exceptionID, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getEditablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return err
+	}
+	exception, err := s.findException(policyID, exceptionID)
+	if err != nil {
+		return err
+	}
+	return s.db.DB.Delete(exception).Error
+}
+
+func (s *PolicyService) findException(policyID, exceptionID uint) (*models.PolicyException, error) {
+	var exception models.PolicyException
+	if err := s.db.DB.Where("policy_id = ? AND id = ?", policyID, exceptionID).First(&exception).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrExceptionNotFound
+		}
+		return nil, err
+	}
+	return &exception, nil
+}
+
+// validateException checks the selector, justification and expiry of a requested exception
+func (s *PolicyService) validateException(exception *models.PolicyException) error {
+	if len(exception.Selector) == 0 {
+		return fmt.Errorf("%w: selector must match at least one input field", ErrInvalidException)
+	}
+	for field, value := range exception.Selector {
+		if field == "" || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") {
+			return fmt.Errorf("%w: invalid selector field %q", ErrInvalidException, field)
+		}
+		if pattern, ok := value.(string); ok {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("%w: invalid pattern for %s: %v", ErrInvalidException, field, err)
+			}
+		}
+	}
+	if strings.TrimSpace(exception.Justification) == "" {
+		return fmt.Errorf("%w: justification is required", ErrInvalidException)
+	}
+
+	now := time.Now()
+	if !exception.ExpiresAt.After(now) {
+		return fmt.Errorf("%w: expires_at must be in the future", ErrInvalidException)
+	}
+	if limit := s.cfg.Review.ExceptionMaxDuration; limit > 0 && exception.ExpiresAt.After(now.Add(limit)) {
+		return fmt.Errorf("%w: exceptions may be granted for at most %s", ErrInvalidException, limit)
+	}
+	return nil
+}
+
+// activeExceptions returns the approved, unexpired exceptions of a policy
+func (s *PolicyService) activeExceptions(policyID uint) []models.PolicyException {
+	if s.db == nil {
+		return nil
+	}
+
+	var exceptions []models.PolicyException
+	err := s.db.DB.Where("policy_id = ? AND approved_at IS NOT NULL AND expires_at > ?", policyID, time.Now()).
+		Order("expires_at DESC").Find(&exceptions).Error
+	if err != nil {
+		return nil
+	}
+	return exceptions
+}
+
+// activeExceptionsByPolicy returns the approved, unexpired exceptions of several
+// policies by policy ID
+func (s *PolicyService) activeExceptionsByPolicy(policyIDs []uint) map[uint][]models.PolicyException {
+	exceptions := make(map[uint][]models.PolicyException, len(policyIDs))
+	if s.db == nil || len(policyIDs) == 0 {
+		return exceptions
+	}
+
+	var active []models.PolicyException
+	err := s.db.DB.Where("policy_id IN ? AND approved_at IS NOT NULL AND expires_at > ?", policyIDs, time.Now()).
+		Order("expires_at DESC").Find(&active).Error
+	if err != nil {
+		return exceptions
+	}
+	for _, exception := range active {
+		exceptions[exception.PolicyID] = append(exceptions[exception.PolicyID], exception)
+	}
+	return exceptions
+}
+
+// matchException returns the first exception whose selector matches the input, or nil.
+// Exceptions loaded before a long evaluation are re-checked for expiry.
+func matchException(exceptions []models.PolicyException, input map[string]interface{}) *models.PolicyException {
+	now := time.Now()
+	for i := range exceptions {
+		if exceptions[i].ExpiresAt.After(now) && selectorMatches(exceptions[i].Selector, input) {
+			return &exceptions[i]
+		}
+	}
+	return nil
+}
+
+// selectorMatches reports whether every field of the selector matches the input.
+// Strings match as glob patterns, lists match any of their values.
+func selectorMatches(selector map[string]interface{}, input map[string]interface{}) bool {
+	for field, want := range selector {
+		got, ok := lookupInput(input, field)
+		if !ok || !selectorValueMatches(want, got) {
+			return false
+		}
+	}
+	return true
+}
+
+func selectorValueMatches(want, got interface{}) bool {
+	switch want := want.(type) {
+	case string:
+		value, ok := got.(string)
+		if !ok {
+			return false
+		}
+		matched, err := path.Match(want, value)
+		return err == nil && matched
+	case []interface{}:
+		for _, option := range want {
+			if selectorValueMatches(option, got) {
+				return true
+			}
+		}
+		return false
+	default:
+		return reflect.DeepEqual(normalizeJSON(want), normalizeJSON(got))
+	}
+}
+
+// lookupInput resolves a dotted path within the input
+func lookupInput(input map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = input
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// normalizeJSON converts a value to its JSON representation so numbers compare equal
+func normalizeJSON(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+// waive turns a deny into an allow, keeping the waived violations
+func waive(result *EvaluationResult) {
+	result.Waived = result.Violations
+	result.Violations = []string{}
+	result.Decision = models.DecisionAllow
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rootPod(namespace string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":     "Pod",
+		"metadata": map[string]interface{}{"namespace": namespace},
+		"spec":     map[string]interface{}{},
+	}
+}
+
+func TestPolicyService_Exceptions(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	service.cfg.Review.ExceptionMaxDuration = 24 * time.Hour
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy, AccessLevel: models.AccessOrg}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	invalid := []models.PolicyException{
+		{Justification: "migration", ExpiresAt: time.Now().Add(time.Hour)},
+		{Selector: map[string]interface{}{"metadata.namespace": "legacy"}, ExpiresAt: time.Now().Add(time.Hour)},
+		{Selector: map[string]interface{}{"metadata.namespace": "legacy"}, Justification: "migration", ExpiresAt: time.Now().Add(-time.Hour)},
+		{Selector: map[string]interface{}{"metadata.namespace": "legacy"}, Justification: "migration", ExpiresAt: time.Now().Add(48 * time.Hour)},
+		{Selector: map[string]interface{}{"metadata.namespace": "[legacy"}, Justification: "migration", ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for _, exception := range invalid {
+		assert.ErrorIs(t, service.CreateException(policy.ID, &exception, 1, 1, models.RoleEditor), ErrInvalidException)
+	}
+
+	exception := &models.PolicyException{
+		Selector:      map[string]interface{}{"metadata.namespace": "legacy-*"},
+		Justification: "Batch jobs run as root until the migration finishes",
+		ExpiresAt:     time.Now().Add(time.Hour),
+	}
+	require.NoError(t, service.CreateException(policy.ID, exception, 1, 1, models.RoleEditor))
+
+	// Pending exceptions do not apply
+	evaluation, err := service.TestPolicy(policy.ID, rootPod("legacy-batch"), 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, evaluation.Decision)
+
+	_, err = service.ApproveException(policy.ID, exception.ID, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrExceptionNotAllowed, "requesters cannot approve their own exceptions")
+	_, err = service.ApproveException(policy.ID, exception.ID, 2, 1, models.RoleEditor)
+	assert.ErrorIs(t, err, ErrExceptionNotAllowed)
+	approved, err := service.ApproveException(policy.ID, exception.ID, 2, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.NotNil(t, approved.ApproverID)
+	assert.Equal(t, uint(2), *approved.ApproverID)
+
+	evaluation, err = service.TestPolicy(policy.ID, rootPod("legacy-batch"), 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionAllow, evaluation.Decision)
+	require.NotNil(t, evaluation.ExceptionID)
+	assert.Equal(t, exception.ID, *evaluation.ExceptionID)
+
+	batch, err := service.EvaluateBatch(policy.ID, []map[string]interface{}{rootPod("legacy-batch"), rootPod("default")}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionAllow, batch.Results[0].Decision)
+	assert.Equal(t, []string{"pods must run as non-root"}, batch.Results[0].Waived)
+	assert.Empty(t, batch.Results[0].Violations)
+	assert.Equal(t, models.DecisionDeny, batch.Results[1].Decision)
+	assert.Nil(t, batch.Results[1].ExceptionID)
+
+	expiring, err := service.GetExpiringExceptions(2*time.Hour, 1)
+	require.NoError(t, err)
+	require.Len(t, expiring, 1)
+	assert.Equal(t, policy.ID, expiring[0].Policy.ID)
+	expiring, err = service.GetExpiringExceptions(30*time.Minute, 1)
+	require.NoError(t, err)
+	assert.Empty(t, expiring)
+	expiring, err = service.GetExpiringExceptions(2*time.Hour, 2)
+	require.NoError(t, err)
+	assert.Empty(t, expiring, "other organizations' exceptions are not listed")
+
+	// Expired exceptions stop applying
+	require.NoError(t, db.DB.Model(exception).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	evaluation, err = service.TestPolicy(policy.ID, rootPod("legacy-batch"), 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, evaluation.Decision)
+	assert.Nil(t, evaluation.ExceptionID)
+
+	exceptions, err := service.GetExceptions(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Len(t, exceptions, 1, "expired exceptions are still listed")
+
+	require.NoError(t, service.RevokeException(policy.ID, exception.ID, 1, 1, models.RoleAdmin))
+	assert.ErrorIs(t, service.RevokeException(policy.ID, exception.ID, 1, 1, models.RoleAdmin), ErrExceptionNotFound)
+}
+
+func TestSelectorMatches(t *testing.T) {
+	input := map[string]interface{}{
+		"kind":     "Pod",
+		"metadata": map[string]interface{}{"namespace": "legacy-batch", "labels": map[string]interface{}{"team": "data"}},
+		"spec":     map[string]interface{}{"replicas": float64(3), "hostNetwork": true},
+	}
+
+	tests := []struct {
+		name     string
+		selector map[string]interface{}
+		want     bool
+	}{
+		{"exact", map[string]interface{}{"kind": "Pod"}, true},
+		{"glob", map[string]interface{}{"metadata.namespace": "legacy-*"}, true},
+		{"any of", map[string]interface{}{"metadata.labels.team": []interface{}{"platform", "data"}}, true},
+		{"number", map[string]interface{}{"spec.replicas": 3}, true},
+		{"bool", map[string]interface{}{"spec.hostNetwork": true}, true},
+		{"all fields must match", map[string]interface{}{"kind": "Pod", "metadata.namespace": "default"}, false},
+		{"missing field", map[string]interface{}{"metadata.labels.owner": "data"}, false},
+		{"not an object", map[string]interface{}{"kind.name": "Pod"}, false},
+		{"type mismatch", map[string]interface{}{"spec.replicas": "3"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, selectorMatches(tt.selector, input))
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"niyama-backend/internal/models"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"gopkg.in/yaml.v3"
+)
+
+// GetPolicyExports returns the commits of a policy to its organization's sources, newest first
+func (s *PolicyService) GetPolicyExports(policyID, userID, orgID uint, userRole models.Role) ([]models.PolicyExport, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	var exports []models.PolicyExport
+	err := s.db.DB.Where("policy_id = ?", policyID).Order("id DESC").Find(&exports).Error
+	return exports, err
+}
+
+// startExport exports a policy in the background, like replays, so the request
+// that changed the policy does not wait for the clone and push of every source
+func (s *PolicyService) startExport(policy *models.Policy, mode string, origin *uint) {
+	var sources int64
+	err := s.db.DB.Model(&models.PolicySource{}).
+		Where("organization_id = ? AND export = ?", policy.OrganizationID, mode).Count(&sources).Error
+	if err != nil {
+		log.Printf("Warning: failed to load export sources for policy %d: %v", policy.ID, err)
+		return
+	}
+	if sources == 0 {
+		return
+	}
+
+	policyID := policy.ID
+	s.exports.Add(1)
+	go func() {
+		defer s.exports.Done()
+		s.exportPolicy(policyID, mode, origin)
+	}()
+}
+
+// exportPolicy commits the current version of a policy to the organization's
+// sources that export on mode, skipping origin, the source the change was synced
+// from. The policy change is already saved, so failures are recorded on the
+// export instead of being returned.
+func (s *PolicyService) exportPolicy(policyID uint, mode string, origin *uint) {
+	s.exportMu.Lock()
+	defer s.exportMu.Unlock()
+
+	var policy models.Policy
+	if err := s.db.DB.Preload("Author").First(&policy, policyID).Error; err != nil {
+		log.Printf("Warning: failed to load policy %d for export: %v", policyID, err)
+		return
+	}
+	if !isRegoPolicy(&policy) {
+		return
+	}
+
+	var sources []models.PolicySource
+	err := s.db.DB.Where("organization_id = ? AND export = ?", policy.OrganizationID, mode).Order("id").Find(&sources).Error
+	if err != nil {
+		log.Printf("Warning: failed to load export sources for policy %d: %v", policyID, err)
+		return
+	}
+
+	for i := range sources {
+		source := &sources[i]
+		if origin != nil && *origin == source.ID {
+			continue
+		}
+		if err := s.exportPolicyToSource(&policy, source); err != nil {
+			log.Printf("Warning: failed to record export of policy %d: %v", policyID, err)
+		}
+	}
+}
+
+// exportPolicyToSource commits the policy and its sidecar metadata to one source.
+// Policies without a source are linked to the first source they are exported to,
+// so a later sync of that source recognizes the file.
+func (s *PolicyService) exportPolicyToSource(policy *models.Policy, source *models.PolicySource) error {
+	export := &models.PolicyExport{PolicyID: policy.ID, SourceID: source.ID, Version: currentVersion(policy)}
+
+	commit, err := s.commitPolicy(policy, source, export)
+	if err != nil {
+		export.Error = err.Error()
+		return s.db.DB.Create(export).Error
+	}
+	export.Commit = commit
+
+	if err := s.db.DB.Create(export).Error; err != nil {
+		return err
+	}
+	if policy.SourceID != nil {
+		return nil
+	}
+
+	sourceCommit := commit
+	if sourceCommit == "" {
+		sourceCommit = source.LastCommit
+	}
+	return s.db.DB.Model(policy).UpdateColumns(map[string]interface{}{
+		"source_id":     source.ID,
+		"source_path":   export.Path,
+		"source_commit": sourceCommit,
+	}).Error
+}
+
+// commitPolicy writes the policy file and its sidecar into the source's branch and
+// pushes the commit. It returns an empty commit when the files are up to date.
+func (s *PolicyService) commitPolicy(policy *models.Policy, source *models.PolicySource, export *models.PolicyExport) (string, error) {
+	if err := s.checkRepositoryURL(source.RepositoryURL); err != nil {
+		return "", err
+	}
+	pkg, err := PackagePath(policy.Content)
+	if err != nil {
+		return "", err
+	}
+	export.Path = exportPath(policy, source, pkg)
+
+	sidecar, err := yaml.Marshal(policyFileMeta{
+		Name:         policy.Name,
+		Description:  policy.Description,
+		Category:     policy.Category,
+		Tags:         policy.Tags,
+		AccessLevel:  policy.AccessLevel,
+		RequireTests: policy.RequireTests,
+		Metadata:     policy.Metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	files := map[string]string{
+		export.Path: policy.Content,
+		strings.TrimSuffix(export.Path, ".rego") + ".yaml": string(sidecar),
+	}
+
+	var version models.PolicyVersion
+	message := fmt.Sprintf("Update %s", policy.Name)
+	if err := s.db.DB.Where("policy_id = ? AND version = ?", policy.ID, export.Version).First(&version).Error; err == nil && version.Message != "" {
+		message = version.Message
+	}
+	message = fmt.Sprintf("%s\n\nNiyama-Policy: %d\nNiyama-Version: %d\n", message, policy.ID, export.Version)
+
+	ctx, cancel := s.gitContext()
+	defer cancel()
+
+	now := time.Now()
+	author := &object.Signature{Name: s.cfg.Git.CommitterName, Email: s.cfg.Git.CommitterEmail, When: now}
+	if policy.Author.ID != 0 {
+		author = &object.Signature{Name: userDisplayName(&policy.Author), Email: policy.Author.Email, When: now}
+	}
+	committer := &object.Signature{Name: s.cfg.Git.CommitterName, Email: s.cfg.Git.CommitterEmail, When: now}
+
+	return commitFiles(ctx, source, files, message, author, committer)
+}
+
+// commitFiles clones the head of the source's branch into memory, writes files and
+// pushes a single commit with them
+func commitFiles(ctx context.Context, source *models.PolicySource, files map[string]string, message string, author, committer *object.Signature) (string, error) {
+	branch := plumbing.NewBranchReferenceName(source.Branch)
+	fs := memfs.New()
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), fs, &git.CloneOptions{
+		URL:           source.RepositoryURL,
+		ReferenceName: branch,
+		SingleBranch:  true,
+		Depth:         1,
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range sortedKeys(files) {
+		if err := util.WriteFile(fs, name, []byte(files[name]), 0o644); err != nil {
+			return "", err
+		}
+		if _, err := worktree.Add(name); err != nil {
+			return "", err
+		}
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return "", err
+	}
+	if status.IsClean() {
+		return "", nil
+	}
+
+	hash, err := worktree.Commit(message, &git.CommitOptions{Author: author, Committer: committer})
+	if err != nil {
+		return "", err
+	}
+
+	err = repo.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(branch + ":" + branch)},
+	})
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
+
+// exportPath is where a policy is written in a source: the file it was synced
+// from, or a path mirroring its package and ending in the policy ID, e.g.
+// kubernetes/security-12.rego for policy 12 in package kubernetes.security. Several
+// policies may declare one package, so the package alone does not name a file.
+func exportPath(policy *models.Policy, source *models.PolicySource, pkg string) string {
+	if policy.SourceID != nil && *policy.SourceID == source.ID && policy.SourcePath != "" {
+		return policy.SourcePath
+	}
+	return path.Join(source.Path, fmt.Sprintf("%s-%d.rego", strings.ReplaceAll(pkg, ".", "/"), policy.ID))
+}
+
+func userDisplayName(user *models.User) string {
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		return name
+	}
+	return user.Username
+}
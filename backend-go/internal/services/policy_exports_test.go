@@ -0,0 +1,176 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// head returns the latest commit on the remote's main branch
+func (r *testGitRemote) head() *object.Commit {
+	r.t.Helper()
+
+	repo, err := git.Clone(memory.NewStorage(), nil, &git.CloneOptions{URL: r.url})
+	require.NoError(r.t, err)
+	ref, err := repo.Head()
+	require.NoError(r.t, err)
+	commit, err := repo.CommitObject(ref.Hash())
+	require.NoError(r.t, err)
+	return commit
+}
+
+// file reads a file at the remote's latest commit
+func (r *testGitRemote) file(name string) string {
+	r.t.Helper()
+
+	file, err := r.head().File(name)
+	require.NoError(r.t, err)
+	content, err := file.Contents()
+	require.NoError(r.t, err)
+	return content
+}
+
+func TestPolicyService_ExportOnUpdate(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	service.cfg.Git = config.GitConfig{CommitterName: "Niyama", CommitterEmail: "niyama@example.com", AllowLocalRepositories: true}
+	require.NoError(t, db.DB.Create(&models.User{ID: 1, Email: "ada@example.com", Username: "ada", Password: "x", FirstName: "Ada", LastName: "Lovelace"}).Error)
+
+	remote := newTestGitRemote(t)
+	remote.commit(map[string]string{"README.md": "# Policies"}, "Initial commit")
+
+	source := &models.PolicySource{Name: "Platform policies", RepositoryURL: remote.url, Path: "policies", Export: models.SourceExportUpdate}
+	require.NoError(t, service.CreatePolicySource(source, 1, 1, models.RoleAdmin))
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy, Category: "security", ChangeMessage: "Add pod security policy"}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	service.Wait()
+
+	// The file layout mirrors the package path
+	head := remote.head()
+	assert.Equal(t, podSecurityPolicy, remote.file("policies/kubernetes/security-1.rego"))
+	assert.Contains(t, remote.file("policies/kubernetes/security-1.yaml"), "name: Pod Security")
+	assert.True(t, strings.HasPrefix(head.Message, "Add pod security policy\n"))
+	assert.Equal(t, "Ada Lovelace", head.Author.Name)
+	assert.Equal(t, "ada@example.com", head.Author.Email)
+	assert.Equal(t, "Niyama", head.Committer.Name)
+
+	// The policy is linked to the file it was exported to
+	require.NoError(t, db.DB.First(policy, policy.ID).Error)
+	require.NotNil(t, policy.SourceID)
+	assert.Equal(t, source.ID, *policy.SourceID)
+	assert.Equal(t, "policies/kubernetes/security-1.rego", policy.SourcePath)
+	assert.Equal(t, head.Hash.String(), policy.SourceCommit)
+
+	err := service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicyV2, ChangeMessage: "Also deny host network"}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	service.Wait()
+	assert.Equal(t, podSecurityPolicyV2, remote.file("policies/kubernetes/security-1.rego"))
+	assert.True(t, strings.HasPrefix(remote.head().Message, "Also deny host network\n"))
+
+	// Metadata-only updates are not committed
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Description: "Pod hardening"}, 1, 1, models.RoleAdmin))
+	service.Wait()
+
+	exports, err := service.GetPolicyExports(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.Len(t, exports, 2)
+	assert.Equal(t, 2, exports[0].Version)
+	assert.Equal(t, remote.head().Hash.String(), exports[0].Commit)
+	assert.Empty(t, exports[0].Error)
+
+	// Syncing the source back finds the exported policy unchanged
+	sync, err := service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Zero(t, sync.Created)
+	assert.Zero(t, sync.Failed)
+}
+
+func TestPolicyService_ExportOnApproval(t *testing.T) {
+	service, db := newTestReviewPolicyService(t, 1)
+	service.cfg.Git = config.GitConfig{CommitterName: "Niyama", CommitterEmail: "niyama@example.com", AllowLocalRepositories: true}
+
+	remote := newTestGitRemote(t)
+	initial := remote.commit(map[string]string{"README.md": "# Policies"}, "Initial commit")
+
+	source := &models.PolicySource{Name: "Approved policies", RepositoryURL: remote.url, Export: models.SourceExportApproval}
+	require.NoError(t, service.CreatePolicySource(source, 1, 1, models.RoleAdmin))
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy, AccessLevel: models.AccessOrg}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	service.Wait()
+	assert.Equal(t, initial, remote.head().Hash.String(), "drafts are not exported")
+
+	_, err := service.SubmitForReview(policy.ID, "ready", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewApproved, "", 2, 1, models.RoleEditor)
+	require.NoError(t, err)
+	service.Wait()
+
+	assert.Equal(t, podSecurityPolicy, remote.file("kubernetes/security-1.rego"))
+	assert.True(t, strings.HasPrefix(remote.head().Message, "Initial version\n"))
+	// Without an author record the commit is authored by the committer identity
+	assert.Equal(t, "Niyama", remote.head().Author.Name)
+
+	var exports []models.PolicyExport
+	require.NoError(t, db.DB.Find(&exports).Error)
+	assert.Len(t, exports, 1)
+}
+
+func TestPolicyService_ExportSharedPackage(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	remote := newTestGitRemote(t)
+	remote.commit(map[string]string{"README.md": "# Policies"}, "Initial commit")
+
+	source := &models.PolicySource{Name: "Platform policies", RepositoryURL: remote.url, Export: models.SourceExportUpdate}
+	require.NoError(t, service.CreatePolicySource(source, 1, 1, models.RoleAdmin))
+
+	// Both policies declare package kubernetes.security
+	first := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	second := &models.Policy{Name: "Host Network", Content: hostNetworkPolicy}
+	require.NoError(t, service.CreatePolicy(first, 1, 1))
+	require.NoError(t, service.CreatePolicy(second, 1, 1))
+	service.Wait()
+
+	assert.Equal(t, podSecurityPolicy, remote.file("kubernetes/security-1.rego"))
+	assert.Equal(t, hostNetworkPolicy, remote.file("kubernetes/security-2.rego"))
+
+	var linked []models.Policy
+	require.NoError(t, db.DB.Order("id").Find(&linked).Error)
+	require.Len(t, linked, 2)
+	assert.Equal(t, "kubernetes/security-1.rego", linked[0].SourcePath)
+	assert.Equal(t, "kubernetes/security-2.rego", linked[1].SourcePath)
+
+	// Syncing the source back matches each file to its policy
+	sync, err := service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sync.Unchanged)
+	assert.Zero(t, sync.Created)
+}
+
+func TestPolicyService_ExportFailureIsRecorded(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	source := &models.PolicySource{Name: "Unreachable", RepositoryURL: t.TempDir(), Export: models.SourceExportUpdate}
+	require.NoError(t, service.CreatePolicySource(source, 1, 1, models.RoleAdmin))
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1), "export failures do not fail the change")
+	service.Wait()
+
+	var export models.PolicyExport
+	require.NoError(t, db.DB.Where("policy_id = ?", policy.ID).First(&export).Error)
+	assert.NotEmpty(t, export.Error)
+	assert.Empty(t, export.Commit)
+
+	require.NoError(t, db.DB.First(policy, policy.ID).Error)
+	assert.Nil(t, policy.SourceID)
+}
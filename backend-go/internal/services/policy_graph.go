@@ -0,0 +1,288 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// ErrPolicyHasDependents is returned when removing a policy would break active policies that use it
+var ErrPolicyHasDependents = errors.New("policy has active dependents")
+
+// DependentsError lists the active policies that depend on a policy being removed
+type DependentsError struct {
+	Dependents []PolicyNode
+}
+
+func (e *DependentsError) Error() string {
+	names := make([]string, len(e.Dependents))
+	for i, dependent := range e.Dependents {
+		names[i] = fmt.Sprintf("%s (%d)", dependent.Name, dependent.PolicyID)
+	}
+	return fmt.Sprintf("%v: %s", ErrPolicyHasDependents, strings.Join(names, ", "))
+}
+
+func (e *DependentsError) Unwrap() error {
+	return ErrPolicyHasDependents
+}
+
+// PolicyDependencies is what a Rego module declares and references
+type PolicyDependencies struct {
+	Package  string   `json:"package"`   // e.g. "kubernetes.security"
+	Imports  []string `json:"imports"`   // imported data documents, e.g. "lib.kubernetes"
+	DataRefs []string `json:"data_refs"` // data documents referenced outside the package
+}
+
+// Graph node types
+const (
+	GraphNodePolicy = "policy"
+	GraphNodeData   = "data"
+)
+
+// PolicyNode is a node of the dependency graph: a policy, or a data document no
+// policy of the organization provides
+type PolicyNode struct {
+	ID       string `json:"id"` // "policy:<id>" or "data:<path>"
+	Type     string `json:"type"`
+	PolicyID uint   `json:"policy_id,omitempty"`
+	Name     string `json:"name"`
+	Package  string `json:"package,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Error    string `json:"error,omitempty"` // set when the policy content could not be analyzed
+}
+
+// PolicyEdge points from a policy to a node it depends on through ref
+type PolicyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Ref  string `json:"ref"`
+	Kind string `json:"kind"` // "import" or "reference"
+}
+
+// PolicyGraph is the dependency graph of an organization's policies
+type PolicyGraph struct {
+	Nodes []PolicyNode `json:"nodes"`
+	Edges []PolicyEdge `json:"edges"`
+}
+
+// AnalyzePolicy extracts the package, imports and data references of a Rego module
+func AnalyzePolicy(content string) (*PolicyDependencies, error) {
+	module, err := ast.ParseModule("policy.rego", content)
+	if err != nil {
+		return nil, err
+	}
+	if module == nil {
+		return nil, fmt.Errorf("policy content is empty")
+	}
+
+	pkg := strings.TrimPrefix(module.Package.Path.String(), "data.")
+	deps := &PolicyDependencies{Package: pkg, Imports: []string{}, DataRefs: []string{}}
+
+	imports := map[string]bool{}
+	for _, imp := range module.Imports {
+		ref, ok := imp.Path.Value.(ast.Ref)
+		if !ok {
+			continue
+		}
+		if path, ok := dataPath(ref); ok {
+			imports[path] = true
+		}
+	}
+
+	refs := map[string]bool{}
+	for _, rule := range module.Rules {
+		ast.WalkRefs(rule, func(ref ast.Ref) bool {
+			if path, ok := dataPath(ref.ConstantPrefix()); ok && !withinPackage(path, pkg) {
+				refs[path] = true
+			}
+			return false
+		})
+	}
+
+	deps.Imports = sortedKeys(imports)
+	deps.DataRefs = sortedKeys(refs)
+	return deps, nil
+}
+
+// GetDependencyGraph builds the dependency graph of the organization's policies
+func (s *PolicyService) GetDependencyGraph(userID, orgID uint, userRole models.Role) (*PolicyGraph, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var policies []models.Policy
+	if err := s.db.DB.Where("organization_id = ?", orgID).Order("id").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+
+	return buildPolicyGraph(policies), nil
+}
+
+// GetDependents returns the active policies of the organization that depend on a policy
+func (s *PolicyService) GetDependents(policyID, userID, orgID uint, userRole models.Role) ([]PolicyNode, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.GetPolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+	return s.activeDependents(policy)
+}
+
+// activeDependents finds the other active policies of the policy's organization
+// that import or reference its package
+func (s *PolicyService) activeDependents(policy *models.Policy) ([]PolicyNode, error) {
+	if !isRegoPolicy(policy) {
+		return []PolicyNode{}, nil
+	}
+	deps, err := AnalyzePolicy(policy.Content)
+	if err != nil {
+		return []PolicyNode{}, nil
+	}
+
+	var candidates []models.Policy
+	err = s.db.DB.Where("organization_id = ? AND status = ? AND id <> ?", policy.OrganizationID, models.StatusActive, policy.ID).
+		Order("id").Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := []PolicyNode{}
+	for _, candidate := range candidates {
+		if !isRegoPolicy(&candidate) {
+			continue
+		}
+		candidateDeps, err := AnalyzePolicy(candidate.Content)
+		if err != nil {
+			continue
+		}
+		for _, ref := range append(candidateDeps.Imports, candidateDeps.DataRefs...) {
+			if refersToPackage(ref, deps.Package) {
+				dependents = append(dependents, policyNode(&candidate, candidateDeps, nil))
+				break
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// buildPolicyGraph links every import and data reference to the policies whose
+// packages provide it, or to a data node when no policy does
+func buildPolicyGraph(policies []models.Policy) *PolicyGraph {
+	graph := &PolicyGraph{Nodes: []PolicyNode{}, Edges: []PolicyEdge{}}
+
+	analyzed := make([]*PolicyDependencies, len(policies))
+	for i := range policies {
+		var deps *PolicyDependencies
+		var err error
+		if isRegoPolicy(&policies[i]) {
+			deps, err = AnalyzePolicy(policies[i].Content)
+		}
+		analyzed[i] = deps
+		graph.Nodes = append(graph.Nodes, policyNode(&policies[i], deps, err))
+	}
+
+	dataNodes := map[string]bool{}
+	for i, deps := range analyzed {
+		if deps == nil {
+			continue
+		}
+		from := graph.Nodes[i].ID
+
+		refs := map[string]string{}
+		for _, ref := range deps.DataRefs {
+			refs[ref] = "reference"
+		}
+		for _, ref := range deps.Imports {
+			refs[ref] = "import"
+		}
+
+		for _, ref := range sortedKeys(refs) {
+			matched := false
+			for j, target := range analyzed {
+				if j == i || target == nil || !refersToPackage(ref, target.Package) {
+					continue
+				}
+				graph.Edges = append(graph.Edges, PolicyEdge{From: from, To: graph.Nodes[j].ID, Ref: "data." + ref, Kind: refs[ref]})
+				matched = true
+			}
+			if matched {
+				continue
+			}
+
+			id := "data:" + ref
+			if !dataNodes[id] {
+				dataNodes[id] = true
+				graph.Nodes = append(graph.Nodes, PolicyNode{ID: id, Type: GraphNodeData, Name: "data." + ref})
+			}
+			graph.Edges = append(graph.Edges, PolicyEdge{From: from, To: id, Ref: "data." + ref, Kind: refs[ref]})
+		}
+	}
+
+	return graph
+}
+
+func policyNode(policy *models.Policy, deps *PolicyDependencies, err error) PolicyNode {
+	node := PolicyNode{
+		ID:       fmt.Sprintf("policy:%d", policy.ID),
+		Type:     GraphNodePolicy,
+		PolicyID: policy.ID,
+		Name:     policy.Name,
+		Status:   policy.Status.String(),
+	}
+	if deps != nil {
+		node.Package = deps.Package
+	}
+	if err != nil {
+		node.Error = err.Error()
+	}
+	return node
+}
+
+// dataPath returns the dotted path of a constant data reference without the
+// "data." prefix. References to the whole data document are ignored.
+func dataPath(ref ast.Ref) (string, bool) {
+	if len(ref) < 2 || !ref[0].Equal(ast.DefaultRootDocument) {
+		return "", false
+	}
+
+	parts := make([]string, 0, len(ref)-1)
+	for _, term := range ref[1:] {
+		str, ok := term.Value.(ast.String)
+		if !ok {
+			break
+		}
+		parts = append(parts, string(str))
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, "."), true
+}
+
+// refersToPackage reports whether a data path reads from a package: a rule of
+// the package, the package document itself, or a document that contains it
+func refersToPackage(ref, pkg string) bool {
+	return withinPackage(ref, pkg) || strings.HasPrefix(pkg, ref+".")
+}
+
+// withinPackage reports whether a data path is the package or one of its rules
+func withinPackage(path, pkg string) bool {
+	return path == pkg || strings.HasPrefix(path, pkg+".")
+}
+
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
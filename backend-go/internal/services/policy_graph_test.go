@@ -0,0 +1,89 @@
+package services
+
+import (
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const libPolicy = `package lib.kubernetes
+
+is_pod {
+    input.kind == "Pod"
+}`
+
+const networkPolicy = `package kubernetes.network
+
+import data.lib.kubernetes
+import future.keywords.in
+
+deny[msg] {
+    kubernetes.is_pod
+    not input.metadata.labels.app
+    msg := "pods must be labelled"
+}
+
+deny[msg] {
+    some i in data.kubernetes.networkpolicies
+    i.spec.podSelector == {}
+    msg := "network policies must select pods"
+}
+
+deny[msg] {
+    data.kubernetes.network.exempt[input.metadata.name]
+    msg := "self references are ignored"
+}`
+
+func TestAnalyzePolicy(t *testing.T) {
+	deps, err := AnalyzePolicy(networkPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, "kubernetes.network", deps.Package)
+	assert.Equal(t, []string{"lib.kubernetes"}, deps.Imports)
+	assert.Equal(t, []string{"kubernetes.networkpolicies"}, deps.DataRefs)
+
+	_, err = AnalyzePolicy("package broken\n\nallow {")
+	assert.Error(t, err)
+}
+
+func TestPolicyService_GetDependencyGraph(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	lib := &models.Policy{Name: "Kubernetes library", Content: libPolicy, Status: models.StatusActive, OrganizationID: 1}
+	network := &models.Policy{Name: "Network", Content: networkPolicy, Status: models.StatusActive, OrganizationID: 1}
+	other := &models.Policy{Name: "Other org", Content: libPolicy, Status: models.StatusActive, OrganizationID: 2}
+	for _, policy := range []*models.Policy{lib, network, other} {
+		require.NoError(t, db.DB.Create(policy).Error)
+	}
+
+	graph, err := service.GetDependencyGraph(1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	require.Len(t, graph.Nodes, 3)
+	assert.Equal(t, "lib.kubernetes", graph.Nodes[0].Package)
+	assert.Equal(t, PolicyNode{ID: "data:kubernetes.networkpolicies", Type: GraphNodeData, Name: "data.kubernetes.networkpolicies"}, graph.Nodes[2])
+	assert.Equal(t, []PolicyEdge{
+		{From: "policy:2", To: "data:kubernetes.networkpolicies", Ref: "data.kubernetes.networkpolicies", Kind: "reference"},
+		{From: "policy:2", To: "policy:1", Ref: "data.lib.kubernetes", Kind: "import"},
+	}, graph.Edges)
+
+	// Deleting the library breaks the active network policy
+	dependents, err := service.GetDependents(lib.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.Len(t, dependents, 1)
+	assert.Equal(t, network.ID, dependents[0].PolicyID)
+
+	err = service.DeletePolicy(lib.ID, 1, 1, models.RoleAdmin, false)
+	var dependentsErr *DependentsError
+	require.ErrorAs(t, err, &dependentsErr)
+	assert.ErrorIs(t, err, ErrPolicyHasDependents)
+	assert.Equal(t, network.ID, dependentsErr.Dependents[0].PolicyID)
+
+	// Inactive dependents do not block deletion
+	require.NoError(t, db.DB.Model(network).Update("status", models.StatusInactive).Error)
+	require.NoError(t, service.DeletePolicy(lib.ID, 1, 1, models.RoleAdmin, false))
+	require.NoError(t, service.DeletePolicy(network.ID, 1, 1, models.RoleAdmin, true))
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"niyama-backend/internal/models"
+)
+
+// AdmissionPatchTypeJSONPatch is the only patch type of admission.k8s.io/v1
+const AdmissionPatchTypeJSONPatch = "JSONPatch"
+
+// MutationResult is the preview of the patch a policy applies to an object
+type MutationResult struct {
+	PolicyID uint                   `json:"policy_id"`
+	Patch    []PatchOperation       `json:"patch"`
+	Patched  map[string]interface{} `json:"patched"` // the object with the patch applied
+	Duration int64                  `json:"duration"`
+}
+
+// MutatePolicy previews the patch a policy's patch rule produces for an object.
+// The patch is validated against the object and nothing is recorded.
+func (s *PolicyService) MutatePolicy(policyID uint, object map[string]interface{}, userID, orgID uint, userRole models.Role) (*MutationResult, error) {
+	policy, err := s.getTestablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.OPA.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := s.evaluator.Evaluate(ctx, policy, object)
+	if err != nil {
+		return nil, fmt.Errorf("policy evaluation failed: %v", err)
+	}
+	duration := time.Since(start)
+
+	patch, patched, err := policyPatch(result, object)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MutationResult{
+		PolicyID: policy.ID,
+		Patch:    patch,
+		Patched:  patched,
+		Duration: duration.Milliseconds(),
+	}, nil
+}
+
+// MutateAdmission answers an AdmissionReview of a MutatingAdmissionWebhook with the
+// JSONPatch produced by the patch rules of an organization's active Rego policies.
+// Policies run in order, each on the object as patched by the previous ones, so the
+// returned patch is their operations in sequence. A policy that fails to evaluate or
+// produces an invalid patch rejects the request. Deny rules are left to the
+// validating webhook, which records the decisions of the request; only the patches
+// applied here are recorded, under the mutation source. Dry runs are not recorded.
+func (s *PolicyService) MutateAdmission(orgRef string, review *AdmissionReview) (*AdmissionReview, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	if err := validateAdmissionReview(review); err != nil {
+		return nil, err
+	}
+
+	org, err := findOrganization(s.db, orgRef)
+	if err != nil {
+		return nil, err
+	}
+
+	request := review.Request
+	response := &AdmissionResponse{UID: request.UID, Allowed: true}
+	if request.Object == nil {
+		return newAdmissionReview(response), nil
+	}
+
+	policies, err := s.admissionPolicies(org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	object := request.Object
+	var patch []PatchOperation
+	var evaluations []*models.PolicyEvaluation
+	for i := range policies {
+		policy := &policies[i]
+		evaluation, result, err := s.evaluatePolicy(policy, nil, nil, object, 0)
+		var operations []PatchOperation
+		if err == nil {
+			operations, object, err = policyPatch(result, object)
+		}
+		if err != nil {
+			response.Allowed = false
+			response.Status = &AdmissionStatus{
+				Code:    http.StatusForbidden,
+				Reason:  "Forbidden",
+				Message: fmt.Sprintf("[%s] %v", policy.Name, err),
+			}
+			return newAdmissionReview(response), nil
+		}
+		patch = append(patch, operations...)
+		if len(operations) == 0 {
+			continue
+		}
+
+		output, err := json.Marshal(map[string]interface{}{"patch": operations})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal patch: %v", err)
+		}
+		evaluation.Output = string(output)
+		evaluation.Decision = models.DecisionAllow
+		evaluation.ExceptionID = nil
+		evaluation.UserID = nil
+		evaluation.Source = models.EvaluationSourceMutation
+		evaluation.DecisionID = request.UID
+		evaluations = append(evaluations, evaluation)
+	}
+
+	if !isDryRun(request) {
+		if err := s.recordEvaluations(evaluations); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(patch) > 0 {
+		encoded, err := json.Marshal(patch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal patch: %v", err)
+		}
+		patchType := AdmissionPatchTypeJSONPatch
+		response.PatchType = &patchType
+		response.Patch = encoded
+	}
+
+	return newAdmissionReview(response), nil
+}
+
+// policyPatch reads the patch rule of an evaluated package and validates it by
+// applying it to the object. A patch may not change the identity of the object.
+func policyPatch(result *EvaluationResult, object map[string]interface{}) ([]PatchOperation, map[string]interface{}, error) {
+	operations := []PatchOperation{}
+	if doc, ok := result.Result.(map[string]interface{}); ok {
+		parsed, err := patchOperations(doc["patch"])
+		if err != nil {
+			return nil, nil, err
+		}
+		if parsed != nil {
+			operations = parsed
+		}
+	}
+
+	original := normalizeJSON(object)
+	patched, err := applyPatch(original, operations)
+	if err != nil {
+		return nil, nil, err
+	}
+	patchedObject, ok := patched.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: the patched document is not an object", ErrInvalidPatch)
+	}
+
+	for _, field := range [][]string{{"apiVersion"}, {"kind"}, {"metadata", "name"}, {"metadata", "namespace"}} {
+		if !reflect.DeepEqual(objectField(original, field), objectField(patchedObject, field)) {
+			return nil, nil, fmt.Errorf("%w: patches may not change /%s", ErrInvalidPatch, strings.Join(field, "/"))
+		}
+	}
+
+	return operations, patchedObject, nil
+}
+
+// objectField returns the value of a nested member of an object, nil when it is missing
+func objectField(value interface{}, field []string) interface{} {
+	for _, key := range field {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
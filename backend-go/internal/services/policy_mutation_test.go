@@ -0,0 +1,190 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const renamePolicy = `package kubernetes.rename
+
+patch[op] {
+    op := {"op": "replace", "path": "/metadata/name", "value": "renamed"}
+}`
+
+func unsafePod() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "api", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "api", "image": "api:1"},
+				map[string]interface{}{"name": "sidecar", "image": "proxy:1", "resources": map[string]interface{}{}},
+			},
+		},
+	}
+}
+
+func podDefaultsPolicy(t *testing.T) string {
+	template, err := NewTemplateService(nil, &config.Config{}).GetTemplate("4")
+	require.NoError(t, err)
+	return template.Content
+}
+
+func TestPolicyService_MutatePolicy(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Defaults", Content: podDefaultsPolicy(t)}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	result, err := service.MutatePolicy(policy.ID, unsafePod(), 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.Len(t, result.Patch, 2)
+	assert.Equal(t, "/spec/containers/0/resources", result.Patch[0].Path)
+	assert.Equal(t, "/spec/securityContext", result.Patch[1].Path)
+
+	patched, _ := json.Marshal(result.Patched)
+	assert.JSONEq(t, `{
+		"apiVersion": "v1",
+		"kind": "Pod",
+		"metadata": {"name": "api", "namespace": "default"},
+		"spec": {
+			"securityContext": {"runAsNonRoot": true},
+			"containers": [
+				{"name": "api", "image": "api:1", "resources": {"limits": {"cpu": "500m", "memory": "512Mi"}, "requests": {"cpu": "250m", "memory": "256Mi"}}},
+				{"name": "sidecar", "image": "proxy:1", "resources": {}}
+			]
+		}
+	}`, string(patched))
+
+	// Objects that need no changes get an empty patch
+	result, err = service.MutatePolicy(policy.ID, result.Patched, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Empty(t, result.Patch)
+
+	// Patches are validated before they are returned
+	invalid := &models.Policy{Name: "Broken", Content: "package broken\n\npatch[op] {\n    op := {\"op\": \"remove\", \"path\": \"/spec/hostNetwork\"}\n}"}
+	require.NoError(t, service.CreatePolicy(invalid, 1, 1))
+	_, err = service.MutatePolicy(invalid.ID, unsafePod(), 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+
+	rename := &models.Policy{Name: "Rename", Content: renamePolicy}
+	require.NoError(t, service.CreatePolicy(rename, 1, 1))
+	_, err = service.MutatePolicy(rename.ID, unsafePod(), 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPatch, "patches may not rename the object")
+}
+
+func TestPolicyService_MutateAdmission(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	org := createTestOrganization(t, db, "acme")
+
+	policies := []models.Policy{
+		{Name: "Pod Defaults", Content: podDefaultsPolicy(t), Status: models.StatusActive, OrganizationID: org.ID},
+		// Sees the security context added by the previous policy
+		{Name: "Read-only Root", Content: `package kubernetes.readonly
+
+patch[op] {
+    input.spec.securityContext.runAsNonRoot
+    op := {"op": "add", "path": "/spec/securityContext/fsGroup", "value": 2000}
+}`, Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "Pod Security", Content: podSecurityPolicy, Status: models.StatusActive, OrganizationID: org.ID},
+	}
+	for i := range policies {
+		require.NoError(t, db.DB.Create(&policies[i]).Error)
+	}
+
+	review := &AdmissionReview{
+		APIVersion: AdmissionAPIVersion,
+		Kind:       AdmissionKind,
+		Request:    &AdmissionRequest{UID: "b7e1c2d3", Operation: "CREATE", Object: unsafePod()},
+	}
+	result, err := service.MutateAdmission("acme", review)
+	require.NoError(t, err)
+	response := result.Response
+	assert.True(t, response.Allowed, "deny rules are left to the validating webhook")
+	require.NotNil(t, response.PatchType)
+	assert.Equal(t, AdmissionPatchTypeJSONPatch, *response.PatchType)
+
+	var patch []PatchOperation
+	require.NoError(t, json.Unmarshal(response.Patch, &patch))
+	require.Len(t, patch, 3)
+	assert.Equal(t, "/spec/securityContext/fsGroup", patch[2].Path)
+
+	encoded, err := json.Marshal(result)
+	require.NoError(t, err)
+	assert.Contains(t, string(encoded), `"patchType":"JSONPatch"`)
+
+	// Only the policies that patched the object are recorded, with their patch
+	var evaluations []models.PolicyEvaluation
+	require.NoError(t, db.DB.Where("decision_id = ?", "b7e1c2d3").Order("policy_id").Find(&evaluations).Error)
+	require.Len(t, evaluations, 2)
+	assert.Equal(t, policies[0].ID, evaluations[0].PolicyID)
+	assert.Equal(t, models.EvaluationSourceMutation, evaluations[0].Source)
+	assert.Equal(t, models.DecisionAllow, evaluations[0].Decision)
+	assert.JSONEq(t, `{"patch": [{"op": "add", "path": "/spec/securityContext/fsGroup", "value": 2000}]}`, evaluations[1].Output)
+
+	// Objects that need no changes get no patch
+	review.Request.UID = "b7e1c2d4"
+	review.Request.Object = map[string]interface{}{"kind": "ConfigMap", "metadata": map[string]interface{}{"name": "settings"}}
+	result, err = service.MutateAdmission("acme", review)
+	require.NoError(t, err)
+	assert.True(t, result.Response.Allowed)
+	assert.Nil(t, result.Response.PatchType)
+	assert.Empty(t, result.Response.Patch)
+
+	// An invalid patch rejects the request
+	require.NoError(t, db.DB.Create(&models.Policy{Name: "Rename", Content: renamePolicy, Status: models.StatusActive, OrganizationID: org.ID}).Error)
+	result, err = service.MutateAdmission("acme", review)
+	require.NoError(t, err)
+	assert.False(t, result.Response.Allowed)
+	assert.Contains(t, result.Response.Status.Message, "[Rename] invalid patch: patches may not change /metadata/name")
+}
+
+func TestPolicyService_AdmissionWebhooks(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	org := createTestOrganization(t, db, "acme")
+
+	policies := []models.Policy{
+		{Name: "Pod Defaults", Content: podDefaultsPolicy(t), Status: models.StatusActive, OrganizationID: org.ID},
+		{Name: "Host Network", Content: hostNetworkPolicy, Status: models.StatusActive, OrganizationID: org.ID},
+	}
+	for i := range policies {
+		require.NoError(t, db.DB.Create(&policies[i]).Error)
+	}
+
+	// The API server sends the request to the mutating webhook, then the patched
+	// object to the validating webhook under the same UID
+	request := &AdmissionRequest{UID: "d4e5f6a7", Operation: "CREATE", Object: unsafePod()}
+	mutated, err := service.MutateAdmission("acme", &AdmissionReview{APIVersion: AdmissionAPIVersion, Kind: AdmissionKind, Request: request})
+	require.NoError(t, err)
+	var patch []PatchOperation
+	require.NoError(t, json.Unmarshal(mutated.Response.Patch, &patch))
+	patched, err := applyPatch(normalizeJSON(request.Object), patch)
+	require.NoError(t, err)
+
+	validated, err := service.ReviewAdmission("acme", &AdmissionReview{APIVersion: AdmissionAPIVersion, Kind: AdmissionKind, Request: &AdmissionRequest{
+		UID: request.UID, Operation: "CREATE", Object: patched.(map[string]interface{}),
+	}})
+	require.NoError(t, err)
+	assert.True(t, validated.Response.Allowed)
+
+	// Each policy's decision is recorded once, and the patch separately
+	var evaluations []models.PolicyEvaluation
+	require.NoError(t, db.DB.Where("decision_id = ?", request.UID).Order("source, policy_id").Find(&evaluations).Error)
+	require.Len(t, evaluations, 3)
+	assert.Equal(t, models.EvaluationSourceAdmission, evaluations[0].Source)
+	assert.Equal(t, policies[0].ID, evaluations[0].PolicyID)
+	assert.Equal(t, models.DecisionAllow, evaluations[0].Decision)
+	assert.Equal(t, models.EvaluationSourceAdmission, evaluations[1].Source)
+	assert.Equal(t, policies[1].ID, evaluations[1].PolicyID)
+	assert.Equal(t, models.DecisionAllow, evaluations[1].Decision)
+	assert.Equal(t, models.EvaluationSourceMutation, evaluations[2].Source)
+	assert.Equal(t, policies[0].ID, evaluations[2].PolicyID)
+	assert.Contains(t, evaluations[2].Output, `"patch"`)
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"niyama-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidReplay is returned when a replay request is malformed
+	ErrInvalidReplay = errors.New("invalid replay")
+	// ErrReplayNotFound is returned when a replay does not exist for the policy
+	ErrReplayNotFound = errors.New("replay not found")
+)
+
+// replayPageSize is the number of stored inputs a replay loads and evaluates at a time
+const replayPageSize = 500
+
+// ReplayRequest selects the stored inputs a replay re-evaluates: the latest Limit
+// of them, those evaluated between From and To, or the latest Limit in that window
+type ReplayRequest struct {
+	Content string
+	Limit   int
+	From    *time.Time
+	To      *time.Time
+}
+
+// ReplayFlipFilter narrows the flips of a replay
+type ReplayFlipFilter struct {
+	Change string
+	Limit  int
+	Offset int
+}
+
+// StartReplay queues a background job re-evaluating stored inputs of a policy
+// against draft content. Progress and results are read back with GetReplay.
+func (s *PolicyService) StartReplay(policyID uint, request ReplayRequest, userID, orgID uint, userRole models.Role) (*models.PolicyReplay, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.getTestablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Content == "" {
+		return nil, fmt.Errorf("%w: content is required", ErrInvalidReplay)
+	}
+	if request.Limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalidReplay)
+	}
+	if request.Limit == 0 && request.From == nil && request.To == nil {
+		return nil, fmt.Errorf("%w: limit or time window is required", ErrInvalidReplay)
+	}
+	if request.From != nil && request.To != nil && request.To.Before(*request.From) {
+		return nil, fmt.Errorf("%w: to is before from", ErrInvalidReplay)
+	}
+
+	// Windows are capped to the newest inputs the configuration allows
+	limit := request.Limit
+	if maxInputs := s.cfg.OPA.ReplayMaxInputs; maxInputs > 0 {
+		if limit > maxInputs {
+			return nil, fmt.Errorf("%w: limit is %d, maximum is %d", ErrInvalidReplay, limit, maxInputs)
+		}
+		if limit == 0 {
+			limit = maxInputs
+		}
+	}
+
+	// The draft is not stored, so it is evaluated as a module of its own rather than
+	// merged with the policy's module on a remote OPA server
+	candidate := *policy
+	candidate.ID = 0
+	candidate.Content = request.Content
+	if err := s.validatePolicy(&candidate); err != nil {
+		return nil, err
+	}
+
+	// Compile the draft once up front rather than on the first input of every worker
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.OPA.Timeout)
+	defer cancel()
+	if err := s.engines.Compile(ctx, &candidate); err != nil {
+		return nil, err
+	}
+
+	replay := &models.PolicyReplay{
+		PolicyID: policyID,
+		Content:  request.Content,
+		Limit:    limit,
+		From:     request.From,
+		To:       request.To,
+		Status:   models.ReplayPending,
+		UserID:   userID,
+	}
+	// Inputs evaluated while the replay runs are not part of it
+	if replay.To == nil {
+		now := time.Now()
+		replay.To = &now
+	}
+
+	var total int64
+	if err := s.replayInputs(replay).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	replay.Total = int(total)
+	if limit > 0 && replay.Total > limit {
+		replay.Total = limit
+	}
+
+	if err := s.db.DB.Create(replay).Error; err != nil {
+		return nil, err
+	}
+
+	// The job works on its own copy so the caller can serialize the replay
+	job := *replay
+	s.replays.Add(1)
+	go func() {
+		defer s.replays.Done()
+		s.runReplay(&job, &candidate)
+	}()
+
+	return replay, nil
+}
+
+// FailInterruptedReplays marks the replays that were pending or running when the
+// backend last stopped as failed, since nothing resumes them. It is called at startup.
+func (s *PolicyService) FailInterruptedReplays() error {
+	if s.db == nil {
+		return nil
+	}
+
+	result := s.db.DB.Model(&models.PolicyReplay{}).
+		Where("status IN ?", []string{models.ReplayPending, models.ReplayRunning}).
+		Updates(map[string]interface{}{
+			"status":       models.ReplayFailed,
+			"error":        "interrupted by a restart",
+			"completed_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Marked %d interrupted replays as failed", result.RowsAffected)
+	}
+	return nil
+}
+
+// Wait blocks until the background jobs of the service, replays and exports, finish
+func (s *PolicyService) Wait() {
+	s.replays.Wait()
+	s.exports.Wait()
+}
+
+// GetReplays lists the replays of a policy, newest first
+func (s *PolicyService) GetReplays(policyID, userID, orgID uint, userRole models.Role) ([]models.PolicyReplay, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	var replays []models.PolicyReplay
+	if err := s.db.DB.Where("policy_id = ?", policyID).Order("id DESC").Find(&replays).Error; err != nil {
+		return nil, err
+	}
+	return replays, nil
+}
+
+// GetReplay returns a replay of a policy with its progress
+func (s *PolicyService) GetReplay(policyID, replayID, userID, orgID uint, userRole models.Role) (*models.PolicyReplay, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+	return s.findReplay(policyID, replayID)
+}
+
+// GetReplayFlips returns the inputs whose decision the draft of a replay changes
+func (s *PolicyService) GetReplayFlips(policyID, replayID, userID, orgID uint, userRole models.Role, filter ReplayFlipFilter) ([]models.PolicyReplayFlip, int64, error) {
+	replay, err := s.GetReplay(policyID, replayID, userID, orgID, userRole)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := s.db.DB.Model(&models.PolicyReplayFlip{}).Where("replay_id = ?", replay.ID)
+	if filter.Change != "" {
+		query = query.Where("change = ?", filter.Change)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var flips []models.PolicyReplayFlip
+	if err := query.Order("evaluation_id DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&flips).Error; err != nil {
+		return nil, 0, err
+	}
+	return flips, total, nil
+}
+
+func (s *PolicyService) findReplay(policyID, replayID uint) (*models.PolicyReplay, error) {
+	var replay models.PolicyReplay
+	if err := s.db.DB.Where("policy_id = ? AND id = ?", policyID, replayID).First(&replay).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReplayNotFound
+		}
+		return nil, err
+	}
+	return &replay, nil
+}
+
+// replayInputs selects the stored evaluations in the window of a replay
+func (s *PolicyService) replayInputs(replay *models.PolicyReplay) *gorm.DB {
+	query := s.db.DB.Model(&models.PolicyEvaluation{}).Where("policy_id = ?", replay.PolicyID)
+	if replay.From != nil {
+		query = query.Where("created_at >= ?", *replay.From)
+	}
+	if replay.To != nil {
+		query = query.Where("created_at <= ?", *replay.To)
+	}
+	return query
+}
+
+// runReplay runs a replay to completion, recording its progress after every page
+func (s *PolicyService) runReplay(replay *models.PolicyReplay, candidate *models.Policy) {
+	started := time.Now()
+	replay.Status = models.ReplayRunning
+	replay.StartedAt = &started
+	s.saveReplay(replay)
+
+	replay.Status = models.ReplayCompleted
+	if err := s.replayEvaluations(replay, candidate); err != nil {
+		log.Printf("Warning: replay %d of policy %d failed: %v", replay.ID, replay.PolicyID, err)
+		replay.Status = models.ReplayFailed
+		replay.Error = err.Error()
+	}
+
+	completed := time.Now()
+	replay.CompletedAt = &completed
+	s.saveReplay(replay)
+}
+
+// replayEvaluations walks the stored inputs of a replay newest first, one page at a time
+func (s *PolicyService) replayEvaluations(replay *models.PolicyReplay, candidate *models.Policy) error {
+	exceptions := s.activeExceptions(replay.PolicyID)
+
+	var lastID uint
+	for replay.Limit == 0 || replay.Processed < replay.Limit {
+		size := replayPageSize
+		if replay.Limit > 0 && replay.Limit-replay.Processed < size {
+			size = replay.Limit - replay.Processed
+		}
+
+		query := s.replayInputs(replay)
+		if lastID != 0 {
+			query = query.Where("id < ?", lastID)
+		}
+		var page []models.PolicyEvaluation
+		if err := query.Order("id DESC").Limit(size).Find(&page).Error; err != nil {
+			return fmt.Errorf("failed to load evaluations: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		lastID = page[len(page)-1].ID
+
+		flips := s.replayPage(replay, candidate, exceptions, page)
+		if len(flips) > 0 {
+			if err := s.db.DB.CreateInBatches(flips, 100).Error; err != nil {
+				return fmt.Errorf("failed to record flips: %v", err)
+			}
+		}
+		replay.Processed += len(page)
+		s.saveReplay(replay)
+	}
+	return nil
+}
+
+// replayPage evaluates a page of stored inputs against the draft with a bounded
+// worker pool, counts the outcomes on the replay and returns the flipped decisions.
+// Active exceptions waive the draft's denies as they would in live evaluations.
+func (s *PolicyService) replayPage(replay *models.PolicyReplay, candidate *models.Policy, exceptions []models.PolicyException, page []models.PolicyEvaluation) []models.PolicyReplayFlip {
+	inputs := make([]map[string]interface{}, len(page))
+	results := make([]*EvaluationResult, len(page))
+
+	workers := s.cfg.OPA.BatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(page) {
+		workers = len(page)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := json.Unmarshal([]byte(page[i].Input), &inputs[i]); err != nil {
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), s.cfg.OPA.Timeout)
+				if result, err := s.evaluator.Evaluate(ctx, candidate, inputs[i]); err == nil {
+					if result.Decision == models.DecisionDeny && matchException(exceptions, inputs[i]) != nil {
+						waive(result)
+					}
+					results[i] = result
+				}
+				cancel()
+			}
+		}()
+	}
+
+	for i := range page {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var flips []models.PolicyReplayFlip
+	for i, evaluation := range page {
+		result := results[i]
+		switch {
+		case result == nil:
+			replay.Errors++
+		case result.Decision == evaluation.Decision:
+			replay.Unchanged++
+		default:
+			flip := models.PolicyReplayFlip{
+				ReplayID:         replay.ID,
+				EvaluationID:     evaluation.ID,
+				Change:           models.ReplayFlipDenyToAllow,
+				Input:            inputs[i],
+				Decision:         evaluation.Decision,
+				ReplayDecision:   result.Decision,
+				ReplayViolations: result.Violations,
+				EvaluatedAt:      evaluation.CreatedAt,
+			}
+			if evaluation.Decision == models.DecisionAllow {
+				flip.Change = models.ReplayFlipAllowToDeny
+				replay.AllowToDeny++
+			} else {
+				replay.DenyToAllow++
+			}
+			flips = append(flips, flip)
+		}
+	}
+	return flips
+}
+
+// saveReplay records the progress of a replay; failures only delay what callers see
+func (s *PolicyService) saveReplay(replay *models.PolicyReplay) {
+	if err := s.db.DB.Save(replay).Error; err != nil {
+		log.Printf("Warning: failed to record progress of replay %d: %v", replay.ID, err)
+	}
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hostNetworkPolicy = `package kubernetes.security
+
+deny[msg] {
+    input.kind == "Pod"
+    input.spec.hostNetwork
+    msg := "pods must not use the host network"
+}`
+
+func TestPolicyService_Replay(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded, BatchWorkers: 2, ReplayMaxInputs: 100})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	compliant := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{"securityContext": map[string]interface{}{"runAsNonRoot": true}}}
+	root := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{}}
+	hostNetwork := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{"hostNetwork": true, "securityContext": map[string]interface{}{"runAsNonRoot": true}}}
+
+	start := time.Now()
+	_, err := service.EvaluateBatch(policy.ID, []map[string]interface{}{compliant, root, hostNetwork}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	_, err = service.StartReplay(policy.ID, ReplayRequest{Content: hostNetworkPolicy}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidReplay)
+	_, err = service.StartReplay(policy.ID, ReplayRequest{Content: hostNetworkPolicy, Limit: 101}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidReplay)
+	_, err = service.StartReplay(policy.ID, ReplayRequest{Content: "package kubernetes.security\n\ndeny {", Limit: 10}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+
+	// The last two inputs: root stays denied, host network flips to deny
+	latest, err := service.StartReplay(policy.ID, ReplayRequest{Content: podSecurityHostNetworkPolicy, Limit: 2}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.ReplayPending, latest.Status)
+	assert.Equal(t, 2, latest.Total)
+	service.replays.Wait()
+
+	// The whole window: root flips to allow as well
+	window, err := service.StartReplay(policy.ID, ReplayRequest{Content: hostNetworkPolicy, From: &start}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 3, window.Total)
+
+	service.replays.Wait()
+
+	replay, err := service.GetReplay(policy.ID, latest.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.ReplayCompleted, replay.Status)
+	assert.Equal(t, 2, replay.Processed)
+	assert.Equal(t, 1, replay.Unchanged)
+	assert.Equal(t, 1, replay.AllowToDeny)
+	assert.Zero(t, replay.DenyToAllow)
+	assert.Zero(t, replay.Errors)
+	assert.NotNil(t, replay.CompletedAt)
+
+	flips, total, err := service.GetReplayFlips(policy.ID, latest.ID, 1, 1, models.RoleAdmin, ReplayFlipFilter{Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, flips, 1)
+	assert.Equal(t, models.ReplayFlipAllowToDeny, flips[0].Change)
+	assert.Equal(t, hostNetwork, flips[0].Input)
+	assert.Equal(t, []string{"pods must not use the host network"}, flips[0].ReplayViolations)
+
+	replay, err = service.GetReplay(policy.ID, window.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 3, replay.Processed)
+	assert.Equal(t, 1, replay.Unchanged)
+	assert.Equal(t, 1, replay.AllowToDeny)
+	assert.Equal(t, 1, replay.DenyToAllow)
+
+	flips, total, err = service.GetReplayFlips(policy.ID, window.ID, 1, 1, models.RoleAdmin, ReplayFlipFilter{Change: models.ReplayFlipDenyToAllow, Limit: 50})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, flips, 1)
+	assert.Equal(t, root, flips[0].Input)
+	assert.Equal(t, models.DecisionDeny, flips[0].Decision)
+	assert.Equal(t, models.DecisionAllow, flips[0].ReplayDecision)
+
+	replays, err := service.GetReplays(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.Len(t, replays, 2)
+	assert.Equal(t, window.ID, replays[0].ID)
+
+	_, err = service.GetReplay(policy.ID, window.ID+1, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrReplayNotFound)
+}
+
+func TestPolicyService_FailInterruptedReplays(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	replays := []models.PolicyReplay{
+		{PolicyID: 1, Status: models.ReplayPending},
+		{PolicyID: 1, Status: models.ReplayRunning, Processed: 50},
+		{PolicyID: 1, Status: models.ReplayCompleted},
+	}
+	require.NoError(t, db.DB.Create(&replays).Error)
+
+	require.NoError(t, service.FailInterruptedReplays())
+
+	var stored []models.PolicyReplay
+	require.NoError(t, db.DB.Order("id").Find(&stored).Error)
+	require.Len(t, stored, 3)
+	for _, replay := range stored[:2] {
+		assert.Equal(t, models.ReplayFailed, replay.Status)
+		assert.Equal(t, "interrupted by a restart", replay.Error)
+		assert.NotNil(t, replay.CompletedAt)
+	}
+	assert.Equal(t, 50, stored[1].Processed)
+	assert.Equal(t, models.ReplayCompleted, stored[2].Status)
+}
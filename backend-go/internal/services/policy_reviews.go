@@ -0,0 +1,249 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"niyama-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidTransition is returned when a status change is not allowed by the review workflow
+	ErrInvalidTransition = errors.New("invalid policy status transition")
+	// ErrReviewNotAllowed is returned when the user may not review a policy version
+	ErrReviewNotAllowed = errors.New("review not allowed")
+)
+
+// SubmitForReview moves a draft or inactive policy into review for its current version
+func (s *PolicyService) SubmitForReview(policyID uint, comment string, userID, orgID uint, userRole models.Role) (*models.Policy, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.getEditablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+	if policy.Status != models.StatusDraft && policy.Status != models.StatusInactive {
+		return nil, fmt.Errorf("%w: only draft or inactive policies can be submitted for review, policy is %s", ErrInvalidTransition, policy.Status)
+	}
+
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordReview(tx, policy, models.ReviewSubmitted, userID, comment); err != nil {
+			return err
+		}
+		return tx.Model(policy).Update("status", models.StatusInReview).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// ReviewPolicy records an approval or a request for changes on the version under
+// review. Enough approvals move the policy to approved; a request for changes
+// returns it to draft.
+func (s *PolicyService) ReviewPolicy(policyID uint, action, comment string, userID, orgID uint, userRole models.Role) (*models.Policy, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.GetPolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.canReviewPolicy(policy, userID, userRole); err != nil {
+		return nil, err
+	}
+	if policy.Status != models.StatusInReview {
+		return nil, fmt.Errorf("%w: policy is %s, not in review", ErrInvalidTransition, policy.Status)
+	}
+
+	switch action {
+	case models.ReviewApproved:
+	case models.ReviewChangesRequested:
+		if comment == "" {
+			return nil, fmt.Errorf("a comment is required when requesting changes")
+		}
+	default:
+		return nil, fmt.Errorf("review action must be %q or %q", models.ReviewApproved, models.ReviewChangesRequested)
+	}
+
+	approved := false
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := recordReview(tx, policy, action, userID, comment); err != nil {
+			return err
+		}
+
+		if action == models.ReviewChangesRequested {
+			return tx.Model(policy).Update("status", models.StatusDraft).Error
+		}
+
+		approved, err = s.versionApproved(tx, policy)
+		if err != nil || !approved {
+			return err
+		}
+		return tx.Model(policy).Update("status", models.StatusApproved).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if approved {
+		s.startExport(policy, models.SourceExportApproval, nil)
+	}
+	return policy, nil
+}
+
+// GetReviews returns the review history of a policy, oldest first
+func (s *PolicyService) GetReviews(policyID, userID, orgID uint, userRole models.Role) ([]models.PolicyReview, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	var reviews []models.PolicyReview
+	err := s.db.DB.Preload("Reviewer").Where("policy_id = ?", policyID).Order("id").Find(&reviews).Error
+	return reviews, err
+}
+
+// checkStatusTransition enforces the review workflow on a policy update.
+// Review states are only entered through SubmitForReview and ReviewPolicy, and
+// a policy only becomes active from an approved, unchanged version.
+func (s *PolicyService) checkStatusTransition(policy, updates *models.Policy, contentChanged bool) error {
+	if !s.reviewRequired() {
+		return nil
+	}
+
+	status := updates.Status
+	if status == "" || status == policy.Status {
+		status = policy.Status
+		updates.Status = ""
+	}
+
+	switch status {
+	case models.StatusInReview, models.StatusApproved:
+		if status != policy.Status {
+			return fmt.Errorf("%w: use the review endpoints to move a policy to %s", ErrInvalidTransition, status)
+		}
+		// Changing content under review discards the review
+		if contentChanged {
+			updates.Status = models.StatusDraft
+		}
+	case models.StatusActive:
+		if contentChanged {
+			return fmt.Errorf("%w: content of an active policy must be reviewed, move it to draft or inactive first", ErrInvalidTransition)
+		}
+		if policy.Status == models.StatusActive {
+			return nil
+		}
+		approved, err := s.versionApproved(s.db.DB, policy)
+		if err != nil {
+			return err
+		}
+		if !approved {
+			return fmt.Errorf("%w: version %d has not been approved", ErrInvalidTransition, currentVersion(policy))
+		}
+	}
+	return nil
+}
+
+// checkNewPolicyStatus keeps new policies out of review and active states while
+// approval is required
+func (s *PolicyService) checkNewPolicyStatus(policy *models.Policy) error {
+	if !s.reviewRequired() {
+		return nil
+	}
+
+	switch policy.Status {
+	case models.StatusInReview, models.StatusApproved, models.StatusActive:
+		return fmt.Errorf("%w: new policies must be reviewed before they become %s", ErrInvalidTransition, policy.Status)
+	}
+	return nil
+}
+
+// versionApproved reports whether the policy's current version has the required
+// number of distinct approvals since it was last submitted
+func (s *PolicyService) versionApproved(tx *gorm.DB, policy *models.Policy) (bool, error) {
+	version := currentVersion(policy)
+
+	var submission models.PolicyReview
+	err := tx.Where("policy_id = ? AND version = ? AND action = ?", policy.ID, version, models.ReviewSubmitted).
+		Order("id DESC").First(&submission).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var approvals int64
+	err = tx.Model(&models.PolicyReview{}).
+		Where("policy_id = ? AND version = ? AND action = ? AND id > ?", policy.ID, version, models.ReviewApproved, submission.ID).
+		Distinct("reviewer_id").Count(&approvals).Error
+	if err != nil {
+		return false, err
+	}
+
+	return approvals >= int64(s.requiredApprovals()), nil
+}
+
+// canReviewPolicy checks that the user may review the policy's current version
+func (s *PolicyService) canReviewPolicy(policy *models.Policy, userID uint, userRole models.Role) error {
+	// Owner, Admin and Editor can review org policies
+	if userRole != models.RoleOwner && userRole != models.RoleAdmin && userRole != models.RoleEditor {
+		return fmt.Errorf("%w: insufficient permissions to review policy", ErrReviewNotAllowed)
+	}
+
+	if s.cfg.Review.AllowSelfApproval {
+		return nil
+	}
+
+	// The author of the version under review cannot review it
+	var version models.PolicyVersion
+	err := s.db.DB.Where("policy_id = ? AND version = ?", policy.ID, currentVersion(policy)).First(&version).Error
+	authorID := policy.AuthorID
+	if err == nil {
+		authorID = version.AuthorID
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if authorID == userID {
+		return fmt.Errorf("%w: authors cannot review their own changes", ErrReviewNotAllowed)
+	}
+	return nil
+}
+
+// reviewRequired reports whether activation requires approval
+func (s *PolicyService) reviewRequired() bool {
+	return s.cfg.Review.MinApprovals > 0
+}
+
+// requiredApprovals is the number of approvals that approve a version
+func (s *PolicyService) requiredApprovals() int {
+	if s.cfg.Review.MinApprovals < 1 {
+		return 1
+	}
+	return s.cfg.Review.MinApprovals
+}
+
+// recordReview stores a review step for the policy's current version
+func recordReview(tx *gorm.DB, policy *models.Policy, action string, userID uint, comment string) error {
+	err := tx.Create(&models.PolicyReview{
+		PolicyID:   policy.ID,
+		Version:    currentVersion(policy),
+		Action:     action,
+		ReviewerID: userID,
+		Comment:    comment,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record review: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReviewPolicyService(t *testing.T, minApprovals int) (*PolicyService, *database.Database) {
+	db := &database.Database{DB: setupTestDB(t)}
+	cfg := &config.Config{
+		OPA:    config.OPAConfig{Mode: OPAModeEmbedded, Timeout: 5 * time.Second},
+		Review: config.ReviewConfig{MinApprovals: minApprovals},
+	}
+	service := NewPolicyService(db, cfg)
+	t.Cleanup(service.Wait)
+	return service, db
+}
+
+func policyStatus(t *testing.T, db *database.Database, policyID uint) models.PolicyStatus {
+	var policy models.Policy
+	require.NoError(t, db.DB.First(&policy, policyID).Error)
+	return policy.Status
+}
+
+func TestPolicyService_ReviewWorkflow(t *testing.T) {
+	service, db := newTestReviewPolicyService(t, 2)
+
+	// Author 1 creates the policy; users 2 and 3 review it
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy, AccessLevel: models.AccessOrg}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	err := service.UpdatePolicy(policy.ID, &models.Policy{Status: models.StatusActive}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTransition, "drafts cannot go active")
+
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewApproved, "", 2, 1, models.RoleEditor)
+	assert.ErrorIs(t, err, ErrInvalidTransition, "only policies in review can be reviewed")
+
+	_, err = service.SubmitForReview(policy.ID, "ready", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusInReview, policyStatus(t, db, policy.ID))
+
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewApproved, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrReviewNotAllowed, "authors cannot approve their own version")
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewApproved, "", 4, 1, models.RoleViewer)
+	assert.ErrorIs(t, err, ErrReviewNotAllowed)
+
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewChangesRequested, "", 2, 1, models.RoleEditor)
+	assert.Error(t, err, "requesting changes needs a comment")
+
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewChangesRequested, "tighten the message", 2, 1, models.RoleEditor)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusDraft, policyStatus(t, db, policy.ID))
+
+	// The revised version is authored by user 1 again and goes through a new round
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicyV2}, 1, 1, models.RoleAdmin))
+	_, err = service.SubmitForReview(policy.ID, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewApproved, "", 2, 1, models.RoleEditor)
+	require.NoError(t, err)
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewApproved, "again", 2, 1, models.RoleEditor)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusInReview, policyStatus(t, db, policy.ID), "approvals are counted per reviewer")
+
+	err = service.UpdatePolicy(policy.ID, &models.Policy{Status: models.StatusActive}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTransition, "one approval is not enough")
+
+	_, err = service.ReviewPolicy(policy.ID, models.ReviewApproved, "", 3, 1, models.RoleOwner)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusApproved, policyStatus(t, db, policy.ID))
+
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Status: models.StatusActive}, 1, 1, models.RoleAdmin))
+	assert.Equal(t, models.StatusActive, policyStatus(t, db, policy.ID))
+
+	// Active content cannot change without another review
+	err = service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicy}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	// An approved version can be reactivated after being switched off
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Status: models.StatusInactive}, 1, 1, models.RoleAdmin))
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Status: models.StatusActive}, 1, 1, models.RoleAdmin))
+
+	reviews, err := service.GetReviews(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	actions := make([]string, len(reviews))
+	for i, review := range reviews {
+		actions[i] = review.Action
+	}
+	assert.Equal(t, []string{
+		models.ReviewSubmitted, models.ReviewChangesRequested,
+		models.ReviewSubmitted, models.ReviewApproved, models.ReviewApproved, models.ReviewApproved,
+	}, actions)
+	assert.Equal(t, 2, reviews[2].Version)
+}
+
+func TestPolicyService_ReviewWorkflow_Transitions(t *testing.T) {
+	service, db := newTestReviewPolicyService(t, 1)
+
+	err := service.CreatePolicy(&models.Policy{Name: "Active", Content: podSecurityPolicy, Status: models.StatusActive}, 1, 1)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy, AccessLevel: models.AccessOrg}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	err = service.UpdatePolicy(policy.ID, &models.Policy{Status: models.StatusApproved}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	_, err = service.SubmitForReview(policy.ID, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	_, err = service.SubmitForReview(policy.ID, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	// Editing content under review returns the policy to draft
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicyV2}, 1, 1, models.RoleAdmin))
+	assert.Equal(t, models.StatusDraft, policyStatus(t, db, policy.ID))
+}
+
+func TestPolicyService_ReviewWorkflow_Disabled(t *testing.T) {
+	service, db := newTestReviewPolicyService(t, 0)
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy, AccessLevel: models.AccessOrg}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Status: models.StatusActive}, 1, 1, models.RoleAdmin))
+	assert.Equal(t, models.StatusActive, policyStatus(t, db, policy.ID))
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrInvalidPolicyQuery is returned for unknown sort fields and malformed cursors
+var ErrInvalidPolicyQuery = errors.New("invalid policy query")
+
+// Sort fields accepted by PolicyQuery
+const (
+	PolicySortCreatedAt = "created_at"
+	PolicySortUpdatedAt = "updated_at"
+	PolicySortName      = "name"
+)
+
+// Page sizes of GetPolicies
+const (
+	DefaultPolicyPageSize = 50
+	MaxPolicyPageSize     = 500
+)
+
+// PolicyQuery filters, sorts and pages the policies returned by GetPolicies
+type PolicyQuery struct {
+	Search      string   // full-text search over name, description and content
+	Tags        []string // policies must carry all tags
+	Category    string
+	Status      string
+	Language    string
+	AccessLevel string
+	AuthorID    uint
+	Sort        string // one of the PolicySort fields, default created_at
+	Desc        bool
+	Limit       int
+	Cursor      string // NextCursor of the previous page
+}
+
+// PolicyFacets counts the policies matching a query per tag and category
+type PolicyFacets struct {
+	Tags       map[string]int64 `json:"tags"`
+	Categories map[string]int64 `json:"categories"`
+}
+
+// PolicyPage is one page of the policies matching a query
+type PolicyPage struct {
+	Policies   []models.Policy `json:"policies"`
+	Total      int64           `json:"total"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	Facets     PolicyFacets    `json:"facets"`
+}
+
+// policyCursor is the position after the last policy of a page
+type policyCursor struct {
+	Value string `json:"v"`
+	ID    uint   `json:"id"`
+}
+
+// filterPolicies narrows a policy query by the filters and search of q
+func (s *PolicyService) filterPolicies(query *gorm.DB, q PolicyQuery) *gorm.DB {
+	if q.Category != "" {
+		query = query.Where("category = ?", q.Category)
+	}
+	if q.Status != "" {
+		query = query.Where("status = ?", q.Status)
+	}
+	if q.Language != "" {
+		query = query.Where("language = ?", q.Language)
+	}
+	if q.AccessLevel != "" {
+		query = query.Where("access_level = ?", q.AccessLevel)
+	}
+	if q.AuthorID != 0 {
+		query = query.Where("author_id = ?", q.AuthorID)
+	}
+
+	// Tags are stored as a JSON array; match each one as a quoted array element
+	for _, tag := range q.Tags {
+		encoded, _ := json.Marshal(tag)
+		query = query.Where(`tags LIKE ? ESCAPE '\'`, "%"+escapeLike(string(encoded))+"%")
+	}
+
+	search := strings.TrimSpace(q.Search)
+	if search == "" {
+		return query
+	}
+	if s.db.DB.Dialector.Name() == "postgres" {
+		return query.Where(database.PolicySearchVector+" @@ websearch_to_tsquery('english', ?)", search)
+	}
+
+	// Databases without text search match every word as a substring
+	for _, word := range strings.Fields(strings.ToLower(search)) {
+		pattern := "%" + escapeLike(word) + "%"
+		query = query.Where(
+			`(LOWER(name) LIKE ? ESCAPE '\' OR LOWER(description) LIKE ? ESCAPE '\' OR LOWER(content) LIKE ? ESCAPE '\')`,
+			pattern, pattern, pattern,
+		)
+	}
+	return query
+}
+
+// pagePolicies orders a policy query by the sort column and continues after the
+// cursor. One policy more than the page size is fetched to detect a next page.
+func pagePolicies(query *gorm.DB, sortColumn string, q PolicyQuery) (*gorm.DB, error) {
+	direction, comparison := "ASC", ">"
+	if q.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if q.Cursor != "" {
+		cursor, err := decodePolicyCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		value, err := cursorValue(sortColumn, cursor.Value)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where(
+			fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", sortColumn, comparison, sortColumn, comparison),
+			value, value, cursor.ID,
+		)
+	}
+
+	return query.Order(sortColumn + " " + direction).Order("id " + direction).Limit(policyPageLimit(q.Limit) + 1), nil
+}
+
+// policyPageLimit applies the default and maximum page size
+func policyPageLimit(limit int) int {
+	switch {
+	case limit <= 0:
+		return DefaultPolicyPageSize
+	case limit > MaxPolicyPageSize:
+		return MaxPolicyPageSize
+	default:
+		return limit
+	}
+}
+
+// policyFacets counts tags and categories over a set of policies
+func policyFacets(policies []models.Policy) PolicyFacets {
+	facets := PolicyFacets{Tags: map[string]int64{}, Categories: map[string]int64{}}
+	for _, policy := range policies {
+		for _, tag := range policy.Tags {
+			facets.Tags[tag]++
+		}
+		if policy.Category != "" {
+			facets.Categories[policy.Category]++
+		}
+	}
+	return facets
+}
+
+func policySortColumn(sort string) (string, error) {
+	switch sort {
+	case "", PolicySortCreatedAt:
+		return PolicySortCreatedAt, nil
+	case PolicySortUpdatedAt, PolicySortName:
+		return sort, nil
+	default:
+		return "", fmt.Errorf("%w: unknown sort field %q", ErrInvalidPolicyQuery, sort)
+	}
+}
+
+func encodePolicyCursor(sortColumn string, policy *models.Policy) string {
+	cursor := policyCursor{ID: policy.ID}
+	switch sortColumn {
+	case PolicySortName:
+		cursor.Value = policy.Name
+	case PolicySortUpdatedAt:
+		cursor.Value = policy.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = policy.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePolicyCursor(encoded string) (*policyCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPolicyQuery)
+	}
+	var cursor policyCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPolicyQuery)
+	}
+	return &cursor, nil
+}
+
+// cursorValue converts a cursor value back to the type of the sort column
+func cursorValue(sortColumn, value string) (interface{}, error) {
+	if sortColumn == PolicySortName {
+		return value, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPolicyQuery)
+	}
+	return t, nil
+}
+
+// escapeLike escapes LIKE wildcards so user input only matches literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
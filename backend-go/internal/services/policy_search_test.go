@@ -0,0 +1,122 @@
+package services
+
+import (
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createSearchPolicies(t *testing.T, service *PolicyService) []*models.Policy {
+	t.Helper()
+	db := service.db
+
+	policies := []*models.Policy{
+		{Name: "Pod security", Description: "Blocks privileged pods", Content: podSecurityPolicy, Category: "security", Tags: []string{"kubernetes", "pods"}, Status: models.StatusActive, AuthorID: 1, OrganizationID: 1},
+		{Name: "Container images", Description: "Trusted registries only", Content: containerSecurityPolicy, Category: "security", Tags: []string{"kubernetes", "images"}, Status: models.StatusDraft, AuthorID: 2, OrganizationID: 1},
+		{Name: "Network", Description: "Labelled workloads", Content: networkPolicy, Category: "networking", Tags: []string{"kubernetes"}, Status: models.StatusActive, AuthorID: 1, OrganizationID: 1},
+		{Name: "Cost limits", Description: "100% of the budget", Content: libPolicy, Category: "cost", Tags: []string{"cloud"}, Status: models.StatusActive, AuthorID: 1, OrganizationID: 1},
+		{Name: "Other org", Content: libPolicy, Category: "security", Tags: []string{"kubernetes"}, Status: models.StatusActive, AuthorID: 3, OrganizationID: 2},
+	}
+	for _, policy := range policies {
+		require.NoError(t, db.DB.Create(policy).Error)
+	}
+	return policies
+}
+
+func policyNames(policies []models.Policy) []string {
+	names := make([]string, len(policies))
+	for i, policy := range policies {
+		names[i] = policy.Name
+	}
+	return names
+}
+
+func TestPolicyService_GetPolicies_Filters(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	createSearchPolicies(t, service)
+
+	tests := []struct {
+		name     string
+		query    PolicyQuery
+		expected []string
+	}{
+		{"all", PolicyQuery{}, []string{"Pod security", "Container images", "Network", "Cost limits"}},
+		{"category", PolicyQuery{Category: "security"}, []string{"Pod security", "Container images"}},
+		{"status", PolicyQuery{Status: "draft"}, []string{"Container images"}},
+		{"author", PolicyQuery{AuthorID: 2}, []string{"Container images"}},
+		{"all tags", PolicyQuery{Tags: []string{"kubernetes", "pods"}}, []string{"Pod security"}},
+		{"tag is not a prefix match", PolicyQuery{Tags: []string{"pod"}}, []string{}},
+		{"search description", PolicyQuery{Search: "privileged"}, []string{"Pod security"}},
+		{"search content", PolicyQuery{Search: "kubernetes.network"}, []string{"Network"}},
+		{"search every word", PolicyQuery{Search: "trusted images"}, []string{"Container images"}},
+		{"search wildcards literally", PolicyQuery{Search: "100%"}, []string{"Cost limits"}},
+		{"filters combine", PolicyQuery{Category: "security", Status: "active", Search: "pod"}, []string{"Pod security"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := service.GetPolicies(1, 1, models.RoleAdmin, tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, policyNames(page.Policies))
+			assert.Equal(t, int64(len(tt.expected)), page.Total)
+			assert.Empty(t, page.NextCursor)
+		})
+	}
+}
+
+func TestPolicyService_GetPolicies_Facets(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	createSearchPolicies(t, service)
+
+	page, err := service.GetPolicies(1, 1, models.RoleAdmin, PolicyQuery{Tags: []string{"kubernetes"}, Limit: 1})
+	require.NoError(t, err)
+
+	assert.Len(t, page.Policies, 1)
+	assert.Equal(t, int64(3), page.Total)
+	assert.Equal(t, map[string]int64{"kubernetes": 3, "pods": 1, "images": 1}, page.Facets.Tags)
+	assert.Equal(t, map[string]int64{"security": 2, "networking": 1}, page.Facets.Categories)
+}
+
+func TestPolicyService_GetPolicies_Pagination(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	createSearchPolicies(t, service)
+
+	collect := func(query PolicyQuery) []string {
+		var names []string
+		for pages := 0; pages < 10; pages++ {
+			page, err := service.GetPolicies(1, 1, models.RoleAdmin, query)
+			require.NoError(t, err)
+			names = append(names, policyNames(page.Policies)...)
+			if page.NextCursor == "" {
+				return names
+			}
+			query.Cursor = page.NextCursor
+		}
+		t.Fatal("pagination did not terminate")
+		return nil
+	}
+
+	assert.Equal(t,
+		[]string{"Container images", "Cost limits", "Network", "Pod security"},
+		collect(PolicyQuery{Sort: PolicySortName, Limit: 3}))
+	assert.Equal(t,
+		[]string{"Pod security", "Network", "Cost limits", "Container images"},
+		collect(PolicyQuery{Sort: PolicySortName, Desc: true, Limit: 1}))
+	assert.Equal(t,
+		[]string{"Pod security", "Container images", "Network", "Cost limits"},
+		collect(PolicyQuery{Limit: 2}))
+}
+
+func TestPolicyService_GetPolicies_InvalidQuery(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	_, err := service.GetPolicies(1, 1, models.RoleAdmin, PolicyQuery{Sort: "content"})
+	assert.ErrorIs(t, err, ErrInvalidPolicyQuery)
+
+	_, err = service.GetPolicies(1, 1, models.RoleAdmin, PolicyQuery{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidPolicyQuery)
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"niyama-backend/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrNoShadow is returned when a policy has no shadow candidate
+var ErrNoShadow = errors.New("policy has no shadow candidate")
+
+// ShadowSample is an evaluation on which the shadow candidate disagreed
+type ShadowSample struct {
+	EvaluationID     uint        `json:"evaluation_id"`
+	Input            interface{} `json:"input"`
+	Decision         string      `json:"decision"`
+	ShadowDecision   string      `json:"shadow_decision"`
+	Violations       []string    `json:"violations"`
+	ShadowViolations []string    `json:"shadow_violations"`
+	CreatedAt        time.Time   `json:"created_at"`
+}
+
+// ShadowReport summarizes how often a shadow candidate disagreed with the policy
+type ShadowReport struct {
+	Shadow           *models.PolicyShadow `json:"shadow"`
+	Evaluations      int64                `json:"evaluations"`
+	Disagreements    int64                `json:"disagreements"`
+	DisagreementRate float64              `json:"disagreement_rate"`
+	AllowToDeny      int64                `json:"allow_to_deny"` // allowed by the policy, denied by the shadow
+	DenyToAllow      int64                `json:"deny_to_allow"` // denied by the policy, allowed by the shadow
+	Errors           int64                `json:"errors"`        // evaluations the shadow failed on
+	Samples          []ShadowSample       `json:"samples"`
+}
+
+// StartShadow evaluates candidate content alongside the policy from now on,
+// replacing any running shadow. The content is taken from version when it is set.
+func (s *PolicyService) StartShadow(policyID uint, content string, version int, message string, userID, orgID uint, userRole models.Role) (*models.PolicyShadow, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.getEditablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != 0 {
+		policyVersion, err := s.findVersion(policyID, version)
+		if err != nil {
+			return nil, err
+		}
+		content = policyVersion.Content
+	}
+	if content == "" {
+		return nil, fmt.Errorf("content or version is required")
+	}
+
+	candidate := *policy
+	candidate.Content = content
+	if err := s.validatePolicy(&candidate); err != nil {
+		return nil, err
+	}
+
+	shadow := &models.PolicyShadow{
+		PolicyID: policyID,
+		Version:  version,
+		Content:  content,
+		Message:  message,
+		AuthorID: userID,
+	}
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := stopShadows(tx, policyID); err != nil {
+			return err
+		}
+		return tx.Create(shadow).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shadow, nil
+}
+
+// GetShadow returns the running shadow candidate of a policy
+func (s *PolicyService) GetShadow(policyID, userID, orgID uint, userRole models.Role) (*models.PolicyShadow, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	shadow := s.activeShadow(policyID)
+	if shadow == nil {
+		return nil, ErrNoShadow
+	}
+	return shadow, nil
+}
+
+// StopShadow stops evaluating the shadow candidate of a policy. Its report stays available.
+func (s *PolicyService) StopShadow(policyID, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getEditablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return err
+	}
+	if s.activeShadow(policyID) == nil {
+		return ErrNoShadow
+	}
+	return stopShadows(s.db.DB, policyID)
+}
+
+// GetShadowReport summarizes the evaluations of a shadow candidate, the latest one
+// when shadowID is 0, with up to samples evaluations on which it disagreed
+func (s *PolicyService) GetShadowReport(policyID, shadowID uint, samples int, userID, orgID uint, userRole models.Role) (*ShadowReport, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	var shadow models.PolicyShadow
+	query := s.db.DB.Preload("Author").Where("policy_id = ?", policyID)
+	if shadowID != 0 {
+		query = query.Where("id = ?", shadowID)
+	}
+	if err := query.Order("id DESC").First(&shadow).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoShadow
+		}
+		return nil, err
+	}
+
+	report := &ShadowReport{Shadow: &shadow, Samples: []ShadowSample{}}
+
+	// Failed shadow evaluations have no shadow decision
+	var counts []struct {
+		Decision       string
+		ShadowDecision string
+		Count          int64
+	}
+	err := s.db.DB.Model(&models.PolicyEvaluation{}).
+		Select("decision, shadow_decision, COUNT(*) AS count").
+		Where("shadow_id = ?", shadow.ID).
+		Group("decision, shadow_decision").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range counts {
+		report.Evaluations += row.Count
+		switch {
+		case row.ShadowDecision == "":
+			report.Errors += row.Count
+		case row.Decision == row.ShadowDecision:
+		case row.Decision == models.DecisionAllow:
+			report.AllowToDeny += row.Count
+			report.Disagreements += row.Count
+		default:
+			report.DenyToAllow += row.Count
+			report.Disagreements += row.Count
+		}
+	}
+	if compared := report.Evaluations - report.Errors; compared > 0 {
+		report.DisagreementRate = float64(report.Disagreements) / float64(compared)
+	}
+
+	if samples <= 0 {
+		return report, nil
+	}
+
+	var evaluations []models.PolicyEvaluation
+	err = s.db.DB.Where("shadow_id = ? AND shadow_differs = ?", shadow.ID, true).
+		Order("id DESC").Limit(samples).Find(&evaluations).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, evaluation := range evaluations {
+		report.Samples = append(report.Samples, newShadowSample(&evaluation))
+	}
+	return report, nil
+}
+
+// activeShadow returns the running shadow candidate of a policy, or nil
+func (s *PolicyService) activeShadow(policyID uint) *models.PolicyShadow {
+	if s.db == nil {
+		return nil
+	}
+
+	var shadow models.PolicyShadow
+	err := s.db.DB.Where("policy_id = ? AND stopped_at IS NULL", policyID).Order("id DESC").First(&shadow).Error
+	if err != nil {
+		return nil
+	}
+	return &shadow
+}
+
+// activeShadows returns the running shadow candidates of several policies by policy ID
+func (s *PolicyService) activeShadows(policyIDs []uint) map[uint]*models.PolicyShadow {
+	shadows := make(map[uint]*models.PolicyShadow, len(policyIDs))
+	if s.db == nil || len(policyIDs) == 0 {
+		return shadows
+	}
+
+	var running []models.PolicyShadow
+	err := s.db.DB.Where("policy_id IN ? AND stopped_at IS NULL", policyIDs).Order("id DESC").Find(&running).Error
+	if err != nil {
+		return shadows
+	}
+	for i := range running {
+		// The newest candidate wins, as in activeShadow
+		if _, ok := shadows[running[i].PolicyID]; !ok {
+			shadows[running[i].PolicyID] = &running[i]
+		}
+	}
+	return shadows
+}
+
+// evaluateShadow evaluates the shadow candidate on the same input as the policy and
+// records its decision on the evaluation. Shadow failures never fail the evaluation.
+// exception is the exception matching the input, if any.
+func (s *PolicyService) evaluateShadow(policy *models.Policy, shadow *models.PolicyShadow, exception *models.PolicyException, input map[string]interface{}, result *EvaluationResult, evaluation *models.PolicyEvaluation) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.OPA.Timeout)
+	defer cancel()
+
+	// The candidate is not stored, so it is evaluated as a module of its own rather
+	// than in place of, or merged with, the policy's module on a remote OPA server
+	candidate := *policy
+	candidate.ID = 0
+	candidate.Content = shadow.Content
+
+	evaluation.ShadowID = &shadow.ID
+	shadowResult, err := s.evaluator.Evaluate(ctx, &candidate, input)
+	if err != nil {
+		evaluation.ShadowError = fmt.Sprintf("shadow evaluation failed: %v", err)
+		return
+	}
+
+	// Exceptions waive the candidate's denies like the policy's
+	if exception != nil && shadowResult.Decision == models.DecisionDeny {
+		waive(shadowResult)
+	}
+
+	output, err := json.Marshal(shadowResult.Result)
+	if err != nil {
+		evaluation.ShadowError = fmt.Sprintf("failed to marshal shadow output: %v", err)
+		return
+	}
+	evaluation.ShadowOutput = string(output)
+	evaluation.ShadowDecision = shadowResult.Decision
+	evaluation.ShadowDiffers = shadowResult.Decision != result.Decision
+}
+
+// stopShadows stops the running shadow candidates of a policy
+func stopShadows(tx *gorm.DB, policyID uint) error {
+	return tx.Model(&models.PolicyShadow{}).
+		Where("policy_id = ? AND stopped_at IS NULL", policyID).
+		Update("stopped_at", time.Now()).Error
+}
+
+func newShadowSample(evaluation *models.PolicyEvaluation) ShadowSample {
+	sample := ShadowSample{
+		EvaluationID:   evaluation.ID,
+		Decision:       evaluation.Decision,
+		ShadowDecision: evaluation.ShadowDecision,
+		CreatedAt:      evaluation.CreatedAt,
+	}
+
+	_ = json.Unmarshal([]byte(evaluation.Input), &sample.Input)
+
+	var output, shadowOutput interface{}
+	_ = json.Unmarshal([]byte(evaluation.Output), &output)
+	_ = json.Unmarshal([]byte(evaluation.ShadowOutput), &shadowOutput)
+	sample.Violations = newEvaluationResult(output).Violations
+	sample.ShadowViolations = newEvaluationResult(shadowOutput).Violations
+	return sample
+}
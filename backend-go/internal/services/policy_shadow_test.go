@@ -0,0 +1,138 @@
+package services
+
+import (
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const podSecurityHostNetworkPolicy = `package kubernetes.security
+
+deny[msg] {
+    input.kind == "Pod"
+    not input.spec.securityContext.runAsNonRoot
+    msg := "pods must run as non-root"
+}
+
+deny[msg] {
+    input.kind == "Pod"
+    input.spec.hostNetwork
+    msg := "pods must not use the host network"
+}`
+
+func TestPolicyService_Shadow(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded, BatchWorkers: 2})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	_, err := service.GetShadow(policy.ID, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrNoShadow)
+
+	_, err = service.StartShadow(policy.ID, "package kubernetes.security\n\ndeny {", 0, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+
+	shadow, err := service.StartShadow(policy.ID, podSecurityHostNetworkPolicy, 0, "Block host network", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	compliant := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{"securityContext": map[string]interface{}{"runAsNonRoot": true}}}
+	root := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{}}
+	hostNetwork := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{"hostNetwork": true, "securityContext": map[string]interface{}{"runAsNonRoot": true}}}
+
+	// Only the policy's decision is returned
+	evaluation, err := service.TestPolicy(policy.ID, hostNetwork, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionAllow, evaluation.Decision)
+	assert.Equal(t, models.DecisionDeny, evaluation.ShadowDecision)
+	assert.True(t, evaluation.ShadowDiffers)
+	require.NotNil(t, evaluation.ShadowID)
+	assert.Equal(t, shadow.ID, *evaluation.ShadowID)
+
+	batch, err := service.EvaluateBatch(policy.ID, []map[string]interface{}{compliant, root, hostNetwork}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, batch.Summary.Allowed)
+
+	report, err := service.GetShadowReport(policy.ID, 0, 10, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, shadow.ID, report.Shadow.ID)
+	assert.Equal(t, int64(4), report.Evaluations)
+	assert.Equal(t, int64(2), report.Disagreements)
+	assert.Equal(t, int64(2), report.AllowToDeny)
+	assert.Zero(t, report.DenyToAllow)
+	assert.Zero(t, report.Errors)
+	assert.InDelta(t, 0.5, report.DisagreementRate, 0.001)
+	require.Len(t, report.Samples, 2)
+	assert.Equal(t, hostNetwork, report.Samples[0].Input)
+	assert.Empty(t, report.Samples[0].Violations)
+	assert.Equal(t, []string{"pods must not use the host network"}, report.Samples[0].ShadowViolations)
+
+	// Promoting the candidate ends the shadow run; its report stays available
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityHostNetworkPolicy}, 1, 1, models.RoleAdmin))
+	_, err = service.GetShadow(policy.ID, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrNoShadow)
+
+	evaluation, err = service.TestPolicy(policy.ID, hostNetwork, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Nil(t, evaluation.ShadowID)
+
+	report, err = service.GetShadowReport(policy.ID, shadow.ID, 0, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), report.Evaluations)
+	assert.NotNil(t, report.Shadow.StoppedAt)
+	assert.Empty(t, report.Samples)
+
+	var evaluations int64
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Where("policy_id = ?", policy.ID).Count(&evaluations).Error)
+	assert.Equal(t, int64(5), evaluations)
+}
+
+func TestPolicyService_ShadowFromVersion(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicyV2}, 1, 1, models.RoleAdmin))
+
+	_, err := service.StartShadow(policy.ID, "", 9, "", 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	// Shadowing the previous version previews a rollback
+	shadow, err := service.StartShadow(policy.ID, "", 1, "Preview rollback", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, podSecurityPolicy, shadow.Content)
+
+	replacement, err := service.StartShadow(policy.ID, podSecurityHostNetworkPolicy, 0, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	active, err := service.GetShadow(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, replacement.ID, active.ID, "starting a shadow replaces the running one")
+
+	require.NoError(t, service.StopShadow(policy.ID, 1, 1, models.RoleAdmin))
+	assert.ErrorIs(t, service.StopShadow(policy.ID, 1, 1, models.RoleAdmin), ErrNoShadow)
+}
+
+func TestPolicyService_ShadowRemote(t *testing.T) {
+	opa := newFakeOPA(t, map[string]string{})
+	defer opa.Close()
+
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeRemote, URL: opa.URL})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy + "\n\ndefault allow = true"}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	// The candidate shares the policy's package and redefines its default rule
+	_, err := service.StartShadow(policy.ID, podSecurityHostNetworkPolicy+"\n\ndefault allow = false\n\nallow {\n    count(deny) == 0\n}", 0, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	hostNetwork := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{"hostNetwork": true, "securityContext": map[string]interface{}{"runAsNonRoot": true}}}
+	evaluation, err := service.TestPolicy(policy.ID, hostNetwork, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Empty(t, evaluation.ShadowError)
+	assert.Equal(t, models.DecisionAllow, evaluation.Decision)
+	assert.Equal(t, models.DecisionDeny, evaluation.ShadowDecision)
+	assert.NotContains(t, evaluation.Output, "host network")
+}
@@ -0,0 +1,511 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"niyama-backend/internal/models"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrPolicySourceNotFound is returned for sources outside the user's organization
+	ErrPolicySourceNotFound = errors.New("policy source not found")
+	// ErrInvalidPolicySource is returned when a source is missing its repository or has an invalid path
+	ErrInvalidPolicySource = errors.New("invalid policy source")
+	// ErrSourceSyncFailed is returned when the source repository cannot be read
+	ErrSourceSyncFailed = errors.New("policy source sync failed")
+)
+
+// policyFileMeta is the sidecar YAML of a .rego file, e.g. pods.yaml next to pods.rego
+type policyFileMeta struct {
+	Name         string                 `yaml:"name"`
+	Description  string                 `yaml:"description,omitempty"`
+	Category     string                 `yaml:"category,omitempty"`
+	Tags         []string               `yaml:"tags,omitempty"`
+	AccessLevel  models.AccessLevel     `yaml:"access_level,omitempty"`
+	RequireTests bool                   `yaml:"require_tests,omitempty"`
+	Metadata     map[string]interface{} `yaml:"metadata,omitempty"`
+}
+
+// policyFile is a .rego file read from a source repository
+type policyFile struct {
+	path    string // relative to the repository root
+	content string
+	meta    policyFileMeta
+	err     error // set when the file or its sidecar could not be read
+}
+
+// GetPolicySources returns the organization's policy sources
+func (s *PolicyService) GetPolicySources(orgID uint) ([]models.PolicySource, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var sources []models.PolicySource
+	err := s.db.DB.Where("organization_id = ?", orgID).Order("id").Find(&sources).Error
+	return sources, err
+}
+
+// GetPolicySource returns a policy source of the organization
+func (s *PolicyService) GetPolicySource(sourceID, orgID uint) (*models.PolicySource, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var source models.PolicySource
+	err := s.db.DB.Where("organization_id = ?", orgID).First(&source, sourceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPolicySourceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &source, nil
+}
+
+// CreatePolicySource points the organization at a git repository, branch and path
+func (s *PolicyService) CreatePolicySource(source *models.PolicySource, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicySources(userRole) {
+		return fmt.Errorf("insufficient permissions to manage policy sources")
+	}
+	if err := normalizePolicySource(source); err != nil {
+		return err
+	}
+	if err := s.checkRepositoryURL(source.RepositoryURL); err != nil {
+		return err
+	}
+
+	source.ID = 0
+	source.OrganizationID = orgID
+	source.LastCommit = ""
+	source.LastSyncedAt = nil
+	return s.db.DB.Create(source).Error
+}
+
+// UpdatePolicySource changes the name, repository or branch of a source and
+// replaces its path and export mode
+func (s *PolicyService) UpdatePolicySource(sourceID uint, updates *models.PolicySource, userID, orgID uint, userRole models.Role) (*models.PolicySource, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicySources(userRole) {
+		return nil, fmt.Errorf("insufficient permissions to manage policy sources")
+	}
+	source, err := s.GetPolicySource(sourceID, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if updates.Name != "" {
+		source.Name = updates.Name
+	}
+	if updates.RepositoryURL != "" {
+		source.RepositoryURL = updates.RepositoryURL
+	}
+	if updates.Branch != "" {
+		source.Branch = updates.Branch
+	}
+	source.Path = updates.Path
+	source.Export = updates.Export
+	if err := normalizePolicySource(source); err != nil {
+		return nil, err
+	}
+	if err := s.checkRepositoryURL(source.RepositoryURL); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.DB.Save(source).Error; err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// DeletePolicySource removes a source. Policies synced from it are kept and
+// stop being updated.
+func (s *PolicyService) DeletePolicySource(sourceID, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicySources(userRole) {
+		return fmt.Errorf("insufficient permissions to manage policy sources")
+	}
+	source, err := s.GetPolicySource(sourceID, orgID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Policy{}).Where("source_id = ?", source.ID).Update("source_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(source).Error
+	})
+}
+
+// GetPolicySyncs returns the sync history of a source, newest first
+func (s *PolicyService) GetPolicySyncs(sourceID, orgID uint) ([]models.PolicySync, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.GetPolicySource(sourceID, orgID); err != nil {
+		return nil, err
+	}
+
+	var syncs []models.PolicySync
+	err := s.db.DB.Where("source_id = ?", sourceID).Order("id DESC").Find(&syncs).Error
+	return syncs, err
+}
+
+// SyncPolicySource reads the .rego files under the source's path at the head of
+// its branch and creates or updates the policies synced from them. Each file
+// goes through the regular create and update checks; files that fail them are
+// reported in the sync record without stopping the others. Policies whose file
+// was removed from the repository are archived; they are not deleted so their
+// evaluation history is kept, and get their previous status back when the file
+// returns.
+func (s *PolicyService) SyncPolicySource(sourceID, userID, orgID uint, userRole models.Role) (*models.PolicySync, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if !canManagePolicySources(userRole) {
+		return nil, fmt.Errorf("insufficient permissions to manage policy sources")
+	}
+	source, err := s.GetPolicySource(sourceID, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The source may predate a change of the allowed repositories
+	if err := s.checkRepositoryURL(source.RepositoryURL); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := s.gitContext()
+	defer cancel()
+
+	commit, files, err := readPolicySource(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSourceSyncFailed, err)
+	}
+
+	var existing []models.Policy
+	if err := s.db.DB.Where("source_id = ?", source.ID).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*models.Policy, len(existing))
+	for i := range existing {
+		byPath[existing[i].SourcePath] = &existing[i]
+	}
+
+	sync := &models.PolicySync{SourceID: source.ID, Commit: commit, Errors: map[string]string{}, UserID: userID}
+	message := fmt.Sprintf("Synced from %s@%s", source.Branch, shortCommit(commit))
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		seen[file.path] = true
+		if file.err != nil {
+			sync.Failed++
+			sync.Errors[file.path] = file.err.Error()
+			continue
+		}
+
+		synced := syncedPolicy(file, source, commit, message)
+		current, ok := byPath[file.path]
+		restoring := ok && current.Status == models.StatusArchived && current.ArchivedFrom != ""
+		if restoring {
+			synced.Status = restoredStatus(current.ArchivedFrom)
+		}
+		var err error
+		switch {
+		case !ok:
+			err = s.CreatePolicy(synced, userID, orgID)
+			if err == nil {
+				sync.Created++
+			}
+		case !restoring && policyMatchesFile(current, synced):
+			sync.Unchanged++
+		default:
+			err = s.UpdatePolicy(current.ID, synced, userID, orgID, userRole, syncedColumns...)
+			if err == nil && restoring {
+				sync.Restored++
+			} else if err == nil {
+				sync.Updated++
+			}
+		}
+		if err != nil {
+			sync.Failed++
+			sync.Errors[file.path] = err.Error()
+		}
+	}
+
+	removed := fmt.Sprintf("Removed from %s@%s", source.Branch, shortCommit(commit))
+	for _, policy := range existing {
+		if seen[policy.SourcePath] || policy.Status == models.StatusArchived {
+			continue
+		}
+		archived := &models.Policy{Status: models.StatusArchived, ArchivedFrom: policy.Status, ChangeMessage: removed}
+		if err := s.UpdatePolicy(policy.ID, archived, userID, orgID, userRole); err != nil {
+			sync.Failed++
+			sync.Errors[policy.SourcePath] = err.Error()
+			continue
+		}
+		sync.Archived++
+	}
+
+	now := time.Now()
+	err = s.db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sync).Error; err != nil {
+			return err
+		}
+		return tx.Model(source).Updates(map[string]interface{}{"last_commit": commit, "last_synced_at": now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sync, nil
+}
+
+// gitContext bounds a repository operation by the configured git timeout
+func (s *PolicyService) gitContext() (context.Context, context.CancelFunc) {
+	if s.cfg.Git.Timeout > 0 {
+		return context.WithTimeout(context.Background(), s.cfg.Git.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// readPolicySource clones the head of the source's branch into memory and reads
+// the .rego files below its path with their sidecar metadata. Rego unit test
+// files (*_test.rego) are not policies and are skipped.
+func readPolicySource(ctx context.Context, source *models.PolicySource) (string, []policyFile, error) {
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{
+		URL:           source.RepositoryURL,
+		ReferenceName: plumbing.NewBranchReferenceName(source.Branch),
+		SingleBranch:  true,
+		Depth:         1,
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", nil, err
+	}
+	if source.Path != "" {
+		if tree, err = tree.Tree(source.Path); err != nil {
+			return "", nil, fmt.Errorf("path %q: %v", source.Path, err)
+		}
+	}
+
+	contents := map[string]string{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		ext := path.Ext(f.Name)
+		if ext != ".rego" && ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		contents[f.Name] = content
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var files []policyFile
+	for _, name := range sortedKeys(contents) {
+		if path.Ext(name) != ".rego" || strings.HasSuffix(name, "_test.rego") {
+			continue
+		}
+
+		file := policyFile{path: path.Join(source.Path, name), content: contents[name]}
+		stem := strings.TrimSuffix(name, ".rego")
+		for _, sidecar := range []string{stem + ".yaml", stem + ".yml"} {
+			data, ok := contents[sidecar]
+			if !ok {
+				continue
+			}
+			if err := yaml.Unmarshal([]byte(data), &file.meta); err != nil {
+				file.err = fmt.Errorf("invalid metadata in %s: %v", path.Join(source.Path, sidecar), err)
+			}
+			break
+		}
+		if file.meta.Name == "" {
+			file.meta.Name = path.Base(stem)
+		}
+		files = append(files, file)
+	}
+
+	return head.Hash().String(), files, nil
+}
+
+// syncedColumns are the fields of a synced policy written even when the file
+// clears them
+var syncedColumns = []string{"description", "category", "require_tests"}
+
+// restoredStatus is the status a policy archived for its removed file returns to.
+// Review states are only entered through the review endpoints, so those return to draft.
+func restoredStatus(status models.PolicyStatus) models.PolicyStatus {
+	switch status {
+	case models.StatusInReview, models.StatusApproved:
+		return models.StatusDraft
+	}
+	return status
+}
+
+// syncedPolicy builds the policy a source file describes
+func syncedPolicy(file policyFile, source *models.PolicySource, commit, message string) *models.Policy {
+	sourceID := source.ID
+	tags := file.meta.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return &models.Policy{
+		Name:          file.meta.Name,
+		Description:   file.meta.Description,
+		Content:       file.content,
+		Language:      "rego",
+		Category:      file.meta.Category,
+		AccessLevel:   file.meta.AccessLevel,
+		Tags:          tags,
+		Metadata:      file.meta.Metadata,
+		RequireTests:  file.meta.RequireTests,
+		SourceID:      &sourceID,
+		SourcePath:    file.path,
+		SourceCommit:  commit,
+		ChangeMessage: message,
+	}
+}
+
+// policyMatchesFile reports whether a synced policy already has the file's
+// content and metadata
+func policyMatchesFile(policy, synced *models.Policy) bool {
+	if synced.AccessLevel != "" && synced.AccessLevel != policy.AccessLevel {
+		return false
+	}
+	if len(synced.Metadata) > 0 && !reflect.DeepEqual(synced.Metadata, policy.Metadata) {
+		return false
+	}
+	tags := append([]string{}, policy.Tags...)
+	sort.Strings(tags)
+	syncedTags := append([]string{}, synced.Tags...)
+	sort.Strings(syncedTags)
+
+	return policy.Content == synced.Content &&
+		policy.Name == synced.Name &&
+		policy.Description == synced.Description &&
+		policy.Category == synced.Category &&
+		policy.RequireTests == synced.RequireTests &&
+		reflect.DeepEqual(tags, syncedTags)
+}
+
+// normalizePolicySource checks the required fields of a source and cleans its path
+func normalizePolicySource(source *models.PolicySource) error {
+	if source.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidPolicySource)
+	}
+	if source.RepositoryURL == "" {
+		return fmt.Errorf("%w: repository_url is required", ErrInvalidPolicySource)
+	}
+	if source.Branch == "" {
+		source.Branch = "main"
+	}
+	switch source.Export {
+	case models.SourceExportNone, models.SourceExportUpdate, models.SourceExportApproval:
+	default:
+		return fmt.Errorf("%w: export must be %q or %q", ErrInvalidPolicySource, models.SourceExportUpdate, models.SourceExportApproval)
+	}
+
+	if strings.Contains(source.Path, "..") {
+		return fmt.Errorf("%w: path must stay within the repository", ErrInvalidPolicySource)
+	}
+	source.Path = strings.Trim(path.Clean("/"+source.Path), "/")
+	return nil
+}
+
+// checkRepositoryURL accepts https and ssh repositories outside the server's network,
+// and local paths or private addresses only when the git configuration allows them
+func (s *PolicyService) checkRepositoryURL(repositoryURL string) error {
+	endpoint, err := transport.NewEndpoint(repositoryURL)
+	if err != nil {
+		return fmt.Errorf("%w: repository_url: %v", ErrInvalidPolicySource, err)
+	}
+	switch endpoint.Protocol {
+	case "https", "ssh":
+		if s.cfg.Git.AllowPrivate {
+			return nil
+		}
+		return s.checkRepositoryHost(endpoint.Host)
+	case "file":
+		if s.cfg.Git.AllowLocalRepositories {
+			return nil
+		}
+		return fmt.Errorf("%w: local repositories are not allowed", ErrInvalidPolicySource)
+	default:
+		return fmt.Errorf("%w: repository_url must use https or ssh", ErrInvalidPolicySource)
+	}
+}
+
+// checkRepositoryHost rejects hosts that resolve to an address inside the server's
+// network, the way data sources are restricted
+func (s *PolicyService) checkRepositoryHost(host string) error {
+	ctx, cancel := s.gitContext()
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("%w: repository_url: %v", ErrInvalidPolicySource, err)
+	}
+	for _, addr := range addrs {
+		if err := checkPublicIP(addr.IP); err != nil {
+			return fmt.Errorf("%w: repository_url: %v", ErrInvalidPolicySource, err)
+		}
+	}
+	return nil
+}
+
+func canManagePolicySources(userRole models.Role) bool {
+	// Only Owner and Admin can manage policy sources
+	return userRole == models.RoleOwner || userRole == models.RoleAdmin
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		return commit[:7]
+	}
+	return commit
+}
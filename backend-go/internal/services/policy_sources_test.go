@@ -0,0 +1,263 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testGitRemote is a local bare repository with a working clone that pushes to it
+type testGitRemote struct {
+	t    *testing.T
+	url  string
+	work string
+	repo *git.Repository
+}
+
+func newTestGitRemote(t *testing.T) *testGitRemote {
+	t.Helper()
+
+	bare := t.TempDir()
+	remote, err := git.PlainInit(bare, true)
+	require.NoError(t, err)
+	require.NoError(t, remote.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+
+	work := t.TempDir()
+	repo, err := git.PlainInit(work, false)
+	require.NoError(t, err)
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{bare}})
+	require.NoError(t, err)
+
+	return &testGitRemote{t: t, url: bare, work: work, repo: repo}
+}
+
+// commit writes files, commits them on main and pushes; it returns the commit SHA
+func (r *testGitRemote) commit(files map[string]string, message string) string {
+	r.t.Helper()
+
+	worktree, err := r.repo.Worktree()
+	require.NoError(r.t, err)
+	for name, content := range files {
+		full := filepath.Join(r.work, name)
+		require.NoError(r.t, os.MkdirAll(filepath.Dir(full), 0o755))
+		require.NoError(r.t, os.WriteFile(full, []byte(content), 0o644))
+		_, err := worktree.Add(name)
+		require.NoError(r.t, err)
+	}
+
+	hash, err := worktree.Commit(message, &git.CommitOptions{
+		Author: &object.Signature{Name: "Policy Author", Email: "author@example.com", When: time.Now()},
+	})
+	require.NoError(r.t, err)
+	require.NoError(r.t, r.repo.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []gitconfig.RefSpec{"refs/heads/main:refs/heads/main"},
+	}))
+	return hash.String()
+}
+
+const podSecurityMetadata = `name: Pod Security
+description: Blocks privileged pods
+category: security
+tags: [kubernetes, pods]
+access_level: org
+`
+
+func TestPolicyService_SyncPolicySource(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	remote := newTestGitRemote(t)
+
+	first := remote.commit(map[string]string{
+		"README.md":                        "# Policies",
+		"outside.rego":                     libPolicy,
+		"policies/pods.rego":               podSecurityPolicy,
+		"policies/pods.yaml":               podSecurityMetadata,
+		"policies/pods_test.rego":          "package kubernetes.security_test\n\ntest_allow { true }",
+		"policies/lib/kubernetes.rego":     libPolicy,
+		"policies/lib/kubernetes.yml.orig": "ignored",
+	}, "Add policies")
+
+	source := &models.PolicySource{Name: "Platform policies", RepositoryURL: remote.url, Path: "/policies/"}
+	require.NoError(t, service.CreatePolicySource(source, 1, 1, models.RoleAdmin))
+	assert.Equal(t, "main", source.Branch)
+	assert.Equal(t, "policies", source.Path)
+
+	sync, err := service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, first, sync.Commit)
+	assert.Equal(t, 2, sync.Created)
+	assert.Empty(t, sync.Errors)
+
+	var pods models.Policy
+	require.NoError(t, db.DB.Where("source_path = ?", "policies/pods.rego").First(&pods).Error)
+	assert.Equal(t, "Pod Security", pods.Name)
+	assert.Equal(t, "Blocks privileged pods", pods.Description)
+	assert.Equal(t, "security", pods.Category)
+	assert.Equal(t, []string{"kubernetes", "pods"}, pods.Tags)
+	assert.Equal(t, models.AccessOrg, pods.AccessLevel)
+	assert.Equal(t, models.StatusDraft, pods.Status)
+	assert.Equal(t, podSecurityPolicy, pods.Content)
+	assert.Equal(t, first, pods.SourceCommit)
+	require.NotNil(t, pods.SourceID)
+	assert.Equal(t, source.ID, *pods.SourceID)
+
+	var lib models.Policy
+	require.NoError(t, db.DB.Where("source_path = ?", "policies/lib/kubernetes.rego").First(&lib).Error)
+	assert.Equal(t, "kubernetes", lib.Name)
+
+	versions, err := service.GetVersions(pods.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	assert.Equal(t, "Synced from main@"+first[:7], versions[0].Message)
+
+	// A second sync of the same commit changes nothing
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sync.Unchanged)
+	assert.Zero(t, sync.Created+sync.Updated+sync.Failed)
+
+	second := remote.commit(map[string]string{
+		"policies/pods.rego":   podSecurityPolicyV2,
+		"policies/broken.rego": "package broken\n\nallow {",
+	}, "Update pod security")
+
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, second, sync.Commit)
+	assert.Equal(t, 1, sync.Updated)
+	assert.Equal(t, 1, sync.Unchanged)
+	assert.Equal(t, 1, sync.Failed)
+	assert.Contains(t, sync.Errors["policies/broken.rego"], ErrInvalidPolicy.Error())
+
+	require.NoError(t, db.DB.First(&pods, pods.ID).Error)
+	assert.Equal(t, podSecurityPolicyV2, pods.Content)
+	assert.Equal(t, 2, pods.Version)
+	assert.Equal(t, second, pods.SourceCommit)
+
+	source, err = service.GetPolicySource(source.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, second, source.LastCommit)
+	assert.NotNil(t, source.LastSyncedAt)
+
+	syncs, err := service.GetPolicySyncs(source.ID, 1)
+	require.NoError(t, err)
+	require.Len(t, syncs, 3)
+	assert.Equal(t, second, syncs[0].Commit)
+
+	// Clearing a field in the sidecar clears it on the policy
+	remote.commit(map[string]string{
+		"policies/pods.yaml": "name: Pod Security\ncategory: security\ntags: [kubernetes, pods]\naccess_level: org\n",
+	}, "Drop the description")
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sync.Updated)
+	require.NoError(t, db.DB.First(&pods, pods.ID).Error)
+	assert.Empty(t, pods.Description)
+
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sync.Unchanged)
+	assert.Zero(t, sync.Updated)
+
+	// Removing a file archives its policy
+	require.NoError(t, service.UpdatePolicy(lib.ID, &models.Policy{Status: models.StatusInactive}, 1, 1, models.RoleAdmin))
+	worktree, err := remote.repo.Worktree()
+	require.NoError(t, err)
+	_, err = worktree.Remove("policies/lib/kubernetes.rego")
+	require.NoError(t, err)
+	remote.commit(map[string]string{}, "Remove the library")
+
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sync.Archived)
+	require.NoError(t, db.DB.First(&lib, lib.ID).Error)
+	assert.Equal(t, models.StatusArchived, lib.Status)
+	assert.Equal(t, models.StatusInactive, lib.ArchivedFrom)
+
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Zero(t, sync.Archived, "archived policies are not archived again")
+
+	// Restoring the file restores the policy's previous status
+	remote.commit(map[string]string{"policies/lib/kubernetes.rego": libPolicy}, "Restore the library")
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sync.Restored)
+	assert.NotContains(t, sync.Errors, "policies/lib/kubernetes.rego")
+	require.NoError(t, db.DB.First(&lib, lib.ID).Error)
+	assert.Equal(t, models.StatusInactive, lib.Status)
+	assert.Empty(t, lib.ArchivedFrom)
+
+	sync, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sync.Unchanged)
+}
+
+func TestPolicyService_SyncPolicySource_Errors(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	remote := newTestGitRemote(t)
+	remote.commit(map[string]string{"pods.rego": podSecurityPolicy}, "Add policies")
+
+	err := service.CreatePolicySource(&models.PolicySource{Name: "Escaping", RepositoryURL: remote.url, Path: "../etc"}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPolicySource)
+
+	err = service.CreatePolicySource(&models.PolicySource{Name: "Viewer", RepositoryURL: remote.url}, 1, 1, models.RoleViewer)
+	assert.Error(t, err)
+
+	missing := &models.PolicySource{Name: "Missing branch", RepositoryURL: remote.url, Branch: "release"}
+	require.NoError(t, service.CreatePolicySource(missing, 1, 1, models.RoleAdmin))
+	_, err = service.SyncPolicySource(missing.ID, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrSourceSyncFailed)
+
+	_, err = service.SyncPolicySource(missing.ID, 1, 2, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrPolicySourceNotFound)
+}
+
+func TestPolicyService_CheckRepositoryURL(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+	service.cfg.Git.AllowLocalRepositories = false
+
+	for _, url := range []string{"https://203.0.113.10/acme/policies.git", "ssh://git@203.0.113.10/acme/policies.git", "git@203.0.113.10:acme/policies.git"} {
+		assert.NoError(t, service.checkRepositoryURL(url), url)
+	}
+	for _, url := range []string{"/srv/policies", "file:///srv/policies", "http://203.0.113.10/acme/policies.git", "git://203.0.113.10/acme/policies.git"} {
+		assert.ErrorIs(t, service.checkRepositoryURL(url), ErrInvalidPolicySource, url)
+	}
+
+	// Repositories inside the server's network need GIT_ALLOW_PRIVATE_NETWORKS
+	private := []string{"https://localhost/acme/policies.git", "https://10.0.0.5/acme/policies.git", "ssh://git@[::1]/acme/policies.git", "git@169.254.169.254:acme/policies.git"}
+	for _, url := range private {
+		assert.ErrorIs(t, service.checkRepositoryURL(url), ErrInvalidPolicySource, url)
+	}
+	service.cfg.Git.AllowPrivate = true
+	for _, url := range private {
+		assert.NoError(t, service.checkRepositoryURL(url), url)
+	}
+	service.cfg.Git.AllowPrivate = false
+
+	remote := newTestGitRemote(t)
+	remote.commit(map[string]string{"pods.rego": podSecurityPolicy}, "Add policies")
+	err := service.CreatePolicySource(&models.PolicySource{Name: "Local", RepositoryURL: remote.url}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPolicySource)
+
+	service.cfg.Git.AllowLocalRepositories = true
+	source := &models.PolicySource{Name: "Local", RepositoryURL: remote.url}
+	require.NoError(t, service.CreatePolicySource(source, 1, 1, models.RoleAdmin))
+
+	// Sources saved while local repositories were allowed stop syncing
+	service.cfg.Git.AllowLocalRepositories = false
+	_, err = service.SyncPolicySource(source.ID, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPolicySource)
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/database"
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const podSecurityPolicy = `package kubernetes.security
+
+deny[msg] {
+    input.kind == "Pod"
+    not input.spec.securityContext.runAsNonRoot
+    msg := "pods must run as non-root"
+}`
+
+// newFakeOPA returns a stand-in OPA server backed by the embedded engine. Like OPA,
+// it compiles every uploaded module together, so modules declaring the same package
+// are merged, and it keeps the uploaded data. modules records the modules by ID.
+func newFakeOPA(t *testing.T, modules map[string]string) *httptest.Server {
+	var mu sync.Mutex
+	data := map[string]interface{}{}
+	fail := func(w http.ResponseWriter, status int, err error) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": "invalid_parameter", "message": err.Error()})
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if id, ok := strings.CutPrefix(r.URL.Path, "/v1/policies/"); ok {
+			switch r.Method {
+			case http.MethodPut:
+				body, _ := io.ReadAll(r.Body)
+				compiled := map[string]string{id: string(body)}
+				for other, content := range modules {
+					if other != id {
+						compiled[other] = content
+					}
+				}
+				if _, err := ast.CompileModules(compiled); err != nil {
+					fail(w, http.StatusBadRequest, err)
+					return
+				}
+				modules[id] = string(body)
+			case http.MethodDelete:
+				if _, ok := modules[id]; !ok {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				delete(modules, id)
+			}
+			w.Write([]byte(`{}`))
+			return
+		}
+
+		path, ok := strings.CutPrefix(r.URL.Path, "/v1/data/")
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		segments := strings.Split(path, "/")
+		switch r.Method {
+		case http.MethodPut, http.MethodDelete:
+			node := data
+			for _, segment := range segments[:len(segments)-1] {
+				child, ok := node[segment].(map[string]interface{})
+				if !ok {
+					child = map[string]interface{}{}
+					node[segment] = child
+				}
+				node = child
+			}
+			if r.Method == http.MethodDelete {
+				delete(node, segments[len(segments)-1])
+			} else {
+				var value interface{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&value))
+				node[segments[len(segments)-1]] = value
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodPost:
+			var req struct {
+				Input map[string]interface{} `json:"input"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+			query := ast.Ref{ast.DefaultRootDocument}
+			for _, segment := range segments {
+				query = append(query, ast.StringTerm(segment))
+			}
+			options := []func(*rego.Rego){
+				rego.Query(query.String()),
+				rego.Store(inmem.NewFromObject(copyJSON(data).(map[string]interface{}))),
+				rego.Input(req.Input),
+			}
+			for id, content := range modules {
+				options = append(options, rego.Module(id, content))
+			}
+			rs, err := rego.New(options...).Eval(r.Context())
+			if err != nil {
+				fail(w, http.StatusInternalServerError, err)
+				return
+			}
+			response := map[string]interface{}{}
+			if len(rs) > 0 {
+				response["result"] = rs[0].Expressions[0].Value
+			}
+			json.NewEncoder(w).Encode(response)
+		}
+	}))
+}
+
+func newTestPolicyService(t *testing.T, opa config.OPAConfig) (*PolicyService, *database.Database) {
+	db := &database.Database{DB: setupTestDB(t)}
+	opa.Timeout = 5 * time.Second
+	// Test repositories and data sources are served locally
+	service := NewPolicyService(db, &config.Config{
+		OPA:  opa,
+		Git:  config.GitConfig{AllowLocalRepositories: true},
+		Data: config.DataConfig{SourceWorkers: 4, SourceAllowPrivate: true},
+	})
+	t.Cleanup(service.Wait)
+	return service, db
+}
+
+func TestPolicyService_TestPolicy(t *testing.T) {
+	modules := map[string]string{}
+	opa := newFakeOPA(t, modules)
+	defer opa.Close()
+
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeRemote, URL: opa.URL})
+
+	policy := &models.Policy{
+		Name:    "Pod Security",
+		Content: podSecurityPolicy,
+	}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	tests := []struct {
+		name     string
+		input    map[string]interface{}
+		decision string
+	}{
+		{
+			name: "compliant pod",
+			input: map[string]interface{}{
+				"kind": "Pod",
+				"spec": map[string]interface{}{"securityContext": map[string]interface{}{"runAsNonRoot": true}},
+			},
+			decision: models.DecisionAllow,
+		},
+		{
+			name:     "root pod",
+			input:    map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{}},
+			decision: models.DecisionDeny,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluation, err := service.TestPolicy(policy.ID, tt.input, 1, 1, models.RoleAdmin)
+			require.NoError(t, err)
+
+			assert.Equal(t, policy.ID, evaluation.PolicyID)
+			assert.Equal(t, tt.decision, evaluation.Decision)
+			assert.GreaterOrEqual(t, evaluation.Duration, int64(0))
+			assert.JSONEq(t, mustJSON(t, tt.input), evaluation.Input)
+			assert.Contains(t, evaluation.Output, "deny")
+		})
+	}
+
+	assert.Contains(t, modules["niyama/policy-1"], "package niyama.policies.p1")
+
+	// Other organizations cannot evaluate a private policy
+	_, err := service.TestPolicy(policy.ID, map[string]interface{}{}, 2, 2, models.RoleEditor)
+	assert.Error(t, err)
+}
+
+func TestOPAClient_EvaluateSharedPackage(t *testing.T) {
+	modules := map[string]string{}
+	opa := newFakeOPA(t, modules)
+	defer opa.Close()
+
+	client := NewPolicyEvaluator(config.OPAConfig{Mode: OPAModeRemote, URL: opa.URL}, nil)
+
+	// Both policies declare the same package with conflicting default rules
+	privileged := &models.Policy{ID: 1, OrganizationID: 1, Content: `package kubernetes.security
+
+default allow = true
+
+deny[msg] {
+    input.spec.privileged
+    msg := "privileged pods are not allowed"
+}`}
+	root := &models.Policy{ID: 2, OrganizationID: 2, Content: podSecurityPolicy + `
+
+default allow = false
+
+allow {
+    count(deny) == 0
+}`}
+
+	pod := map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{"privileged": true}}
+	result, err := client.Evaluate(context.Background(), privileged, pod)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"privileged pods are not allowed"}, result.Violations)
+
+	result, err = client.Evaluate(context.Background(), root, pod)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pods must run as non-root"}, result.Violations)
+
+	result, err = client.Evaluate(context.Background(), privileged, map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{}})
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionAllow, result.Decision)
+
+	// A policy that is not stored is evaluated on its own and removed afterwards
+	candidate := &models.Policy{OrganizationID: 1, Content: privileged.Content}
+	result, err = client.Evaluate(context.Background(), candidate, pod)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"privileged pods are not allowed"}, result.Violations)
+
+	ids := []string{}
+	for id := range modules {
+		ids = append(ids, id)
+	}
+	assert.ElementsMatch(t, []string{"niyama/policy-1", "niyama/policy-2"}, ids)
+}
+
+func TestIsolateModule(t *testing.T) {
+	module, err := isolateModule(`package kubernetes.security
+
+import data.kubernetes.security.helpers
+import data.registries
+
+deny[msg] {
+    data.kubernetes.security.blocked[input.image]
+    not registries.allowed[input.registry]
+    data.niyama.policies.p1.deny[msg]
+}`, ast.MustParseRef("data.niyama.policies.p7"), opaDataRoot(3))
+	require.NoError(t, err)
+	assert.Contains(t, module, "package niyama.policies.p7")
+	assert.Contains(t, module, "import data.niyama.policies.p7.helpers")
+	assert.Contains(t, module, `import data.niyama.organizations["3"].registries`)
+	assert.Contains(t, module, "data.niyama.policies.p7.blocked[input.image]")
+	assert.Contains(t, module, "not registries.allowed[input.registry]")
+	// Other policies are only reachable as the organization's documents
+	assert.Contains(t, module, `data.niyama.organizations["3"].niyama.policies.p1.deny[msg]`)
+	assert.NotContains(t, module, "kubernetes")
+}
+
+func TestPolicyService_TestPolicy_OPAError(t *testing.T) {
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code": "invalid_parameter", "message": "error(s) occurred while compiling module(s)", "errors": [{"message": "rego_parse_error: unexpected eof token"}]}`))
+	}))
+	defer opa.Close()
+
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeRemote, URL: opa.URL})
+
+	// Stored directly, CreatePolicy rejects content that does not compile
+	policy := &models.Policy{Name: "Broken", Content: "package broken\n\nallow {", OrganizationID: 1}
+	require.NoError(t, db.DB.Create(policy).Error)
+
+	_, err := service.TestPolicy(policy.ID, map[string]interface{}{}, 1, 1, models.RoleAdmin)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rego_parse_error")
+}
+
+func TestNewEvaluationResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		result     interface{}
+		decision   string
+		violations []string
+	}{
+		{"undefined", nil, models.DecisionAllow, []string{}},
+		{"allow true", map[string]interface{}{"allow": true}, models.DecisionAllow, []string{}},
+		{"allow false", map[string]interface{}{"allow": false}, models.DecisionDeny, []string{}},
+		{"deny messages", map[string]interface{}{"deny": []interface{}{"a", "b"}}, models.DecisionDeny, []string{"a", "b"}},
+		{"empty deny", map[string]interface{}{"deny": []interface{}{}, "allow": true}, models.DecisionAllow, []string{}},
+		{"violation objects", map[string]interface{}{"violation": []interface{}{map[string]interface{}{"msg": "bad"}}}, models.DecisionDeny, []string{"bad"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := newEvaluationResult(tt.result)
+			assert.Equal(t, tt.decision, result.Decision)
+			assert.Equal(t, tt.violations, result.Violations)
+		})
+	}
+}
+
+func TestPackagePath(t *testing.T) {
+	pkg, err := PackagePath("# comment\npackage policy.container_security\n\nimport rego.v1\n")
+	require.NoError(t, err)
+	assert.Equal(t, "policy.container_security", pkg)
+
+	_, err = PackagePath("allow := true")
+	assert.Error(t, err)
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestPolicyService_GetEvaluations(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	compliant := map[string]interface{}{
+		"kind": "Pod",
+		"spec": map[string]interface{}{"securityContext": map[string]interface{}{"runAsNonRoot": true}},
+	}
+	for _, input := range []map[string]interface{}{compliant, {"kind": "Pod"}, {"kind": "Pod"}} {
+		_, err := service.TestPolicy(policy.ID, input, 1, 1, models.RoleAdmin)
+		require.NoError(t, err)
+	}
+
+	// Backdate one evaluation to check time-range filtering
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Where("id = ?", 1).Update("created_at", lastWeek).Error)
+
+	evaluations, total, err := service.GetEvaluations(policy.ID, 1, 1, models.RoleAdmin, EvaluationFilter{Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, evaluations, 3)
+	require.NotNil(t, evaluations[0].UserID)
+	assert.Equal(t, uint(1), *evaluations[0].UserID)
+	assert.Equal(t, models.EvaluationSourceAPI, evaluations[0].Source)
+	assert.NotEmpty(t, evaluations[0].Input)
+
+	_, total, err = service.GetEvaluations(policy.ID, 1, 1, models.RoleAdmin, EvaluationFilter{Decision: models.DecisionDeny, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+
+	from := lastWeek.Add(-time.Hour)
+	to := lastWeek.Add(time.Hour)
+	evaluations, total, err = service.GetEvaluations(policy.ID, 1, 1, models.RoleAdmin, EvaluationFilter{From: &from, To: &to, Limit: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, evaluations, 1)
+	assert.Equal(t, models.DecisionAllow, evaluations[0].Decision)
+
+	evaluations, total, err = service.GetEvaluations(policy.ID, 1, 1, models.RoleAdmin, EvaluationFilter{Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Len(t, evaluations, 1)
+}
+
+func TestPolicyService_ValidatePolicy(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	tests := []struct {
+		name    string
+		content string
+		want    []PolicyDiagnostic
+	}{
+		{"valid v0", podSecurityPolicy, []PolicyDiagnostic{}},
+		{"valid rego.v1", containerSecurityPolicy, []PolicyDiagnostic{}},
+		{"syntax error", "package broken\n\nallow {", []PolicyDiagnostic{{Line: 3, Column: 7, Code: "rego_parse_error", Message: "unexpected eof token"}}},
+		{"missing package", "allow := true", []PolicyDiagnostic{{Line: 1, Column: 1, Code: "rego_parse_error", Message: "package expected"}}},
+		{"unsafe variable", "package x\n\nallow { y }", []PolicyDiagnostic{{Line: 3, Column: 9, Code: "rego_unsafe_var_error", Message: "var y is unsafe"}}},
+		{"type error", "package x\n\nallow { count(1, 2) }", []PolicyDiagnostic{{Line: 3, Column: 9, Code: "rego_type_error", Message: "count: invalid argument(s)"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, service.ValidatePolicy(&models.Policy{Content: tt.content}))
+		})
+	}
+
+	// Other languages are validated by their own engine
+	assert.Empty(t, service.ValidatePolicy(&models.Policy{Content: "permit(principal, action, resource);", Language: "cedar"}))
+
+	err := service.CreatePolicy(&models.Policy{Name: "Broken", Content: "package broken\n\nallow {"}, 1, 1)
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+	assert.Equal(t, 3, validationErr.Diagnostics[0].Line)
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	err = service.UpdatePolicy(policy.ID, &models.Policy{Content: "package kubernetes.security\n\ndeny[msg] {"}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+
+	var stored models.Policy
+	require.NoError(t, db.DB.First(&stored, policy.ID).Error)
+	assert.Equal(t, podSecurityPolicy, stored.Content)
+
+	// Updates that leave the content alone are not validated
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Description: "updated"}, 1, 1, models.RoleAdmin))
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"niyama-backend/internal/models"
+)
+
+var (
+	// ErrTestsFailed is returned when a policy change does not pass the policy's test cases
+	ErrTestsFailed = errors.New("policy tests failed")
+	// ErrInvalidTestCase is returned when a test case is missing required fields
+	ErrInvalidTestCase = errors.New("invalid test case")
+	// ErrNoTestCases is returned when tests are required of a policy without test cases
+	ErrNoTestCases = errors.New("policy has no test cases")
+)
+
+// TestFailureError carries the test run that rejected a policy change
+type TestFailureError struct {
+	Run *TestRun
+}
+
+func (e *TestFailureError) Error() string {
+	return fmt.Sprintf("%v: %d of %d test cases failed", ErrTestsFailed, e.Run.Failed, e.Run.Total)
+}
+
+func (e *TestFailureError) Unwrap() error {
+	return ErrTestsFailed
+}
+
+// TestOutcome is the decision and messages of one test case evaluation
+type TestOutcome struct {
+	Decision string   `json:"decision"`
+	Messages []string `json:"messages"`
+}
+
+// TestCaseResult reports whether a test case produced its expected outcome
+type TestCaseResult struct {
+	TestCaseID uint        `json:"test_case_id"`
+	Name       string      `json:"name"`
+	Passed     bool        `json:"passed"`
+	Expected   TestOutcome `json:"expected"`
+	Actual     TestOutcome `json:"actual"`
+	Diff       []string    `json:"diff,omitempty"` // "-" expected but missing, "+" produced but unexpected
+	Duration   int64       `json:"duration"`       // in milliseconds
+	Error      string      `json:"error,omitempty"`
+}
+
+// TestRun is the result of running the test cases of a policy
+type TestRun struct {
+	PolicyID uint             `json:"policy_id"`
+	Passed   bool             `json:"passed"`
+	Total    int              `json:"total"`
+	Failed   int              `json:"failed"`
+	Results  []TestCaseResult `json:"results"`
+}
+
+// GetTestCases returns the test cases attached to a policy
+func (s *PolicyService) GetTestCases(policyID, userID, orgID uint, userRole models.Role) ([]models.PolicyTestCase, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getTestablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	return s.testCases(policyID)
+}
+
+// CreateTestCase attaches a test case to a policy
+func (s *PolicyService) CreateTestCase(policyID uint, testCase *models.PolicyTestCase, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getEditablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return err
+	}
+	if err := validateTestCase(testCase); err != nil {
+		return err
+	}
+
+	testCase.ID = 0
+	testCase.PolicyID = policyID
+	return s.db.DB.Create(testCase).Error
+}
+
+// UpdateTestCase replaces the name, input and expectations of a test case
+func (s *PolicyService) UpdateTestCase(policyID, testCaseID uint, updates *models.PolicyTestCase, userID, orgID uint, userRole models.Role) (*models.PolicyTestCase, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getEditablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+	if err := validateTestCase(updates); err != nil {
+		return nil, err
+	}
+
+	var testCase models.PolicyTestCase
+	if err := s.db.DB.Where("policy_id = ?", policyID).First(&testCase, testCaseID).Error; err != nil {
+		return nil, err
+	}
+
+	testCase.Name = updates.Name
+	testCase.Description = updates.Description
+	testCase.Input = updates.Input
+	testCase.ExpectedDecision = updates.ExpectedDecision
+	testCase.ExpectedMessages = updates.ExpectedMessages
+	if err := s.db.DB.Save(&testCase).Error; err != nil {
+		return nil, err
+	}
+	return &testCase, nil
+}
+
+// DeleteTestCase removes a test case from a policy
+func (s *PolicyService) DeleteTestCase(policyID, testCaseID, userID, orgID uint, userRole models.Role) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getEditablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return err
+	}
+
+	result := s.db.DB.Where("policy_id = ?", policyID).Delete(&models.PolicyTestCase{}, testCaseID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("test case not found")
+	}
+	return nil
+}
+
+// RunTests evaluates the stored content of a policy against all of its test cases.
+// Test runs are not recorded in the evaluation history.
+func (s *PolicyService) RunTests(policyID, userID, orgID uint, userRole models.Role) (*TestRun, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.getTestablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	testCases, err := s.testCases(policyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.runTestCases(policy, testCases), nil
+}
+
+// checkPolicyTests runs the test cases of a policy against candidate content and
+// returns a TestFailureError when any of them fails. A policy without test cases
+// cannot pass, since nothing would be checked.
+func (s *PolicyService) checkPolicyTests(policy *models.Policy, content string) error {
+	testCases, err := s.testCases(policy.ID)
+	if err != nil {
+		return err
+	}
+	if len(testCases) == 0 {
+		return fmt.Errorf("%w: add test cases before requiring them", ErrNoTestCases)
+	}
+
+	// The candidate is not stored, so it is evaluated as a module of its own rather
+	// than merged with the stored content on a remote OPA server
+	candidate := *policy
+	candidate.ID = 0
+	candidate.Content = content
+
+	run := s.runTestCases(&candidate, testCases)
+	run.PolicyID = policy.ID
+	if !run.Passed {
+		return &TestFailureError{Run: run}
+	}
+	return nil
+}
+
+// testCases loads the test cases of a policy in creation order
+func (s *PolicyService) testCases(policyID uint) ([]models.PolicyTestCase, error) {
+	var testCases []models.PolicyTestCase
+	err := s.db.DB.Where("policy_id = ?", policyID).Order("id").Find(&testCases).Error
+	return testCases, err
+}
+
+// runTestCases evaluates each test case against the policy content
+func (s *PolicyService) runTestCases(policy *models.Policy, testCases []models.PolicyTestCase) *TestRun {
+	run := &TestRun{
+		PolicyID: policy.ID,
+		Passed:   true,
+		Total:    len(testCases),
+		Results:  make([]TestCaseResult, 0, len(testCases)),
+	}
+
+	for _, testCase := range testCases {
+		result := s.runTestCase(policy, testCase)
+		if !result.Passed {
+			run.Passed = false
+			run.Failed++
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	return run
+}
+
+// runTestCase evaluates one test case and compares the outcome with its expectations
+func (s *PolicyService) runTestCase(policy *models.Policy, testCase models.PolicyTestCase) TestCaseResult {
+	result := TestCaseResult{
+		TestCaseID: testCase.ID,
+		Name:       testCase.Name,
+		Expected: TestOutcome{
+			Decision: testCase.ExpectedDecision,
+			Messages: testCase.ExpectedMessages,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.OPA.Timeout)
+	defer cancel()
+
+	input := testCase.Input
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+
+	start := time.Now()
+	evaluation, err := s.evaluator.Evaluate(ctx, policy, input)
+	result.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = fmt.Sprintf("policy evaluation failed: %v", err)
+		return result
+	}
+
+	result.Actual = TestOutcome{Decision: evaluation.Decision, Messages: evaluation.Violations}
+	result.Diff = testOutcomeDiff(result.Expected, result.Actual)
+	result.Passed = len(result.Diff) == 0
+	return result
+}
+
+// testOutcomeDiff lists the differences between the expected and actual outcome.
+// Messages are compared as a set, and only when the test case expects messages.
+func testOutcomeDiff(expected, actual TestOutcome) []string {
+	var diff []string
+	if expected.Decision != actual.Decision {
+		diff = append(diff, "- decision: "+expected.Decision, "+ decision: "+actual.Decision)
+	}
+
+	if len(expected.Messages) == 0 {
+		return diff
+	}
+
+	produced := make(map[string]bool, len(actual.Messages))
+	for _, msg := range actual.Messages {
+		produced[msg] = true
+	}
+	wanted := make(map[string]bool, len(expected.Messages))
+	for _, msg := range expected.Messages {
+		wanted[msg] = true
+	}
+
+	var missing, unexpected []string
+	for msg := range wanted {
+		if !produced[msg] {
+			missing = append(missing, msg)
+		}
+	}
+	for msg := range produced {
+		if !wanted[msg] {
+			unexpected = append(unexpected, msg)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unexpected)
+
+	for _, msg := range missing {
+		diff = append(diff, "- message: "+msg)
+	}
+	for _, msg := range unexpected {
+		diff = append(diff, "+ message: "+msg)
+	}
+	return diff
+}
+
+// validateTestCase checks the required fields of a test case
+func validateTestCase(testCase *models.PolicyTestCase) error {
+	if testCase.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTestCase)
+	}
+	switch testCase.ExpectedDecision {
+	case models.DecisionAllow, models.DecisionDeny:
+		return nil
+	default:
+		return fmt.Errorf("%w: expected_decision must be %q or %q", ErrInvalidTestCase, models.DecisionAllow, models.DecisionDeny)
+	}
+}
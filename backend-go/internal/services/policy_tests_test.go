@@ -0,0 +1,169 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createPodSecurityTests(t *testing.T, service *PolicyService, policyID uint) {
+	testCases := []models.PolicyTestCase{
+		{
+			Name: "non-root pod is allowed",
+			Input: map[string]interface{}{
+				"kind": "Pod",
+				"spec": map[string]interface{}{"securityContext": map[string]interface{}{"runAsNonRoot": true}},
+			},
+			ExpectedDecision: models.DecisionAllow,
+		},
+		{
+			Name:             "root pod is denied",
+			Input:            map[string]interface{}{"kind": "Pod"},
+			ExpectedDecision: models.DecisionDeny,
+			ExpectedMessages: []string{"pods must run as non-root"},
+		},
+	}
+	for i := range testCases {
+		require.NoError(t, service.CreateTestCase(policyID, &testCases[i], 1, 1, models.RoleAdmin))
+	}
+}
+
+func TestPolicyService_RunTests(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	createPodSecurityTests(t, service, policy.ID)
+
+	run, err := service.RunTests(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.True(t, run.Passed)
+	assert.Equal(t, 2, run.Total)
+	assert.Zero(t, run.Failed)
+
+	// A test case expecting a different message reports a diff
+	wrong := &models.PolicyTestCase{
+		Name:             "wrong message",
+		Input:            map[string]interface{}{"kind": "Pod"},
+		ExpectedDecision: models.DecisionDeny,
+		ExpectedMessages: []string{"pods must not be privileged"},
+	}
+	require.NoError(t, service.CreateTestCase(policy.ID, wrong, 1, 1, models.RoleAdmin))
+
+	run, err = service.RunTests(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.False(t, run.Passed)
+	assert.Equal(t, 1, run.Failed)
+	failed := run.Results[2]
+	assert.Equal(t, wrong.ID, failed.TestCaseID)
+	assert.False(t, failed.Passed)
+	assert.Equal(t, []string{"- message: pods must not be privileged", "+ message: pods must run as non-root"}, failed.Diff)
+
+	// Test runs stay out of the evaluation history
+	var stored int64
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Count(&stored).Error)
+	assert.Zero(t, stored)
+
+	require.NoError(t, service.DeleteTestCase(policy.ID, wrong.ID, 1, 1, models.RoleAdmin))
+	testCases, err := service.GetTestCases(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Len(t, testCases, 2)
+
+	err = service.CreateTestCase(policy.ID, &models.PolicyTestCase{Name: "no decision"}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTestCase)
+}
+
+func TestPolicyService_UpdatePolicy_RequireTests(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	createPodSecurityTests(t, service, policy.ID)
+
+	broken := "package kubernetes.security\n\ndeny[msg] {\n    false\n    msg := \"never\"\n}"
+
+	// Without require_tests the edit is saved as before
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: broken}, 1, 1, models.RoleAdmin))
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicy, RequireTests: true}, 1, 1, models.RoleAdmin))
+
+	err := service.UpdatePolicy(policy.ID, &models.Policy{Content: broken}, 1, 1, models.RoleAdmin)
+	require.ErrorIs(t, err, ErrTestsFailed)
+	var testErr *TestFailureError
+	require.True(t, errors.As(err, &testErr))
+	assert.Equal(t, policy.ID, testErr.Run.PolicyID)
+	assert.Equal(t, 1, testErr.Run.Failed)
+	assert.Equal(t, []string{"- decision: deny", "+ decision: allow", "- message: pods must run as non-root"}, testErr.Run.Results[1].Diff)
+
+	var stored models.Policy
+	require.NoError(t, db.DB.First(&stored, policy.ID).Error)
+	assert.Equal(t, podSecurityPolicy, stored.Content, "rejected content is not saved")
+
+	// Passing content and activation go through
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicy + "\n", Status: models.StatusActive}, 1, 1, models.RoleAdmin))
+}
+
+func TestPolicyService_UpdatePolicy_RequireTestsWithoutTestCases(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	err := service.UpdatePolicy(policy.ID, &models.Policy{RequireTests: true}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrNoTestCases)
+
+	createPodSecurityTests(t, service, policy.ID)
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{RequireTests: true}, 1, 1, models.RoleAdmin))
+}
+
+func TestPolicyService_UpdatePolicy_DisableRequireTests(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Description: "Pods run as non-root", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	createPodSecurityTests(t, service, policy.ID)
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{RequireTests: true}, 1, 1, models.RoleAdmin))
+
+	broken := "package kubernetes.security\n\ndeny[msg] {\n    false\n    msg := \"never\"\n}"
+	err := service.UpdatePolicy(policy.ID, &models.Policy{Content: broken}, 1, 1, models.RoleAdmin)
+	require.ErrorIs(t, err, ErrTestsFailed)
+
+	// A zero value is only written for the columns named in the update
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{}, 1, 1, models.RoleAdmin))
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{}, 1, 1, models.RoleAdmin, "require_tests"))
+	var stored models.Policy
+	require.NoError(t, db.DB.First(&stored, policy.ID).Error)
+	assert.False(t, stored.RequireTests)
+	assert.Equal(t, "Pods run as non-root", stored.Description)
+
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: broken}, 1, 1, models.RoleAdmin))
+}
+
+func TestPolicyService_UpdatePolicy_RequireTestsRemote(t *testing.T) {
+	opa := newFakeOPA(t, map[string]string{})
+	defer opa.Close()
+
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeRemote, URL: opa.URL})
+
+	// The stored content denies every pod, so merging it with the candidate fails the tests
+	policy := &models.Policy{Name: "Pod Security", Content: "package kubernetes.security\n\ndeny[msg] {\n    input.kind == \"Pod\"\n    msg := \"pods are not allowed\"\n}"}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	createPodSecurityTests(t, service, policy.ID)
+	_, err := service.TestPolicy(policy.ID, map[string]interface{}{"kind": "Pod"}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicy, RequireTests: true}, 1, 1, models.RoleAdmin))
+}
+
+func TestTestOutcomeDiff(t *testing.T) {
+	expected := TestOutcome{Decision: models.DecisionDeny}
+	assert.Empty(t, testOutcomeDiff(expected, TestOutcome{Decision: models.DecisionDeny, Messages: []string{"any"}}),
+		"messages are not compared when none are expected")
+
+	expected.Messages = []string{"b", "a"}
+	assert.Empty(t, testOutcomeDiff(expected, TestOutcome{Decision: models.DecisionDeny, Messages: []string{"a", "b"}}))
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// ErrInvalidPolicy is returned when policy content does not compile
+var ErrInvalidPolicy = errors.New("policy content is invalid")
+
+// PolicyDiagnostic is a compile error located in policy content
+type PolicyDiagnostic struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Code    string `json:"code"` // e.g. rego_parse_error, rego_type_error
+	Message string `json:"message"`
+}
+
+// ValidationError carries the diagnostics that rejected policy content
+type ValidationError struct {
+	Diagnostics []PolicyDiagnostic
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Diagnostics) == 0 {
+		return ErrInvalidPolicy.Error()
+	}
+	d := e.Diagnostics[0]
+	msg := fmt.Sprintf("%v: %d:%d: %s", ErrInvalidPolicy, d.Line, d.Column, d.Message)
+	if len(e.Diagnostics) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Diagnostics)-1)
+	}
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidPolicy
+}
+
+// ValidatePolicy parses and compiles the policy content with the engine for its
+// language and returns its diagnostics. Languages without an engine are rejected.
+func (s *PolicyService) ValidatePolicy(policy *models.Policy) []PolicyDiagnostic {
+	return s.engines.Validate(policy)
+}
+
+// validatePolicy returns a ValidationError when the policy content does not compile
+func (s *PolicyService) validatePolicy(policy *models.Policy) error {
+	if diagnostics := s.ValidatePolicy(policy); len(diagnostics) > 0 {
+		return &ValidationError{Diagnostics: diagnostics}
+	}
+	return nil
+}
+
+// isRegoPolicy reports whether the policy is written in Rego, the default language
+func isRegoPolicy(policy *models.Policy) bool {
+	return normalizeLanguage(policy.Language) == models.LanguageRego
+}
+
+// regoDiagnostics compiles a Rego module on its own; references to other
+// packages and data documents are left unresolved
+func regoDiagnostics(content string) []PolicyDiagnostic {
+	diagnostics := []PolicyDiagnostic{}
+	if strings.TrimSpace(content) == "" {
+		return append(diagnostics, PolicyDiagnostic{Line: 1, Column: 1, Code: ast.ParseErr, Message: "policy content is empty"})
+	}
+
+	_, err := ast.CompileModules(map[string]string{"policy.rego": content})
+	if err == nil {
+		return diagnostics
+	}
+
+	var astErrs ast.Errors
+	if !errors.As(err, &astErrs) {
+		return append(diagnostics, PolicyDiagnostic{Line: 1, Column: 1, Code: ast.CompileErr, Message: err.Error()})
+	}
+
+	for _, e := range astErrs {
+		diagnostic := PolicyDiagnostic{Code: e.Code, Message: e.Message}
+		if e.Location != nil {
+			diagnostic.Line = e.Location.Row
+			diagnostic.Column = e.Location.Col
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
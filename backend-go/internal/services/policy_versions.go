@@ -0,0 +1,184 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"niyama-backend/internal/models"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"gorm.io/gorm"
+)
+
+// ErrVersionNotFound is returned when a policy has no version with the requested number
+var ErrVersionNotFound = errors.New("policy version not found")
+
+// VersionDiff is a unified diff between the content of two policy versions
+type VersionDiff struct {
+	PolicyID uint   `json:"policy_id"`
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+	Diff     string `json:"diff"`
+}
+
+// GetVersions returns the versions of a policy, newest first
+func (s *PolicyService) GetVersions(policyID, userID, orgID uint, userRole models.Role) ([]models.PolicyVersion, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getTestablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	var versions []models.PolicyVersion
+	err := s.db.DB.Preload("Author").Where("policy_id = ?", policyID).
+		Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+// GetVersion returns one version of a policy
+func (s *PolicyService) GetVersion(policyID uint, version int, userID, orgID uint, userRole models.Role) (*models.PolicyVersion, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if _, err := s.getTestablePolicy(policyID, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	return s.findVersion(policyID, version)
+}
+
+// DiffVersions returns a unified diff from one version of a policy to another.
+// A zero to compares against the current version.
+func (s *PolicyService) DiffVersions(policyID uint, from, to int, userID, orgID uint, userRole models.Role) (*VersionDiff, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.getTestablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+	if to == 0 {
+		to = currentVersion(policy)
+	}
+
+	fromVersion, err := s.findVersion(policyID, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := s.findVersion(policyID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(fromVersion.Content),
+		B:        difflib.SplitLines(toVersion.Content),
+		FromFile: fmt.Sprintf("version %d", from),
+		ToFile:   fmt.Sprintf("version %d", to),
+		Context:  3,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff versions: %v", err)
+	}
+
+	return &VersionDiff{PolicyID: policyID, From: from, To: to, Diff: diff}, nil
+}
+
+// RollbackPolicy restores the content of an earlier version by saving it as a new
+// version. The change goes through UpdatePolicy, so validation and required tests apply.
+func (s *PolicyService) RollbackPolicy(policyID uint, version int, message string, userID, orgID uint, userRole models.Role) (*models.Policy, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	policy, err := s.getEditablePolicy(policyID, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := s.findVersion(policyID, version)
+	if err != nil {
+		return nil, err
+	}
+	if target.Content == policy.Content {
+		return nil, fmt.Errorf("policy content already matches version %d", version)
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("Roll back to version %d", version)
+	}
+	updates := &models.Policy{
+		Content:       target.Content,
+		Language:      target.Language,
+		ChangeMessage: message,
+	}
+	if err := s.UpdatePolicy(policyID, updates, userID, orgID, userRole); err != nil {
+		return nil, err
+	}
+
+	return s.GetPolicy(policyID, userID, orgID, userRole)
+}
+
+// findVersion loads a version by its number
+func (s *PolicyService) findVersion(policyID uint, version int) (*models.PolicyVersion, error) {
+	var policyVersion models.PolicyVersion
+	err := s.db.DB.Preload("Author").Where("policy_id = ? AND version = ?", policyID, version).
+		First(&policyVersion).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: version %d", ErrVersionNotFound, version)
+		}
+		return nil, err
+	}
+	return &policyVersion, nil
+}
+
+// recordContentChange stores the version created by a content update. Policies
+// created before versioning get their previous content recorded first, so
+// their history starts from the content they had.
+func (s *PolicyService) recordContentChange(tx *gorm.DB, policy, updates *models.Policy, userID uint) error {
+	var existing int64
+	if err := tx.Model(&models.PolicyVersion{}).Where("policy_id = ?", policy.ID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing == 0 {
+		err := recordVersion(tx, policy.ID, currentVersion(policy), policy.Content, policy.Language, policy.AuthorID, "Initial version")
+		if err != nil {
+			return err
+		}
+	}
+
+	language := policy.Language
+	if updates.Language != "" {
+		language = updates.Language
+	}
+	return recordVersion(tx, policy.ID, updates.Version, updates.Content, language, userID, updates.ChangeMessage)
+}
+
+// recordVersion inserts an immutable version of a policy's content
+func recordVersion(tx *gorm.DB, policyID uint, version int, content, language string, authorID uint, message string) error {
+	err := tx.Create(&models.PolicyVersion{
+		PolicyID: policyID,
+		Version:  version,
+		Content:  content,
+		Language: language,
+		Message:  message,
+		AuthorID: authorID,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record policy version: %v", err)
+	}
+	return nil
+}
+
+// currentVersion returns the version number of a policy's stored content
+func currentVersion(policy *models.Policy) int {
+	if policy.Version < 1 {
+		return 1
+	}
+	return policy.Version
+}
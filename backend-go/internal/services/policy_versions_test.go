@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const podSecurityPolicyV2 = `package kubernetes.security
+
+deny[msg] {
+    input.kind == "Pod"
+    not input.spec.securityContext.runAsNonRoot
+    msg := "pods must set runAsNonRoot"
+}`
+
+func TestPolicyService_Versions(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+	assert.Equal(t, 1, policy.Version)
+
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicyV2, ChangeMessage: "Clarify message"}, 2, 1, models.RoleAdmin))
+
+	// Metadata-only updates do not create versions, and clients cannot set the version
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Description: "Pods", Version: 7}, 1, 1, models.RoleAdmin))
+
+	versions, err := service.GetVersions(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, 2, versions[0].Version)
+	assert.Equal(t, "Clarify message", versions[0].Message)
+	assert.Equal(t, uint(2), versions[0].AuthorID)
+	assert.Equal(t, podSecurityPolicyV2, versions[0].Content)
+	assert.Equal(t, "Initial version", versions[1].Message)
+
+	var stored models.Policy
+	require.NoError(t, db.DB.First(&stored, policy.ID).Error)
+	assert.Equal(t, 2, stored.Version)
+
+	diff, err := service.DiffVersions(policy.ID, 1, 0, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 2, diff.To)
+	assert.Equal(t, `--- version 1
++++ version 2
+@@ -3,5 +3,5 @@
+ deny[msg] {
+     input.kind == "Pod"
+     not input.spec.securityContext.runAsNonRoot
+-    msg := "pods must run as non-root"
++    msg := "pods must set runAsNonRoot"
+ }
+`, diff.Diff)
+
+	_, err = service.GetVersion(policy.ID, 9, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	rolledBack, err := service.RollbackPolicy(policy.ID, 1, "", 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rolledBack.Version)
+	assert.Equal(t, podSecurityPolicy, rolledBack.Content)
+
+	latest, err := service.GetVersion(policy.ID, 3, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, "Roll back to version 1", latest.Message)
+	assert.Equal(t, podSecurityPolicy, latest.Content)
+
+	_, err = service.RollbackPolicy(policy.ID, 3, "", 1, 1, models.RoleAdmin)
+	assert.Error(t, err, "rolling back to the current content is rejected")
+}
+
+func TestPolicyService_Versions_Unversioned(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	// Policies stored before versioning have no version records
+	policy := &models.Policy{Name: "Legacy", Content: podSecurityPolicy, OrganizationID: 1, AuthorID: 3}
+	require.NoError(t, db.DB.Create(policy).Error)
+
+	require.NoError(t, service.UpdatePolicy(policy.ID, &models.Policy{Content: podSecurityPolicyV2}, 1, 1, models.RoleAdmin))
+
+	versions, err := service.GetVersions(policy.ID, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, podSecurityPolicy, versions[1].Content)
+	assert.Equal(t, uint(3), versions[1].AuthorID)
+	assert.Equal(t, 2, versions[0].Version)
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"niyama-backend/internal/models"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+// maxPreparedQueries bounds the number of compiled policies kept in memory
+const maxPreparedQueries = 256
+
+// RegoEvaluator evaluates policies in-process with the embedded OPA engine
+type RegoEvaluator struct {
+	mu       sync.Mutex
+	prepared map[[sha256.Size]byte]rego.PreparedEvalQuery
+	data     PolicyDataLoader
+}
+
+func NewRegoEvaluator() *RegoEvaluator {
+	return &RegoEvaluator{
+		prepared: make(map[[sha256.Size]byte]rego.PreparedEvalQuery),
+	}
+}
+
+// Evaluate compiles the policy module (cached by content and organization data
+// revision) and queries its package document
+func (e *RegoEvaluator) Evaluate(ctx context.Context, policy *models.Policy, input map[string]interface{}) (*EvaluationResult, error) {
+	var data *PolicyDataSnapshot
+	if e.data != nil && policy.OrganizationID != 0 {
+		var err error
+		if data, err = e.data(policy.OrganizationID); err != nil {
+			return nil, fmt.Errorf("failed to load policy data: %v", err)
+		}
+	}
+
+	query, err := e.prepare(ctx, policy, data)
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate policy: %v", err)
+	}
+
+	// An undefined package document yields no results
+	var result interface{}
+	if len(rs) > 0 && len(rs[0].Expressions) > 0 {
+		result = rs[0].Expressions[0].Value
+	}
+
+	return newEvaluationResult(result), nil
+}
+
+// prepare returns a compiled query for the policy package, compiling it on first use.
+// With data, the query reads the documents from a store bound to the data's revision.
+func (e *RegoEvaluator) prepare(ctx context.Context, policy *models.Policy, data *PolicyDataSnapshot) (rego.PreparedEvalQuery, error) {
+	keyContent := policy.Content
+	if data != nil && len(data.Documents) > 0 {
+		keyContent += fmt.Sprintf("\x00org %d revision %d", policy.OrganizationID, data.Revision)
+	}
+	key := sha256.Sum256([]byte(keyContent))
+
+	e.mu.Lock()
+	query, ok := e.prepared[key]
+	e.mu.Unlock()
+	if ok {
+		return query, nil
+	}
+
+	pkg, err := PackagePath(policy.Content)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+
+	options := []func(*rego.Rego){
+		rego.Query("data." + pkg),
+		rego.Module(opaPolicyID(policy)+".rego", policy.Content),
+	}
+	if data != nil && len(data.Documents) > 0 {
+		options = append(options, rego.Store(inmem.NewFromObject(data.Tree())))
+	}
+	query, err = rego.New(options...).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("failed to compile policy: %v", err)
+	}
+
+	e.mu.Lock()
+	if len(e.prepared) >= maxPreparedQueries {
+		e.prepared = make(map[[sha256.Size]byte]rego.PreparedEvalQuery)
+	}
+	e.prepared[key] = query
+	e.mu.Unlock()
+
+	return query, nil
+}
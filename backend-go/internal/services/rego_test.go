@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const containerSecurityPolicy = `package policy.container_security
+
+import rego.v1
+
+deny contains msg if {
+    input.kind == "Pod"
+    some c in input.spec.containers
+    c.securityContext.runAsUser == 0
+    msg := sprintf("Container '%s' must not run as root user", [c.name])
+}`
+
+func TestRegoEvaluator_Evaluate(t *testing.T) {
+	evaluator := NewRegoEvaluator()
+	ctx := context.Background()
+
+	tests := []struct {
+		name       string
+		content    string
+		input      map[string]interface{}
+		decision   string
+		violations []string
+	}{
+		{
+			name:    "deny rule matches",
+			content: containerSecurityPolicy,
+			input: map[string]interface{}{
+				"kind": "Pod",
+				"spec": map[string]interface{}{"containers": []interface{}{
+					map[string]interface{}{"name": "app", "securityContext": map[string]interface{}{"runAsUser": 0}},
+				}},
+			},
+			decision:   models.DecisionDeny,
+			violations: []string{"Container 'app' must not run as root user"},
+		},
+		{
+			name:       "deny rule does not match",
+			content:    containerSecurityPolicy,
+			input:      map[string]interface{}{"kind": "Pod", "spec": map[string]interface{}{"containers": []interface{}{}}},
+			decision:   models.DecisionAllow,
+			violations: []string{},
+		},
+		{
+			name:       "default allow false",
+			content:    "package kubernetes.security\n\ndefault allow = false\n\nallow {\n    input.spec.securityContext.runAsNonRoot == true\n}",
+			input:      map[string]interface{}{"spec": map[string]interface{}{}},
+			decision:   models.DecisionDeny,
+			violations: []string{},
+		},
+		{
+			name:       "allow rule true",
+			content:    "package kubernetes.security\n\ndefault allow = false\n\nallow {\n    input.spec.securityContext.runAsNonRoot == true\n}",
+			input:      map[string]interface{}{"spec": map[string]interface{}{"securityContext": map[string]interface{}{"runAsNonRoot": true}}},
+			decision:   models.DecisionAllow,
+			violations: []string{},
+		},
+		{
+			name:       "violation objects",
+			content:    "package gatekeeper\n\nviolation[{\"msg\": msg}] {\n    not input.metadata.labels.owner\n    msg := \"owner label is required\"\n}",
+			input:      map[string]interface{}{"metadata": map[string]interface{}{}},
+			decision:   models.DecisionDeny,
+			violations: []string{"owner label is required"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := evaluator.Evaluate(ctx, &models.Policy{Content: tt.content}, tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.decision, result.Decision)
+			assert.Equal(t, tt.violations, result.Violations)
+		})
+	}
+}
+
+func TestRegoEvaluator_CompileError(t *testing.T) {
+	evaluator := NewRegoEvaluator()
+
+	_, err := evaluator.Evaluate(context.Background(), &models.Policy{Content: "package broken\n\nallow {"}, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to compile policy")
+}
+
+func TestPolicyService_TestPolicy_Embedded(t *testing.T) {
+	service, _ := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded})
+
+	policy := &models.Policy{Name: "Pod Security", Content: podSecurityPolicy}
+	require.NoError(t, service.CreatePolicy(policy, 1, 1))
+
+	evaluation, err := service.TestPolicy(policy.ID, map[string]interface{}{"kind": "Pod"}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, models.DecisionDeny, evaluation.Decision)
+	assert.JSONEq(t, `{"deny": ["pods must run as non-root"]}`, evaluation.Output)
+}
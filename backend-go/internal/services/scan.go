@@ -0,0 +1,214 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"niyama-backend/internal/models"
+)
+
+// ErrNoScanPolicies is returned when a scan's policy selection matches no policy
+var ErrNoScanPolicies = errors.New("no policies selected")
+
+// ScanSelection chooses the policies a scan evaluates. Without policy IDs the
+// organization's active Rego policies are used, narrowed by tags and category.
+type ScanSelection struct {
+	PolicyIDs []uint
+	Tags      []string
+	Category  string
+}
+
+// ScanViolation is a deny message of one policy
+type ScanViolation struct {
+	PolicyID uint   `json:"policy_id"`
+	Policy   string `json:"policy"`
+	Message  string `json:"message"`
+}
+
+// ScanFinding is the outcome of one scanned input against every selected policy
+type ScanFinding struct {
+	Address    string          `json:"address,omitempty"`  // Terraform resource address
+	Type       string          `json:"type,omitempty"`     // Terraform resource type
+	Action     string          `json:"action,omitempty"`   // Terraform change action
+	File       string          `json:"file,omitempty"`     // name of a scanned file
+	Document   *int            `json:"document,omitempty"` // index of the document within the file
+	Format     string          `json:"format,omitempty"`   // format the file was parsed as
+	Decision   string          `json:"decision"`
+	Violations []ScanViolation `json:"violations,omitempty"`
+	Waived     []ScanViolation `json:"waived,omitempty"` // violations waived by an exception
+	Errors     []string        `json:"errors,omitempty"`
+}
+
+// ScanPolicy identifies a policy evaluated by a scan
+type ScanPolicy struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// ScanSummary counts the findings of a scan
+type ScanSummary struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Errored int `json:"errored"`
+	Skipped int `json:"skipped"`
+}
+
+// ScanResult is the response of a scan. Passed is false when any finding was
+// denied or failed to evaluate.
+type ScanResult struct {
+	Passed   bool          `json:"passed"`
+	Policies []ScanPolicy  `json:"policies"`
+	Findings []ScanFinding `json:"findings"`
+	Summary  ScanSummary   `json:"summary"`
+}
+
+// scanPolicies loads the policies selected for a scan
+func (s *PolicyService) scanPolicies(selection ScanSelection, userID, orgID uint, userRole models.Role) ([]models.Policy, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var policies []models.Policy
+	if len(selection.PolicyIDs) > 0 {
+		for _, id := range selection.PolicyIDs {
+			policy, err := s.getTestablePolicy(id, userID, orgID, userRole)
+			if err != nil {
+				return nil, fmt.Errorf("policy %d: %v", id, err)
+			}
+			policies = append(policies, *policy)
+		}
+		return policies, nil
+	}
+
+	query := s.db.DB.Where("organization_id = ? AND status = ?", orgID, models.StatusActive)
+	query = s.filterPolicies(query, PolicyQuery{Tags: selection.Tags, Category: selection.Category})
+	var candidates []models.Policy
+	if err := query.Order("id").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		if isRegoPolicy(&candidates[i]) && s.canTestPolicy(&candidates[i], userID, userRole) {
+			policies = append(policies, candidates[i])
+		}
+	}
+	if len(policies) == 0 {
+		return nil, ErrNoScanPolicies
+	}
+	return policies, nil
+}
+
+// runScan evaluates every input against every policy with a bounded worker pool and
+// completes the matching findings. Evaluations are recorded with the scan source. A nil
+// input is not evaluated; its finding keeps the errors it was created with.
+func (s *PolicyService) runScan(policies []models.Policy, inputs []map[string]interface{}, findings []ScanFinding, userID uint) (*ScanResult, error) {
+	if limit := s.cfg.OPA.BatchMaxInputs; limit > 0 && len(inputs) > limit {
+		return nil, fmt.Errorf("%w: %d inputs, maximum is %d", ErrBatchTooLarge, len(inputs), limit)
+	}
+
+	shadows, exceptions := s.policyOverrides(policies)
+
+	workers := s.cfg.OPA.BatchWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	type scanJob struct{ input, policy int }
+	var mu sync.Mutex
+	var evaluations []*models.PolicyEvaluation
+	jobs := make(chan scanJob)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				policy := &policies[job.policy]
+				evaluation, result, err := s.evaluatePolicy(policy, shadows[policy.ID], exceptions[policy.ID], inputs[job.input], userID)
+
+				mu.Lock()
+				finding := &findings[job.input]
+				if err != nil {
+					finding.Errors = append(finding.Errors, fmt.Sprintf("%s: %v", policy.Name, err))
+				} else {
+					evaluation.Source = models.EvaluationSourceScan
+					evaluations = append(evaluations, evaluation)
+					violations := result.Violations
+					if result.Decision == models.DecisionDeny && len(violations) == 0 {
+						// e.g. an allow rule that did not hold
+						violations = []string{"denied"}
+					}
+					finding.Violations = append(finding.Violations, scanViolations(policy, violations)...)
+					finding.Waived = append(finding.Waived, scanViolations(policy, result.Waived)...)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := range inputs {
+		if inputs[i] == nil {
+			continue
+		}
+		for p := range policies {
+			jobs <- scanJob{input: i, policy: p}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := s.recordEvaluations(evaluations); err != nil {
+		return nil, err
+	}
+
+	result := &ScanResult{Passed: true, Findings: findings}
+	for _, policy := range policies {
+		result.Policies = append(result.Policies, ScanPolicy{ID: policy.ID, Name: policy.Name})
+	}
+	result.Summary.Total = len(findings)
+	for i := range findings {
+		finding := &findings[i]
+		// Workers finish in any order; report violations in policy order
+		sortScanViolations(finding.Violations, policies)
+		sortScanViolations(finding.Waived, policies)
+		sort.Strings(finding.Errors)
+
+		switch {
+		case len(finding.Errors) > 0:
+			finding.Decision = models.DecisionDeny
+			result.Summary.Errored++
+			result.Passed = false
+		case len(finding.Violations) > 0:
+			finding.Decision = models.DecisionDeny
+			result.Summary.Failed++
+			result.Passed = false
+		default:
+			finding.Decision = models.DecisionAllow
+			result.Summary.Passed++
+		}
+	}
+	return result, nil
+}
+
+// scanViolations attributes deny messages to a policy
+func scanViolations(policy *models.Policy, messages []string) []ScanViolation {
+	violations := make([]ScanViolation, 0, len(messages))
+	for _, message := range messages {
+		violations = append(violations, ScanViolation{PolicyID: policy.ID, Policy: policy.Name, Message: message})
+	}
+	return violations
+}
+
+// sortScanViolations orders violations by the position of their policy, keeping
+// the order of each policy's messages
+func sortScanViolations(violations []ScanViolation, policies []models.Policy) {
+	position := make(map[uint]int, len(policies))
+	for i, policy := range policies {
+		position[policy.ID] = i
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return position[violations[i].PolicyID] < position[violations[j].PolicyID]
+	})
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"niyama-backend/internal/models"
+)
+
+// ErrInvalidScanFile is returned when a file scan has nothing to scan
+var ErrInvalidScanFile = errors.New("invalid scan files")
+
+// ScanFile is a raw file submitted to a scan
+type ScanFile struct {
+	Name    string
+	Format  string // one of the ScanFormat constants; detected from the name or content when empty
+	Content []byte
+}
+
+// ScanFiles parses each file into its documents and evaluates every document against
+// the selected policies, in the manner of conftest: a YAML stream or a JSON array
+// yields one input per document, while HCL, TOML and Dockerfiles are a single input
+// each. Findings name the file and the index of the document within it. A file that
+// cannot be parsed is reported as an errored finding rather than failing the scan.
+func (s *PolicyService) ScanFiles(files []ScanFile, selection ScanSelection, userID, orgID uint, userRole models.Role) (*ScanResult, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: no files", ErrInvalidScanFile)
+	}
+	for i := range files {
+		format := strings.ToLower(files[i].Format)
+		if format == "" {
+			detected, err := detectScanFormat(files[i].Name, files[i].Content)
+			if err != nil {
+				return nil, err
+			}
+			format = detected
+		}
+		if _, ok := scanParsers[format]; !ok {
+			return nil, fmt.Errorf("%w: %q, expected one of yaml, json, hcl, toml or dockerfile", ErrUnknownScanFormat, format)
+		}
+		files[i].Format = format
+	}
+
+	policies, err := s.scanPolicies(selection, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []map[string]interface{}
+	var findings []ScanFinding
+	skipped := 0
+	for _, file := range files {
+		documents, err := scanParsers[file.Format](file.Name, file.Content)
+		if err != nil {
+			inputs = append(inputs, nil)
+			findings = append(findings, ScanFinding{
+				File:   file.Name,
+				Format: file.Format,
+				Errors: []string{fmt.Sprintf("parse %s: %v", file.Format, err)},
+			})
+			continue
+		}
+		if len(documents) == 0 {
+			skipped++
+			continue
+		}
+		for i, document := range documents {
+			index := i
+			inputs = append(inputs, document)
+			findings = append(findings, ScanFinding{File: file.Name, Document: &index, Format: file.Format})
+		}
+	}
+
+	result, err := s.runScan(policies, inputs, findings, userID)
+	if err != nil {
+		return nil, err
+	}
+	result.Summary.Skipped = skipped
+	return result, nil
+}
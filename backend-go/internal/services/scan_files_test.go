@@ -0,0 +1,104 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const dockerRootPolicy = `package docker.security
+
+deny[msg] {
+    input.instructions[i].Cmd == "user"
+    input.instructions[i].Value[0] == "root"
+    msg := sprintf("stage %d runs as root", [input.instructions[i].Stage])
+}`
+
+const publicBucketConfigPolicy = `package terraform.config
+
+deny[msg] {
+    bucket := input.resource.aws_s3_bucket[name][_]
+    bucket.acl == "public-read"
+    msg := sprintf("aws_s3_bucket.%s must not be public", [name])
+}`
+
+func readScanFile(t *testing.T, name string) ScanFile {
+	content, err := os.ReadFile(filepath.Join("testdata", "files", name))
+	require.NoError(t, err)
+	return ScanFile{Name: name, Content: content}
+}
+
+func TestPolicyService_ScanFiles(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded, BatchWorkers: 4})
+
+	policies := []models.Policy{
+		{Name: "Pod Security", Content: podSecurityPolicy, Status: models.StatusActive, Tags: []string{"config"}, OrganizationID: 1},
+		{Name: "Docker Root", Content: dockerRootPolicy, Status: models.StatusActive, Tags: []string{"config"}, OrganizationID: 1},
+		{Name: "Public Buckets", Content: publicBucketConfigPolicy, Status: models.StatusActive, Tags: []string{"config"}, OrganizationID: 1},
+	}
+	for i := range policies {
+		require.NoError(t, db.DB.Create(&policies[i]).Error)
+	}
+
+	files := []ScanFile{
+		readScanFile(t, "pods.yaml"),
+		readScanFile(t, "Dockerfile"),
+		readScanFile(t, "main.tf"),
+		readScanFile(t, "app.toml"),
+		{Name: "broken.yaml", Content: []byte("kind: [Pod")},
+		{Name: "empty.yaml", Content: []byte("---\n")},
+	}
+	result, err := service.ScanFiles(files, ScanSelection{Tags: []string{"config"}}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.False(t, result.Passed)
+	assert.Equal(t, ScanSummary{Total: 6, Passed: 2, Failed: 3, Errored: 1, Skipped: 1}, result.Summary)
+
+	document := func(i int) *int { return &i }
+	require.Len(t, result.Findings, 6)
+	assert.Equal(t, ScanFinding{File: "pods.yaml", Document: document(0), Format: ScanFormatYAML, Decision: models.DecisionAllow}, result.Findings[0])
+	assert.Equal(t, ScanFinding{
+		File:       "pods.yaml",
+		Document:   document(1),
+		Format:     ScanFormatYAML,
+		Decision:   models.DecisionDeny,
+		Violations: []ScanViolation{{PolicyID: policies[0].ID, Policy: "Pod Security", Message: "pods must run as non-root"}},
+	}, result.Findings[1])
+	assert.Equal(t, ScanFormatDockerfile, result.Findings[2].Format)
+	assert.Equal(t, []ScanViolation{{PolicyID: policies[1].ID, Policy: "Docker Root", Message: "stage 1 runs as root"}}, result.Findings[2].Violations)
+	assert.Equal(t, ScanFormatHCL, result.Findings[3].Format)
+	assert.Equal(t, []ScanViolation{{PolicyID: policies[2].ID, Policy: "Public Buckets", Message: "aws_s3_bucket.logs must not be public"}}, result.Findings[3].Violations)
+	assert.Equal(t, ScanFinding{File: "app.toml", Document: document(0), Format: ScanFormatTOML, Decision: models.DecisionAllow}, result.Findings[4])
+
+	broken := result.Findings[5]
+	assert.Equal(t, "broken.yaml", broken.File)
+	assert.Nil(t, broken.Document)
+	assert.Equal(t, models.DecisionDeny, broken.Decision)
+	require.Len(t, broken.Errors, 1)
+	assert.Contains(t, broken.Errors[0], "parse yaml")
+
+	var recorded int64
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Where("source = ?", models.EvaluationSourceScan).Count(&recorded).Error)
+	assert.Equal(t, int64(15), recorded)
+
+	// An explicit format overrides detection
+	result, err = service.ScanFiles([]ScanFile{{Name: "pod", Format: "JSON", Content: []byte(`{"kind": "Pod", "spec": {"securityContext": {"runAsNonRoot": true}}}`)}},
+		ScanSelection{PolicyIDs: []uint{policies[0].ID}}, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.True(t, result.Passed)
+	assert.Equal(t, ScanFormatJSON, result.Findings[0].Format)
+
+	_, err = service.ScanFiles([]ScanFile{{Name: "notes.txt", Content: []byte("kind: Pod")}}, ScanSelection{}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrUnknownScanFormat)
+
+	_, err = service.ScanFiles([]ScanFile{{Name: "pod", Format: "xml"}}, ScanSelection{}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrUnknownScanFormat)
+
+	_, err = service.ScanFiles(nil, ScanSelection{}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidScanFile)
+}
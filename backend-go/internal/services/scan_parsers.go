@@ -0,0 +1,347 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/tmccombs/hcl2json/convert"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of the files a scan parses into policy input
+const (
+	ScanFormatYAML       = "yaml"
+	ScanFormatJSON       = "json"
+	ScanFormatHCL        = "hcl"
+	ScanFormatTOML       = "toml"
+	ScanFormatDockerfile = "dockerfile"
+)
+
+// ErrUnknownScanFormat is returned when a file's format is neither given nor detectable
+var ErrUnknownScanFormat = errors.New("unknown file format")
+
+// scanParsers parse a file into its documents, each the input of one evaluation
+var scanParsers = map[string]func(name string, content []byte) ([]map[string]interface{}, error){
+	ScanFormatYAML:       parseYAMLDocuments,
+	ScanFormatJSON:       parseJSONDocuments,
+	ScanFormatHCL:        parseHCLDocument,
+	ScanFormatTOML:       parseTOMLDocument,
+	ScanFormatDockerfile: parseDockerfile,
+}
+
+// detectScanFormat picks a file's format from its name, then from its content
+func detectScanFormat(name string, content []byte) (string, error) {
+	base := strings.ToLower(filepath.Base(name))
+	switch filepath.Ext(base) {
+	case ".yaml", ".yml":
+		return ScanFormatYAML, nil
+	case ".json":
+		return ScanFormatJSON, nil
+	case ".hcl", ".tf", ".tfvars", ".nomad":
+		return ScanFormatHCL, nil
+	case ".toml":
+		return ScanFormatTOML, nil
+	case ".dockerfile", ".containerfile":
+		return ScanFormatDockerfile, nil
+	}
+	if base == "dockerfile" || base == "containerfile" || strings.HasPrefix(base, "dockerfile.") {
+		return ScanFormatDockerfile, nil
+	}
+
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return ScanFormatJSON, nil
+	}
+	return "", fmt.Errorf("%w: %s, pass the format explicitly", ErrUnknownScanFormat, name)
+}
+
+// parseYAMLDocuments reads every document of a multi-document YAML stream. Empty
+// documents are skipped.
+func parseYAMLDocuments(name string, content []byte) ([]map[string]interface{}, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	var documents []map[string]interface{}
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		if value == nil {
+			continue
+		}
+		document, ok := normalizeJSON(yamlToJSON(value)).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("document %d is not a mapping", len(documents))
+		}
+		documents = append(documents, document)
+	}
+}
+
+// yamlToJSON converts mappings with non-string keys, which JSON cannot represent
+func yamlToJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, element := range v {
+			v[key] = yamlToJSON(element)
+		}
+		return v
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			converted[fmt.Sprint(key)] = yamlToJSON(element)
+		}
+		return converted
+	case []interface{}:
+		for i, element := range v {
+			v[i] = yamlToJSON(element)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// parseJSONDocuments reads a JSON object, or an array of objects as one document each
+func parseJSONDocuments(name string, content []byte) ([]map[string]interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(content, &value); err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		documents := make([]map[string]interface{}, 0, len(v))
+		for i, element := range v {
+			document, ok := element.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("element %d is not an object", i)
+			}
+			documents = append(documents, document)
+		}
+		return documents, nil
+	default:
+		return nil, fmt.Errorf("expected an object or an array of objects")
+	}
+}
+
+// parseHCLDocument converts an HCL2 file, such as Terraform configuration, to JSON.
+// Blocks become nested objects keyed by type and labels, e.g.
+// input.resource.aws_s3_bucket.logs, and expressions are kept as "${...}" strings.
+func parseHCLDocument(name string, content []byte) ([]map[string]interface{}, error) {
+	converted, err := convert.Bytes(content, name, convert.Options{})
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(converted, &document); err != nil {
+		return nil, err
+	}
+	return []map[string]interface{}{document}, nil
+}
+
+// parseTOMLDocument reads a TOML file; dates and times become strings
+func parseTOMLDocument(name string, content []byte) ([]map[string]interface{}, error) {
+	var document map[string]interface{}
+	if err := toml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	normalized, _ := normalizeJSON(document).(map[string]interface{})
+	return []map[string]interface{}{normalized}, nil
+}
+
+// DockerfileInstruction is one instruction of a Dockerfile, in the shape conftest uses
+type DockerfileInstruction struct {
+	Cmd      string   `json:"Cmd"` // lower case, e.g. "from"
+	SubCmd   string   `json:"SubCmd"`
+	Flags    []string `json:"Flags"`
+	Value    []string `json:"Value"`
+	Original string   `json:"Original"`
+	JSON     bool     `json:"JSON"` // exec form, e.g. CMD ["nginx", "-g", "daemon off;"]
+	Stage    int      `json:"Stage"`
+}
+
+var (
+	// dockerfileDirective matches a parser directive such as # escape=`
+	dockerfileDirective = regexp.MustCompile(`^#\s*([a-zA-Z][a-zA-Z0-9_-]*)\s*=\s*(.*?)\s*$`)
+	// dockerfileHeredoc matches the start of a heredoc such as <<EOF, <<-EOF or <<"EOF"
+	dockerfileHeredoc = regexp.MustCompile(`<<-?["']?[A-Za-z_]`)
+)
+
+// parseDockerfile reads a Dockerfile into {"instructions": [...]}. Comments and
+// parser directives are dropped and continuation lines are joined; each FROM starts
+// a new stage. Heredocs and an escape directive changing the escape character are
+// rejected rather than misread.
+func parseDockerfile(name string, content []byte) ([]map[string]interface{}, error) {
+	instructions := []DockerfileInstruction{}
+	stage, froms := 0, 0
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var logical strings.Builder
+	lineNumber := 0
+	// Parser directives are only recognized before any blank line, comment or instruction
+	directives := true
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if directives {
+			match := dockerfileDirective.FindStringSubmatch(line)
+			directives = match != nil
+			if match != nil && strings.EqualFold(match[1], "escape") && match[2] != "\\" {
+				return nil, fmt.Errorf("line %d: escape directive %q is not supported", lineNumber, match[2])
+			}
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			logical.WriteString(strings.TrimSpace(strings.TrimSuffix(line, "\\")))
+			logical.WriteString(" ")
+			continue
+		}
+		logical.WriteString(line)
+
+		instruction, err := parseDockerfileInstruction(logical.String())
+		logical.Reset()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		if instruction.Cmd == "from" {
+			// Instructions before the first FROM, such as ARG, belong to stage 0
+			if froms > 0 {
+				stage++
+			}
+			froms++
+		}
+		instruction.Stage = stage
+		instructions = append(instructions, instruction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if logical.Len() > 0 {
+		return nil, fmt.Errorf("line %d: unterminated line continuation", lineNumber)
+	}
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("no instructions")
+	}
+
+	document, _ := normalizeJSON(map[string]interface{}{"instructions": instructions}).(map[string]interface{})
+	return []map[string]interface{}{document}, nil
+}
+
+func parseDockerfileInstruction(line string) (DockerfileInstruction, error) {
+	instruction := DockerfileInstruction{Original: line, Flags: []string{}, Value: []string{}}
+	keyword, rest := cutSpace(line)
+	instruction.Cmd = strings.ToLower(keyword)
+
+	// ONBUILD wraps another instruction
+	if instruction.Cmd == "onbuild" {
+		inner, err := parseDockerfileInstruction(rest)
+		if err != nil {
+			return instruction, err
+		}
+		instruction.SubCmd = inner.Cmd
+		instruction.Flags = inner.Flags
+		instruction.Value = inner.Value
+		instruction.JSON = inner.JSON
+		return instruction, nil
+	}
+
+	for strings.HasPrefix(rest, "--") {
+		var flag string
+		flag, rest = cutSpace(rest)
+		instruction.Flags = append(instruction.Flags, flag)
+	}
+
+	switch instruction.Cmd {
+	case "run", "copy", "add":
+		if dockerfileHeredoc.MatchString(rest) {
+			return instruction, fmt.Errorf("heredocs are not supported in %s", strings.ToUpper(instruction.Cmd))
+		}
+	}
+
+	if strings.HasPrefix(rest, "[") {
+		var exec []string
+		if err := json.Unmarshal([]byte(rest), &exec); err == nil {
+			instruction.Value = exec
+			instruction.JSON = true
+			return instruction, nil
+		}
+	}
+
+	switch instruction.Cmd {
+	case "run", "cmd", "entrypoint", "shell":
+		// Shell form is a single command string
+		if rest != "" {
+			instruction.Value = []string{rest}
+		}
+	case "env", "label":
+		instruction.Value = dockerfileKeyValues(rest)
+	default:
+		instruction.Value = strings.Fields(rest)
+	}
+	return instruction, nil
+}
+
+// dockerfileKeyValues splits `KEY=value OTHER="quoted value"` or the legacy
+// `KEY value` form into alternating keys and values
+func dockerfileKeyValues(rest string) []string {
+	if key, value := cutSpace(rest); value != "" && !strings.Contains(key, "=") {
+		return []string{key, value}
+	}
+
+	var values []string
+	var current strings.Builder
+	quote := rune(0)
+	flush := func() {
+		if current.Len() == 0 {
+			return
+		}
+		key, value, _ := strings.Cut(current.String(), "=")
+		values = append(values, key, value)
+		current.Reset()
+	}
+	for _, r := range rest {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && unicode.IsSpace(r):
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+	return values
+}
+
+// cutSpace splits s around its first run of whitespace
+func cutSpace(s string) (before, after string) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
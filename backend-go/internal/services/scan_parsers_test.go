@@ -0,0 +1,152 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectScanFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		format  string
+	}{
+		{name: "k8s/deploy.yml", format: ScanFormatYAML},
+		{name: "values.YAML", format: ScanFormatYAML},
+		{name: "package.json", format: ScanFormatJSON},
+		{name: "main.tf", format: ScanFormatHCL},
+		{name: "prod.tfvars", format: ScanFormatHCL},
+		{name: "pyproject.toml", format: ScanFormatTOML},
+		{name: "Dockerfile", format: ScanFormatDockerfile},
+		{name: "Dockerfile.dev", format: ScanFormatDockerfile},
+		{name: "api.dockerfile", format: ScanFormatDockerfile},
+		{name: "input", content: ` {"kind": "Pod"}`, format: ScanFormatJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := detectScanFormat(tt.name, []byte(tt.content))
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, format)
+		})
+	}
+
+	_, err := detectScanFormat("notes.txt", []byte("kind: Pod"))
+	assert.ErrorIs(t, err, ErrUnknownScanFormat)
+}
+
+func TestParseYAMLDocuments(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "files", "pods.yaml"))
+	require.NoError(t, err)
+
+	documents, err := parseYAMLDocuments("pods.yaml", content)
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	assert.Equal(t, "api", documents[0]["metadata"].(map[string]interface{})["name"])
+	assert.Equal(t, "debug", documents[1]["metadata"].(map[string]interface{})["name"])
+
+	// Non-string keys become strings, numbers decode as JSON numbers
+	documents, err = parseYAMLDocuments("ports.yaml", []byte("ports:\n  80: http\nreplicas: 3\n"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"ports": map[string]interface{}{"80": "http"}, "replicas": float64(3)}, documents[0])
+
+	_, err = parseYAMLDocuments("list.yaml", []byte("- a\n- b\n"))
+	assert.Error(t, err)
+}
+
+func TestParseJSONDocuments(t *testing.T) {
+	documents, err := parseJSONDocuments("pods.json", []byte(`[{"kind": "Pod"}, {"kind": "Service"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"kind": "Pod"}, {"kind": "Service"}}, documents)
+
+	_, err = parseJSONDocuments("names.json", []byte(`["a"]`))
+	assert.Error(t, err)
+}
+
+func TestParseHCLDocument(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "files", "main.tf"))
+	require.NoError(t, err)
+
+	documents, err := parseHCLDocument("main.tf", content)
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	buckets := documents[0]["resource"].(map[string]interface{})["aws_s3_bucket"].(map[string]interface{})
+	logs := buckets["logs"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "public-read", logs["acl"])
+	assert.Equal(t, map[string]interface{}{"team": "platform"}, logs["tags"])
+
+	_, err = parseHCLDocument("broken.tf", []byte(`resource "a" {`))
+	assert.Error(t, err)
+}
+
+func TestParseTOMLDocument(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "files", "app.toml"))
+	require.NoError(t, err)
+
+	documents, err := parseTOMLDocument("app.toml", content)
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{
+		"title":  "api",
+		"server": map[string]interface{}{"port": float64(8080), "tls": false},
+	}}, documents)
+}
+
+func TestParseDockerfile(t *testing.T) {
+	content, err := os.ReadFile(filepath.Join("testdata", "files", "Dockerfile"))
+	require.NoError(t, err)
+
+	documents, err := parseDockerfile("Dockerfile", content)
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	instructions := documents[0]["instructions"].([]interface{})
+	require.Len(t, instructions, 10)
+
+	instruction := func(i int) map[string]interface{} {
+		return instructions[i].(map[string]interface{})
+	}
+	assert.Equal(t, "arg", instruction(0)["Cmd"])
+	assert.Equal(t, float64(0), instruction(0)["Stage"])
+	assert.Equal(t, []interface{}{"golang:${VERSION}", "AS", "build"}, instruction(1)["Value"])
+	assert.Equal(t, []interface{}{"--chown=app:app"}, instruction(3)["Flags"])
+	assert.Equal(t, []interface{}{"go build -o /out/api ./cmd/api"}, instruction(4)["Value"])
+	assert.Equal(t, "from", instruction(5)["Cmd"])
+	assert.Equal(t, float64(1), instruction(5)["Stage"])
+	assert.Equal(t, []interface{}{"PORT", "8080", "MODE", "release build"}, instruction(6)["Value"])
+	assert.Equal(t, []interface{}{"root"}, instruction(8)["Value"])
+	assert.Equal(t, true, instruction(9)["JSON"])
+	assert.Equal(t, []interface{}{"api", "--port", "8080"}, instruction(9)["Value"])
+
+	documents, err = parseDockerfile("Dockerfile", []byte("FROM alpine\nONBUILD RUN make\n"))
+	require.NoError(t, err)
+	onbuild := documents[0]["instructions"].([]interface{})[1].(map[string]interface{})
+	assert.Equal(t, "onbuild", onbuild["Cmd"])
+	assert.Equal(t, "run", onbuild["SubCmd"])
+	assert.Equal(t, []interface{}{"make"}, onbuild["Value"])
+
+	// Keywords and flags may be separated by any whitespace
+	documents, err = parseDockerfile("Dockerfile", []byte("FROM\talpine\nCOPY --chown=app\t. /app\nENV\tMODE\trelease\n"))
+	require.NoError(t, err)
+	instructions = documents[0]["instructions"].([]interface{})
+	assert.Equal(t, "from", instructions[0].(map[string]interface{})["Cmd"])
+	assert.Equal(t, []interface{}{"--chown=app"}, instructions[1].(map[string]interface{})["Flags"])
+	assert.Equal(t, []interface{}{".", "/app"}, instructions[1].(map[string]interface{})["Value"])
+	assert.Equal(t, []interface{}{"MODE", "release"}, instructions[2].(map[string]interface{})["Value"])
+
+	_, err = parseDockerfile("Dockerfile", []byte("# only a comment\n"))
+	assert.Error(t, err)
+
+	_, err = parseDockerfile("Dockerfile", []byte("FROM alpine\nRUN <<EOF\napk add curl\nEOF\n"))
+	assert.ErrorContains(t, err, "heredocs are not supported")
+
+	_, err = parseDockerfile("Dockerfile", []byte("# escape=`\nFROM alpine\n"))
+	assert.ErrorContains(t, err, "escape directive")
+
+	// Only leading comments are directives; the default escape character is accepted
+	_, err = parseDockerfile("Dockerfile", []byte("# syntax=docker/dockerfile:1\n# escape=\\\nFROM alpine\n"))
+	assert.NoError(t, err)
+	_, err = parseDockerfile("Dockerfile", []byte("FROM alpine\n# escape=`\n"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"niyama-backend/internal/models"
+)
+
+// ErrInvalidTerraformPlan is returned for documents that are not `terraform show -json` plans
+var ErrInvalidTerraformPlan = errors.New("invalid terraform plan")
+
+// TerraformPlan is the part of the `terraform show -json` plan representation a scan reads
+type TerraformPlan struct {
+	FormatVersion   string                    `json:"format_version"`
+	ResourceChanges []TerraformResourceChange `json:"resource_changes"`
+}
+
+// TerraformResourceChange is one entry of a plan's resource_changes
+type TerraformResourceChange struct {
+	Address string `json:"address"`
+	Mode    string `json:"mode"`
+	Type    string `json:"type"`
+	Change  struct {
+		Actions []string `json:"actions"`
+	} `json:"change"`
+}
+
+// TerraformScanOptions controls a Terraform plan scan
+type TerraformScanOptions struct {
+	ScanSelection
+	IncludePlan bool // also evaluate the whole plan, reported with the address "plan"
+	IncludeNoOp bool // also evaluate resources the plan leaves unchanged
+}
+
+// ScanTerraformPlan evaluates the resource changes of a `terraform show -json` plan
+// against the selected policies. Each resource_changes entry is the input of one
+// evaluation, so policies read input.type, input.change.actions and
+// input.change.after. Data sources and, unless requested, unchanged resources are
+// skipped.
+func (s *PolicyService) ScanTerraformPlan(data []byte, options TerraformScanOptions, userID, orgID uint, userRole models.Role) (*ScanResult, error) {
+	var parsed TerraformPlan
+	var plan map[string]interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTerraformPlan, err)
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTerraformPlan, err)
+	}
+	if parsed.FormatVersion == "" {
+		return nil, fmt.Errorf("%w: format_version is missing, pass the output of terraform show -json", ErrInvalidTerraformPlan)
+	}
+
+	policies, err := s.scanPolicies(options.ScanSelection, userID, orgID, userRole)
+	if err != nil {
+		return nil, err
+	}
+
+	changes, _ := plan["resource_changes"].([]interface{})
+	var inputs []map[string]interface{}
+	var findings []ScanFinding
+	skipped := 0
+	for i, change := range parsed.ResourceChanges {
+		action := terraformAction(change.Change.Actions)
+		if change.Mode == "data" || (action == "no-op" && !options.IncludeNoOp) {
+			skipped++
+			continue
+		}
+		input, ok := changes[i].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: resource_changes[%d] is not an object", ErrInvalidTerraformPlan, i)
+		}
+		inputs = append(inputs, input)
+		findings = append(findings, ScanFinding{Address: change.Address, Type: change.Type, Action: action})
+	}
+	if options.IncludePlan {
+		inputs = append(inputs, plan)
+		findings = append(findings, ScanFinding{Address: "plan"})
+	}
+
+	result, err := s.runScan(policies, inputs, findings, userID)
+	if err != nil {
+		return nil, err
+	}
+	result.Summary.Skipped = skipped
+	return result, nil
+}
+
+// terraformAction names the change actions of a resource, e.g. ["delete", "create"] is a replace
+func terraformAction(actions []string) string {
+	switch strings.Join(actions, ",") {
+	case "":
+		return "no-op"
+	case "delete,create", "create,delete":
+		return "replace"
+	default:
+		return strings.Join(actions, "-")
+	}
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"niyama-backend/internal/config"
+	"niyama-backend/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const publicBucketPolicy = `package terraform.s3
+
+deny[msg] {
+    input.type == "aws_s3_bucket_acl"
+    input.change.after.acl == "public-read"
+    msg := sprintf("%s must not be public", [input.address])
+}`
+
+const destroyDatabasePolicy = `package terraform.plan
+
+deny[msg] {
+    change := input.resource_changes[_]
+    change.type == "aws_db_instance"
+    change.change.actions[_] == "delete"
+    msg := sprintf("%s would be destroyed", [change.address])
+}`
+
+func TestPolicyService_ScanTerraformPlan(t *testing.T) {
+	service, db := newTestPolicyService(t, config.OPAConfig{Mode: OPAModeEmbedded, BatchWorkers: 4})
+
+	policies := []models.Policy{
+		{Name: "Public Buckets", Content: publicBucketPolicy, Status: models.StatusActive, Tags: []string{"terraform"}, OrganizationID: 1},
+		{Name: "Database Destroy", Content: destroyDatabasePolicy, Status: models.StatusActive, Tags: []string{"terraform"}, OrganizationID: 1},
+		{Name: "Pod Security", Content: podSecurityPolicy, Status: models.StatusActive, Tags: []string{"kubernetes"}, OrganizationID: 1},
+	}
+	for i := range policies {
+		require.NoError(t, db.DB.Create(&policies[i]).Error)
+	}
+
+	plan, err := os.ReadFile(filepath.Join("testdata", "terraform", "plan.json"))
+	require.NoError(t, err)
+
+	options := TerraformScanOptions{ScanSelection: ScanSelection{Tags: []string{"terraform"}}, IncludePlan: true}
+	result, err := service.ScanTerraformPlan(plan, options, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.False(t, result.Passed)
+	assert.Equal(t, []ScanPolicy{{ID: policies[0].ID, Name: "Public Buckets"}, {ID: policies[1].ID, Name: "Database Destroy"}}, result.Policies)
+	assert.Equal(t, ScanSummary{Total: 4, Passed: 2, Failed: 2, Skipped: 2}, result.Summary)
+
+	require.Len(t, result.Findings, 4)
+	assert.Equal(t, ScanFinding{Address: "aws_s3_bucket.logs", Type: "aws_s3_bucket", Action: "create", Decision: models.DecisionAllow}, result.Findings[0])
+	assert.Equal(t, ScanFinding{
+		Address:    "aws_s3_bucket_acl.logs",
+		Type:       "aws_s3_bucket_acl",
+		Action:     "create",
+		Decision:   models.DecisionDeny,
+		Violations: []ScanViolation{{PolicyID: policies[0].ID, Policy: "Public Buckets", Message: "aws_s3_bucket_acl.logs must not be public"}},
+	}, result.Findings[1])
+	assert.Equal(t, "replace", result.Findings[2].Action)
+	assert.Equal(t, models.DecisionAllow, result.Findings[2].Decision)
+	assert.Equal(t, "plan", result.Findings[3].Address)
+	assert.Equal(t, []ScanViolation{{PolicyID: policies[1].ID, Policy: "Database Destroy", Message: "aws_db_instance.main would be destroyed"}}, result.Findings[3].Violations)
+
+	var recorded int64
+	require.NoError(t, db.DB.Model(&models.PolicyEvaluation{}).Where("source = ?", models.EvaluationSourceScan).Count(&recorded).Error)
+	assert.Equal(t, int64(8), recorded)
+
+	// Selecting policies by ID, unchanged resources included
+	options = TerraformScanOptions{ScanSelection: ScanSelection{PolicyIDs: []uint{policies[1].ID}}, IncludeNoOp: true}
+	result, err = service.ScanTerraformPlan(plan, options, 1, 1, models.RoleAdmin)
+	require.NoError(t, err)
+	assert.True(t, result.Passed)
+	assert.Equal(t, ScanSummary{Total: 4, Passed: 4, Skipped: 1}, result.Summary)
+
+	_, err = service.ScanTerraformPlan(plan, TerraformScanOptions{ScanSelection: ScanSelection{Tags: []string{"missing"}}}, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrNoScanPolicies)
+	_, err = service.ScanTerraformPlan([]byte(`{"kind": "Pod"}`), options, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTerraformPlan)
+	_, err = service.ScanTerraformPlan([]byte(`{"format_version": "1.2", "resource_changes": [null]}`), options, 1, 1, models.RoleAdmin)
+	assert.ErrorIs(t, err, ErrInvalidTerraformPlan)
+}
+
+func TestTerraformAction(t *testing.T) {
+	assert.Equal(t, "create", terraformAction([]string{"create"}))
+	assert.Equal(t, "replace", terraformAction([]string{"delete", "create"}))
+	assert.Equal(t, "replace", terraformAction([]string{"create", "delete"}))
+	assert.Equal(t, "no-op", terraformAction([]string{"no-op"}))
+	assert.Equal(t, "no-op", terraformAction(nil))
+}
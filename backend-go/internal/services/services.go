@@ -6,23 +6,27 @@ import (
 )
 
 type Services struct {
-	Auth      *AuthService
-	Policy    *PolicyService
-	Template  *TemplateService
-	Compliance *ComplianceService
-	AI        *AIService
-	Monitoring *MonitoringService
-	User      *UserService
+	Auth        *AuthService
+	Policy      *PolicyService
+	Template    *TemplateService
+	Compliance  *ComplianceService
+	AI          *AIService
+	Monitoring  *MonitoringService
+	User        *UserService
+	Bundle      *BundleService
+	DecisionLog *DecisionLogService
 }
 
 func NewServices(db *database.Database, cfg *config.Config) *Services {
 	return &Services{
-		Auth:      NewAuthService(db, cfg),
-		Policy:    NewPolicyService(db, cfg),
-		Template:  NewTemplateService(db, cfg),
-		Compliance: NewComplianceService(db, cfg),
-		AI:        NewAIService(db, cfg),
-		Monitoring: NewMonitoringService(db, cfg),
-		User:      NewUserService(db, cfg),
+		Auth:        NewAuthService(db, cfg),
+		Policy:      NewPolicyService(db, cfg),
+		Template:    NewTemplateService(db, cfg),
+		Compliance:  NewComplianceService(db, cfg),
+		AI:          NewAIService(db, cfg),
+		Monitoring:  NewMonitoringService(db, cfg),
+		User:        NewUserService(db, cfg),
+		Bundle:      NewBundleService(db, cfg),
+		DecisionLog: NewDecisionLogService(db, cfg),
 	}
 }
@@ -109,6 +109,38 @@ has_resource_limits(container) if {
     container.resources.limits.memory
     container.resources.requests.cpu
     container.resources.requests.memory
+}`,
+		},
+		{
+			ID:          "4",
+			Name:        "Default Pod Security and Resources",
+			Description: "Mutates pods to run as non-root and gives containers without resources the requests and limits the Resource Limits Policy requires.",
+			Framework:   "HIPAA",
+			Language:    "Rego",
+			Content: `package policy.pod_defaults
+
+import rego.v1
+
+# Default to non-root when the pod has no security context
+patch contains op if {
+    input.kind == "Pod"
+    not input.spec.securityContext
+    op := {"op": "add", "path": "/spec/securityContext", "value": {"runAsNonRoot": true}}
+}
+
+# Add default requests and limits to containers that declare none
+patch contains op if {
+    input.kind == "Pod"
+    some i, container in input.spec.containers
+    not container.resources
+    op := {
+        "op": "add",
+        "path": sprintf("/spec/containers/%d/resources", [i]),
+        "value": {
+            "limits": {"cpu": "500m", "memory": "512Mi"},
+            "requests": {"cpu": "250m", "memory": "256Mi"},
+        },
+    }
 }`,
 		},
 	}
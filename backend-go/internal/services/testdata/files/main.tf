@@ -0,0 +1,8 @@
+resource "aws_s3_bucket" "logs" {
+  bucket = "example-logs"
+  acl    = "public-read"
+
+  tags = {
+    team = "platform"
+  }
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"niyama-backend/internal/config"
 	"niyama-backend/internal/database"
@@ -34,6 +40,16 @@ func main() {
 	// Initialize services
 	services := services.NewServices(db, cfg)
 
+	// Agents would reject bundles signed with a key they were not given
+	if err := services.Bundle.SigningError(); err != nil {
+		log.Fatalf("Bundle signing misconfigured: %v", err)
+	}
+
+	// Replays of a previous run are not resumed
+	if err := services.Policy.FailInterruptedReplays(); err != nil {
+		log.Printf("Warning: failed to mark interrupted replays: %v", err)
+	}
+
 	// Initialize handlers
 	handlers := handlers.NewHandlers(services)
 
@@ -50,11 +66,56 @@ func main() {
 	log.Printf("📚 API Documentation available at http://localhost:%s/docs", port)
 	log.Printf("🏥 Health check available at http://localhost:%s/health", port)
 
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Serve until interrupted, then let requests and background jobs finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Poll external data sources into the policy data store until shutdown
+	polling := make(chan struct{})
+	go func() {
+		services.Policy.PollDataSources(ctx)
+		close(polling)
+	}()
+
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		var err error
+		// Kubernetes only calls admission webhooks over HTTPS
+		if cfg.TLS.Enabled() {
+			log.Printf("🔒 Serving HTTPS with certificate %s", cfg.TLS.CertFile)
+			err = server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: failed to shut down server: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		services.Policy.Wait()
+		<-polling
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		log.Println("Warning: background jobs were still running at shutdown")
 	}
 }
 
+// shutdownTimeout bounds how long shutdown waits for requests and background jobs
+const shutdownTimeout = 30 * time.Second
+
 func setupRouter(handlers *handlers.Handlers, cfg *config.Config, db *database.Database) *gin.Engine {
 	// Set Gin mode
 	if cfg.Environment == "production" {
@@ -90,13 +151,89 @@ func setupRouter(handlers *handlers.Handlers, cfg *config.Config, db *database.D
 		policies := api.Group("/policies")
 		{
 			policies.GET("", handlers.Policy.GetPolicies)
+			policies.GET("/graph", handlers.Policy.GetDependencyGraph)
 			policies.GET("/:id", handlers.Policy.GetPolicy)
 			policies.POST("", handlers.Policy.CreatePolicy)
 			policies.PUT("/:id", handlers.Policy.UpdatePolicy)
 			policies.DELETE("/:id", handlers.Policy.DeletePolicy)
 			policies.POST("/:id/evaluate", handlers.Policy.EvaluatePolicy)
+			policies.POST("/:id/evaluate/batch", handlers.Policy.EvaluatePolicyBatch)
+			policies.POST("/:id/mutate", handlers.Policy.MutatePolicy)
+			policies.GET("/:id/evaluations", handlers.Policy.GetEvaluations)
+			policies.GET("/:id/tests", handlers.Policy.GetTestCases)
+			policies.POST("/:id/tests", handlers.Policy.CreateTestCase)
+			policies.POST("/:id/tests/run", handlers.Policy.RunTests)
+			policies.PUT("/:id/tests/:testId", handlers.Policy.UpdateTestCase)
+			policies.DELETE("/:id/tests/:testId", handlers.Policy.DeleteTestCase)
+			policies.GET("/:id/versions", handlers.Policy.GetVersions)
+			policies.GET("/:id/versions/:version", handlers.Policy.GetVersion)
+			policies.POST("/:id/versions/:version/rollback", handlers.Policy.RollbackPolicy)
+			policies.GET("/:id/diff", handlers.Policy.DiffVersions)
+			policies.POST("/:id/submit", handlers.Policy.SubmitPolicy)
+			policies.GET("/:id/reviews", handlers.Policy.GetReviews)
+			policies.POST("/:id/reviews", handlers.Policy.ReviewPolicy)
+			policies.GET("/:id/dependents", handlers.Policy.GetDependents)
+			policies.GET("/:id/exports", handlers.Policy.GetPolicyExports)
+			policies.GET("/:id/shadow", handlers.Policy.GetShadow)
+			policies.PUT("/:id/shadow", handlers.Policy.StartShadow)
+			policies.DELETE("/:id/shadow", handlers.Policy.StopShadow)
+			policies.GET("/:id/shadow/report", handlers.Policy.GetShadowReport)
+			policies.POST("/:id/replay", handlers.Policy.StartReplay)
+			policies.GET("/:id/replays", handlers.Policy.GetReplays)
+			policies.GET("/:id/replays/:replayId", handlers.Policy.GetReplay)
+			policies.GET("/:id/replays/:replayId/flips", handlers.Policy.GetReplayFlips)
+			policies.GET("/:id/exceptions", handlers.Policy.GetExceptions)
+			policies.POST("/:id/exceptions", handlers.Policy.CreateException)
+			policies.POST("/:id/exceptions/:exceptionId/approve", handlers.Policy.ApproveException)
+			policies.DELETE("/:id/exceptions/:exceptionId", handlers.Policy.RevokeException)
 			policies.POST("/save", handlers.Policy.SavePolicy)
 			policies.POST("/test", handlers.Policy.TestPolicy)
+			policies.POST("/validate", handlers.Policy.ValidatePolicy)
+		}
+
+		// Policy exception routes (no auth required for development)
+		exceptions := api.Group("/exceptions")
+		{
+			exceptions.GET("/expiring", handlers.Policy.GetExpiringExceptions)
+		}
+
+		// Policy source routes (no auth required for development)
+		sources := api.Group("/policy-sources")
+		{
+			sources.GET("", handlers.Policy.GetPolicySources)
+			sources.POST("", handlers.Policy.CreatePolicySource)
+			sources.GET("/:id", handlers.Policy.GetPolicySource)
+			sources.PUT("/:id", handlers.Policy.UpdatePolicySource)
+			sources.DELETE("/:id", handlers.Policy.DeletePolicySource)
+			sources.POST("/:id/sync", handlers.Policy.SyncPolicySource)
+			sources.GET("/:id/syncs", handlers.Policy.GetPolicySyncs)
+		}
+
+		// Policy data routes (no auth required for development)
+		data := api.Group("/data")
+		{
+			data.GET("/documents", handlers.Policy.GetDataDocuments)
+			data.GET("/documents/*path", handlers.Policy.GetDataDocument)
+			data.PUT("/documents/*path", handlers.Policy.PutDataDocument)
+			data.DELETE("/documents/*path", handlers.Policy.DeleteDataDocument)
+			data.GET("/versions/*path", handlers.Policy.GetDataVersions)
+			data.GET("/schemas", handlers.Policy.GetDataSchemas)
+			data.PUT("/schemas/*path", handlers.Policy.PutDataSchema)
+			data.DELETE("/schemas/*path", handlers.Policy.DeleteDataSchema)
+			data.GET("/sources", handlers.Policy.GetDataSources)
+			data.POST("/sources", handlers.Policy.CreateDataSource)
+			data.GET("/sources/:id", handlers.Policy.GetDataSource)
+			data.PUT("/sources/:id", handlers.Policy.UpdateDataSource)
+			data.DELETE("/sources/:id", handlers.Policy.DeleteDataSource)
+			data.POST("/sources/:id/fetch", handlers.Policy.FetchDataSource)
+			data.GET("/sources/:id/fetches", handlers.Policy.GetDataSourceFetches)
+		}
+
+		// Scan routes (no auth required for development)
+		scans := api.Group("/scans")
+		{
+			scans.POST("/terraform", handlers.Policy.ScanTerraformPlan)
+			scans.POST("/files", handlers.Policy.ScanFiles)
 		}
 
 		// Template routes (no auth required for development)
@@ -140,6 +277,26 @@ func setupRouter(handlers *handlers.Handlers, cfg *config.Config, db *database.D
 			users.PUT("/:id", handlers.User.UpdateUser)
 			users.DELETE("/:id", handlers.User.DeleteUser)
 		}
+
+		// OPA bundle routes (polled by OPA agents)
+		bundles := api.Group("/bundles")
+		{
+			bundles.GET("/keys", handlers.Bundle.GetPublicKeys)
+			bundles.GET("/:org", handlers.Bundle.GetBundle)
+		}
+
+		// Kubernetes admission webhook routes (called by the API server)
+		admission := api.Group("/admission")
+		{
+			admission.POST("/:org/validate", handlers.Policy.ValidateAdmission)
+			admission.POST("/:org/mutate", handlers.Policy.MutateAdmission)
+		}
+
+		// OPA decision log routes (uploaded by OPA agents)
+		logs := api.Group("/logs")
+		{
+			logs.POST("/:org", handlers.DecisionLog.IngestDecisionLogs)
+		}
 	}
 
 	// 404 handler